@@ -1,21 +1,52 @@
 package main
 
 import (
+	"errors"
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 func startCmd() *cobra.Command {
-	var timeout int32
-	var profile string
+	opts := startOptions{}
 	cmd := &cobra.Command{
 		Use:     "start \"command to run\"",
+		Aliases: []string{"run"},
 		Short:   "start a new job",
-		Example: "client --certs ... start --timeout 1 cp /path/to/source /path/to/destination",
-		Args:    cobra.MinimumNArgs(1),
-		Run:     startHandler(&timeout, &profile),
+		Example: "client --certs ... start --timeout 1 cp /path/to/source /path/to/destination\n" +
+			"client --certs ... start --script build.sh\n" +
+			"client --certs ... start - < build.sh",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if opts.script != "" {
+				if len(args) > 0 {
+					return errors.New("no arguments are accepted with --script")
+				}
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		Run: startHandler(&opts),
 	}
-	cmd.Flags().Int32VarP(&timeout, "timeout", "t", 0, "[Optional] Timeout in seconds (default no timeout)")
-	cmd.Flags().StringVarP(&profile, "profile", "p", "default", "[Optional] Resource profile for the job")
+	cmd.Flags().StringVarP(&opts.timeout, "timeout", "t", "0", "[Optional] Timeout as a duration, e.g. 90s, 5m or 1h30m, or in seconds (default no timeout)")
+	cmd.Flags().StringVarP(&opts.profile, "profile", "p", "default", "[Optional] Resource profile for the job")
+	cmd.Flags().StringVarP(&opts.name, "name", "n", "", "[Optional] Name for the job that can be used in place of the job ID")
+	cmd.Flags().BoolVarP(&opts.execArgs, "exec", "x", false, "[Optional] Execute the arguments exactly as given without a shell")
+	cmd.Flags().StringVar(&opts.cpuset, "cpuset", "", "[Optional] Pin the job to the given CPUs, e.g. 0-3,6, overriding the CPU set of the profile")
+	cmd.Flags().Int32Var(&opts.nice, "nice", 0, "[Optional] Nice level of the job, from -20 (highest priority) to 19")
+	cmd.Flags().BoolVar(&opts.keepRootFS, "keep-rootfs", false, "[Optional] Keep the root filesystem of the job once it finishes for debugging, remove it with remove-rootfs")
+	cmd.Flags().StringArrayVarP(&opts.env, "env", "e", nil, "[Optional] Set an environment variable of the job as KEY=VALUE, can be repeated")
+	cmd.Flags().StringVar(&opts.envFile, "env-file", "", "[Optional] Read environment variables of the job from a file with KEY=VALUE lines, --env overrides them")
+	cmd.Flags().StringVarP(&opts.script, "script", "f", "", "[Optional] Run the content of a script file in a shell, - reads the script from stdin")
+	cmd.Flags().IntVar(&opts.maxScriptLen, "max-script-len", 64*1024, "[Optional] Maximum length of a script in bytes, the server rejects commands longer than its -max-command-len, 0 means unlimited")
+	cmd.Flags().Int32Var(&opts.retries, "retries", 0, "[Optional] Number of times the command is run again when it exits with a non-zero exit code")
+	cmd.Flags().DurationVar(&opts.retryBackoff, "retry-backoff", time.Second, "[Optional] Delay before the first retry, doubled after every retry")
+	cmd.Flags().DurationVar(&opts.startAfter, "start-after", 0, "[Optional] Launch the job after the given delay, e.g. 30s or 2h, the job is SCHEDULED till then")
+	cmd.Flags().StringVar(&opts.startAt, "start-at", "", "[Optional] Launch the job at the given time in RFC 3339 format, e.g. 2006-01-02T15:04:05Z, the job is SCHEDULED till then")
+	cmd.Flags().Int32Var(&opts.priority, "priority", 0, "[Optional] Priority of the job from 0 to 9 when it's queued, jobs with a higher priority are started first")
+	cmd.Flags().DurationVar(&opts.inactivityTimeout, "inactivity-timeout", 0, "[Optional] Kill the job once it produces no output for the given duration, e.g. 10m, the job is INACTIVE then (default no inactivity timeout)")
+	cmd.Flags().StringVar(&opts.outputRetention, "output-retention", "", "[Optional] How long the output of the job is kept once it finishes before the job is removed, e.g. 24h, or forever to keep it till the job is deleted (default server retention)")
+	cmd.Flags().StringVar(&opts.idempotencyKey, "idempotency-key", "", "[Optional] Key identifying the request, retrying the request with the same key returns the job started by the first request instead of starting another one")
+	cmd.Flags().StringArrayVarP(&opts.volumes, "volume", "v", nil, "[Optional] Mount a named volume into the job as name:/target/path, can be repeated")
 	cmd.Flags().SortFlags = false
 
 	return cmd
@@ -33,26 +64,228 @@ func stopCmd() *cobra.Command {
 	return cmd
 }
 
+func eventsCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "events --id <job_id>",
+		Short:   "Print output and status changes of a job as they happen",
+		Example: "client events --id <job_id>",
+		Run:     eventsHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
+func waitCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "wait --id <job_id>",
+		Short:   "Wait for a job to finish",
+		Example: "client wait --id <job_id>",
+		Run:     waitHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
+func pauseCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "pause --id <job_id>",
+		Short:   "Pause a running job",
+		Example: "client pause --id <job_id>",
+		Run:     pauseHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
+func signalCmd() *cobra.Command {
+	var id string
+	var sig string
+	cmd := &cobra.Command{
+		Use:     "signal --id <job_id> --signal <signal>",
+		Short:   "Send a signal to the processes of a running job",
+		Example: "client signal --id <job_id> --signal HUP",
+		Run:     signalHandler(&id, &sig),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	cmd.Flags().StringVarP(&sig, "signal", "s", "", "Signal name, e.g. HUP or SIGUSR1, or number. The server only allows HUP, INT, QUIT, TERM, USR1, USR2 and WINCH")
+	return cmd
+}
+
+func resumeCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "resume --id <job_id>",
+		Short:   "Resume a paused job",
+		Example: "client resume --id <job_id>",
+		Run:     resumeHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
+func removeRootFSCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "remove-rootfs --id <job_id>",
+		Aliases: []string{"rm-rootfs"},
+		Short:   "Remove the root filesystem of a finished job started with --keep-rootfs",
+		Example: "client remove-rootfs --id <job_id>",
+		Run:     removeRootFSHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
+func deleteCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "delete --id <job_id>",
+		Aliases: []string{"rm"},
+		Short:   "Delete a finished job along with its output",
+		Example: "client delete --id <job_id>",
+		Run:     deleteHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
+func volumesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "volumes",
+		Short:   "List the named volumes of the client along with their size",
+		Example: "client volumes",
+		Run:     volumesHandler(),
+	}
+	return cmd
+}
+
+func deleteVolumeCmd() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:     "delete-volume --name <volume>",
+		Short:   "Delete a named volume along with its files, once no unfinished job mounts it",
+		Example: "client delete-volume --name cache",
+		Run:     deleteVolumeHandler(&name),
+	}
+	cmd.Flags().StringVarP(&name, "name", "n", "", "Volume name")
+	return cmd
+}
+
+func execCmd() *cobra.Command {
+	var id string
+	cmd := &cobra.Command{
+		Use:     "exec --id <job_id> -- command [args...]",
+		Short:   "Run a command in the namespaces of a running job and print its output",
+		Example: "client exec --id <job_id> -- ps aux",
+		Args:    cobra.MinimumNArgs(1),
+		Run:     execHandler(&id),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	return cmd
+}
+
 func statusCmd() *cobra.Command {
 	var id string
+	var usage bool
+	var details bool
 	cmd := &cobra.Command{
 		Use:     "status --id <job_id>",
+		Aliases: []string{"st"},
 		Short:   "Fetch status of a job",
 		Example: "client status --id <job_id>",
-		Run:     statusHandler(&id),
+		Run:     statusHandler(&id, &usage, &details),
 	}
 	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	cmd.Flags().BoolVarP(&usage, "usage", "u", false, "[Optional] Print resources used by the job")
+	cmd.Flags().BoolVarP(&details, "details", "d", false, "[Optional] Print the command, profile and timeout of the job")
 	return cmd
 }
 
-func outputCmd() *cobra.Command {
+func listCmd() *cobra.Command {
+	var order string
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the jobs started by the client",
+		Example: "client list --sort newest",
+		Run:     listHandler(&order),
+	}
+	cmd.Flags().StringVarP(&order, "sort", "s", "oldest", "[Optional] Order of the jobs, one of oldest, newest or status")
+	return cmd
+}
+
+func profilesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "profiles",
+		Short:   "List the resource profiles jobs can be started with",
+		Example: "client profiles",
+		Run:     profilesHandler(),
+	}
+	return cmd
+}
+
+func serverStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "server-status",
+		Short:   "Show the number of jobs running and queued on the server",
+		Example: "client server-status",
+		Run:     serverStatusHandler(),
+	}
+	return cmd
+}
+
+func grepCmd() *cobra.Command {
+	var id string
+	opts := grepOptions{}
+	cmd := &cobra.Command{
+		Use:     "grep --id <job_id> --pattern <pattern>",
+		Short:   "Print the lines of the output of a job that match a pattern, searched by the server",
+		Example: "client grep --id <job_id> --pattern error\nclient grep --id <job_id> --pattern '^ERROR [0-9]+' --regexp --context 2",
+		Run:     grepHandler(&id, &opts),
+	}
+	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	cmd.Flags().StringVarP(&opts.pattern, "pattern", "e", "", "Substring the lines are matched against")
+	cmd.Flags().BoolVarP(&opts.regexp, "regexp", "E", false, "[Optional] The pattern is an RE2 regular expression")
+	cmd.Flags().Int32VarP(&opts.context, "context", "C", 0, "[Optional] Number of lines printed before and after every matching line")
+	cmd.Flags().BoolVarP(&opts.lineNumbers, "line-number", "n", false, "[Optional] Prefix every line with its line number")
+	return cmd
+}
+
+func exportCmd() *cobra.Command {
 	var id string
+	var out string
 	cmd := &cobra.Command{
-		Use:     "output --id <job_id>",
-		Short:   "Print output from a job",
-		Example: "client output --id <job_id>",
-		Run:     outputHandler(&id),
+		Use:     "export --id <job_id> [--out <file>]",
+		Short:   "Save the record of a finished job, its manifest and its output, in a gzipped tar archive",
+		Example: "client export --id <job_id> --out job.tar.gz",
+		Run:     exportHandler(&id, &out),
 	}
 	cmd.Flags().StringVarP(&id, "id", "i", "", "Job ID")
+	cmd.Flags().StringVarP(&out, "out", "o", "", "[Optional] Path of the archive, - for stdout (default <job_id>.tar.gz)")
+	return cmd
+}
+
+func outputCmd() *cobra.Command {
+	var ids []string
+	opts := outputOptions{}
+	cmd := &cobra.Command{
+		Use:     "output --id <job_id> [--id <job_id>...]",
+		Aliases: []string{"logs"},
+		Short:   "Print output from one or more jobs",
+		Example: "client output --id <job_id>\nclient output --id <job_id> --id <job_id>",
+		Run:     outputHandler(&ids, &opts),
+	}
+	cmd.Flags().StringArrayVarP(&ids, "id", "i", nil, "Job ID, can be repeated to print the output of several jobs with each line prefixed by the job ID")
+	cmd.Flags().BoolVarP(&opts.lines, "lines", "l", false, "[Optional] Only print complete lines")
+	cmd.Flags().BoolVar(&opts.noColor, "no-color", false, "[Optional] Strip ANSI escape sequences (default when not printing to a terminal)")
+	cmd.Flags().BoolVar(&opts.raw, "raw", false, "[Optional] Print the output exactly as produced by the job, e.g. binary output")
+	cmd.Flags().BoolVar(&opts.finished, "finished", false, "[Optional] Fetch the whole output of a finished job in one request")
+	cmd.Flags().Int64Var(&opts.lastBytes, "last-bytes", 0, "[Optional] Start printing from the last bytes of the output produced so far")
+	cmd.Flags().StringVar(&opts.format, "format", "", "[Optional] Go template every line is printed with, e.g. '{{.Time.Format \"15:04:05\"}} {{.ID}} {{.Line}}'. "+
+		"The fields are ID, the job ID, Time, when the client received the line, and Line, the line without its newline")
+	cmd.Flags().BoolVar(&opts.compress, "compress", false, "[Optional] Compress the output with gzip on the wire")
 	return cmd
 }
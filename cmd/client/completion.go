@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ronakg/runner/pkg/proto"
+	"github.com/spf13/cobra"
+)
+
+// completionTimeout is how long completing job IDs waits for the server, the shell is blocked
+// till then
+const completionTimeout = 2 * time.Second
+
+// completeJobIDs completes the --id flag with the IDs of the jobs of the client, described by
+// their status and command. Nothing is completed if neither the certs nor --insecure are given on
+// the command line or the server can't be reached.
+func completeJobIDs(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if certsDir == "" && pkcs12Path == "" && !insecureConn {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	conn := getClientConn()
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	client := proto.NewRunnerClient(conn)
+	resp, err := client.List(ctx, &proto.ListRequest{Order: proto.ListOrder_NEWEST_FIRST})
+	if err != nil {
+		cobra.CompDebugln(fmt.Sprintf("Failed to list jobs: %v", err), true)
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var ids []string
+	for _, j := range resp.Jobs {
+		command := j.Command
+		if command == "" {
+			command = fmt.Sprintf("%q", j.Args)
+		}
+		ids = append(ids, fmt.Sprintf("%s\t%s %s", j.JobId, j.Status, command))
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+// registerCompletions completes the --id flag of all the subcommands of cmd with job IDs
+func registerCompletions(cmd *cobra.Command) {
+	for _, c := range cmd.Commands() {
+		if c.Flags().Lookup("id") != nil {
+			_ = c.RegisterFlagCompletionFunc("id", completeJobIDs)
+		}
+	}
+}
@@ -1,31 +1,311 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/ronakg/runner/pkg/proto"
 	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
 )
 
-func startHandler(timeout *int32, profile *string) func(*cobra.Command, []string) {
+// hasExitCode returns true if a job with the status has exited, so its exit code is meaningful
+func hasExitCode(status proto.JobStatus) bool {
+	switch status {
+	case proto.JobStatus_RUNNING, proto.JobStatus_PAUSED, proto.JobStatus_SCHEDULED, proto.JobStatus_QUEUED:
+		return false
+	}
+	return true
+}
+
+// parseTimeout parses a timeout given as a duration, e.g. 5m or 200ms, or as a plain number of
+// seconds. Durations that aren't whole milliseconds are rounded up, so that a short timeout
+// doesn't become no timeout.
+func parseTimeout(timeout string) (time.Duration, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		secs, serr := strconv.ParseInt(timeout, 10, 32)
+		if serr != nil {
+			return 0, fmt.Errorf("invalid timeout %s, expected a duration like 90s or a number of seconds", timeout)
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative timeout %s", timeout)
+	}
+
+	if (d+time.Second-1)/time.Second > math.MaxInt32 {
+		return 0, fmt.Errorf("timeout %s is too long", timeout)
+	}
+	return (d + time.Millisecond - 1).Truncate(time.Millisecond), nil
+}
+
+// parseOutputRetention parses the output retention of a job given as a duration, e.g. 24h, or as
+// forever and returns it in milliseconds as expected by the server. An empty retention is 0, the
+// default retention of the server, and forever is -1.
+func parseOutputRetention(retention string) (int64, error) {
+	switch retention {
+	case "":
+		return 0, nil
+	case "forever":
+		return -1, nil
+	}
+
+	d, err := time.ParseDuration(retention)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid output retention %s, expected a positive duration like 24h or forever", retention)
+	}
+	// rounded up, so that a short retention doesn't become the default retention
+	return int64((d + time.Millisecond - 1) / time.Millisecond), nil
+}
+
+// parseSignal parses a signal given by name, with or without the SIG prefix, or by number
+func parseSignal(sig string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(sig); err == nil && num > 0 {
+		return syscall.Signal(num), nil
+	}
+	name := strings.ToUpper(sig)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if num := unix.SignalNum(name); num != 0 {
+		return num, nil
+	}
+	return 0, fmt.Errorf("invalid signal %q, expected a name like HUP or a number", sig)
+}
+
+// envKeyRegexp matches the keys of environment variables
+var envKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvFile reads the environment variables in the file at path, one KEY=VALUE per line. Blank
+// lines and lines starting with # are skipped. Values are taken as is, quotes aren't removed.
+func parseEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var env []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		kv := strings.TrimLeft(line, " \t")
+		if err := validateEnv(kv); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		env = append(env, kv)
+	}
+	return env, nil
+}
+
+// validateEnv checks that kv is KEY=VALUE with a valid key
+func validateEnv(kv string) error {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed environment variable %q, expected KEY=VALUE", kv)
+	}
+	if !envKeyRegexp.MatchString(parts[0]) {
+		return fmt.Errorf("invalid environment variable name %q", parts[0])
+	}
+	return nil
+}
+
+// mergeEnv returns the variables of env, keeping only the last variable with the same key in the
+// position of the first one
+func mergeEnv(env []string) []string {
+	index := make(map[string]int)
+	var merged []string
+	for _, kv := range env {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if i, ok := index[key]; ok {
+			merged[i] = kv
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, kv)
+	}
+	return merged
+}
+
+// readScript reads the script at path, or from stdin if path is -. The script is sent to the
+// server as the command of the job, so it can't be longer than maxLen bytes, the maximum length of
+// a command accepted by the server. A maxLen of 0 means unlimited.
+func readScript(path string, maxLen int) (string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	if maxLen > 0 {
+		r = io.LimitReader(r, int64(maxLen)+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if maxLen > 0 && len(data) > maxLen {
+		return "", fmt.Errorf("script is longer than %d bytes, the maximum length of a command accepted by the server. "+
+			"Raise -max-command-len of the server and --max-script-len to run it", maxLen)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", errors.New("script is empty")
+	}
+	return string(data), nil
+}
+
+// startOptions are the flags of the start command
+type startOptions struct {
+	timeout           string        // timeout as a duration or in seconds, 0 means no timeout
+	profile           string        // resource profile of the job
+	name              string        // name of the job that can be used in place of its ID
+	execArgs          bool          // run the arguments without a shell
+	cpuset            string        // CPUs the job is pinned to, overriding the CPU set of the profile
+	nice              int32         // nice level of the job
+	keepRootFS        bool          // keep the root filesystem of the job once it finishes
+	env               []string      // KEY=VALUE environment variables of the job
+	envFile           string        // file with KEY=VALUE environment variables, env overrides them
+	script            string        // script file run in a shell, - for stdin
+	maxScriptLen      int           // maximum length of the script in bytes, 0 means unlimited
+	retries           int32         // number of times a failed command is run again
+	retryBackoff      time.Duration // delay before the first retry
+	startAfter        time.Duration // delay before the job is launched
+	startAt           string        // RFC 3339 time the job is launched at
+	priority          int32         // priority of the job when it's queued
+	inactivityTimeout time.Duration // kill the job once it's silent for this long, 0 means never
+	outputRetention   string        // how long the output is kept once the job finishes
+	idempotencyKey    string        // key identifying the start request
+	volumes           []string      // name:/target/path volumes mounted into the job
+}
+
+func startHandler(opts *startOptions) func(*cobra.Command, []string) {
 	return func(_ *cobra.Command, args []string) {
+		// start - reads the script from stdin
+		if opts.script == "" && len(args) == 1 && args[0] == "-" && !opts.execArgs {
+			opts.script = "-"
+		}
+		if opts.script != "" && opts.execArgs {
+			log.Fatal("A script can't be run with --exec, it's run in a shell")
+		}
+
+		d, err := parseTimeout(opts.timeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opts.inactivityTimeout < 0 {
+			log.Fatalf("Negative inactivity timeout %s", opts.inactivityTimeout)
+		}
+		if opts.startAfter < 0 {
+			log.Fatalf("Negative start delay %s", opts.startAfter)
+		}
+		if opts.startAfter > 0 && opts.startAt != "" {
+			log.Fatal("--start-after and --start-at can't be combined")
+		}
+		var startAtMs int64
+		if opts.startAt != "" {
+			at, err := time.Parse(time.RFC3339, opts.startAt)
+			if err != nil {
+				log.Fatalf("Invalid start time %s, expected RFC 3339 format like 2006-01-02T15:04:05Z", opts.startAt)
+			}
+			startAtMs = at.UnixNano() / int64(time.Millisecond)
+		}
+
+		retentionMs, err := parseOutputRetention(opts.outputRetention)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// the variables given with --env override the variables in the env file
+		var jobEnv []string
+		if opts.envFile != "" {
+			if jobEnv, err = parseEnvFile(opts.envFile); err != nil {
+				log.Fatalf("Invalid env file: %v", err)
+			}
+		}
+		for _, kv := range opts.env {
+			if err := validateEnv(kv); err != nil {
+				log.Fatal(err)
+			}
+		}
+		jobEnv = mergeEnv(append(jobEnv, opts.env...))
+		req := &proto.StartRequest{
+			// servers that don't know about milliseconds get the timeout rounded up to seconds
+			Timeout:    int32((d + time.Second - 1) / time.Second),
+			TimeoutMs:  d.Milliseconds(),
+			Profile:    opts.profile,
+			Name:       opts.name,
+			Nice:       opts.nice,
+			Cpuset:     opts.cpuset,
+			KeepRootfs: opts.keepRootFS,
+			Env:        jobEnv,
+
+			IdempotencyKey: opts.idempotencyKey,
+			Retries:        opts.retries,
+			RetryBackoffMs: opts.retryBackoff.Milliseconds(),
+			StartAfterMs:   opts.startAfter.Milliseconds(),
+			StartAt:        startAtMs,
+			Priority:       opts.priority,
+
+			OutputRetentionMs:   retentionMs,
+			InactivityTimeoutMs: int64((opts.inactivityTimeout + time.Millisecond - 1) / time.Millisecond),
+		}
+		for _, v := range opts.volumes {
+			parts := strings.SplitN(v, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				log.Fatalf("Invalid volume %s, expected name:/target/path", v)
+			}
+			req.Volumes = append(req.Volumes, &proto.Volume{Name: parts[0], Target: parts[1]})
+		}
+
 		conn := getClientConn()
 		defer conn.Close()
 
+		switch {
+		case opts.script != "":
+			// the server runs the script with sh -c like any other command
+			if req.Command, err = readScript(opts.script, opts.maxScriptLen); err != nil {
+				log.Fatalf("Failed to read script: %v", err)
+			}
+		case opts.execArgs:
+			req.Args = args
+		default:
+			req.Command = strings.Join(args, " ")
+		}
+
 		client := proto.NewRunnerClient(conn)
-		resp, err := client.Start(context.Background(), &proto.StartRequest{
-			Command: strings.Join(args, " "),
-			Timeout: *timeout,
-			Profile: *profile,
-		})
+		resp, err := client.Start(context.Background(), req)
 		if err != nil {
 			log.Fatalf("Failed to start '%s': %v", args, err)
 		}
+		if len(resp.DroppedEnv) > 0 {
+			fmt.Fprintf(os.Stderr, "Dropped environment variables: %s\n", strings.Join(resp.DroppedEnv, ", "))
+		}
 		fmt.Printf("%s\n", resp.JobId)
 	}
 }
@@ -43,14 +323,184 @@ func stopHandler(id *string) func(*cobra.Command, []string) {
 			log.Fatalf("Failed to stop the job %s: %v", *id, err)
 		}
 		fmt.Printf("%s", resp.Status)
-		if resp.Status != proto.JobStatus_RUNNING {
+		if hasExitCode(resp.Status) {
 			fmt.Printf(" (%d)", resp.ExitCode)
 		}
 		fmt.Print("\n")
 	}
 }
 
-func statusHandler(id *string) func(*cobra.Command, []string) {
+func waitHandler(id *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.Wait(context.Background(), &proto.WaitRequest{
+			JobId: *id,
+		})
+		if err != nil {
+			log.Fatalf("Failed to wait for the job %s: %v", *id, err)
+		}
+		fmt.Printf("%s (%d)\n", resp.Status, resp.ExitCode)
+	}
+}
+
+func pauseHandler(id *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.Pause(context.Background(), &proto.PauseRequest{
+			JobId: *id,
+		})
+		if err != nil {
+			log.Fatalf("Failed to pause the job %s: %v", *id, err)
+		}
+		fmt.Printf("%s\n", resp.Status)
+	}
+}
+
+func signalHandler(id *string, sig *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		num, err := parseSignal(*sig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.Signal(context.Background(), &proto.SignalRequest{
+			JobId:  *id,
+			Signal: int32(num),
+		})
+		if err != nil {
+			log.Fatalf("Failed to send %s to the job %s: %v", num, *id, err)
+		}
+		fmt.Printf("%s\n", resp.Status)
+	}
+}
+
+func resumeHandler(id *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.Resume(context.Background(), &proto.ResumeRequest{
+			JobId: *id,
+		})
+		if err != nil {
+			log.Fatalf("Failed to resume the job %s: %v", *id, err)
+		}
+		fmt.Printf("%s\n", resp.Status)
+	}
+}
+
+func removeRootFSHandler(id *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		_, err := client.RemoveRootFS(context.Background(), &proto.RemoveRootFSRequest{
+			JobId: *id,
+		})
+		if err != nil {
+			log.Fatalf("Failed to remove the root filesystem of the job %s: %v", *id, err)
+		}
+	}
+}
+
+func deleteHandler(id *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		_, err := client.Delete(context.Background(), &proto.DeleteRequest{
+			JobId: *id,
+		})
+		if err != nil {
+			log.Fatalf("Failed to delete the job %s: %v", *id, err)
+		}
+	}
+}
+
+func volumesHandler() func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.ListVolumes(context.Background(), &proto.ListVolumesRequest{})
+		if err != nil {
+			log.Fatalf("Failed to list volumes: %v", err)
+		}
+
+		for _, v := range resp.Volumes {
+			fmt.Printf("%s\tsize=%d\n", v.Name, v.SizeBytes)
+		}
+	}
+}
+
+func deleteVolumeHandler(name *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		_, err := client.DeleteVolume(context.Background(), &proto.DeleteVolumeRequest{
+			Name: *name,
+		})
+		if err != nil {
+			log.Fatalf("Failed to delete the volume %s: %v", *name, err)
+		}
+	}
+}
+
+// execHandler runs the command in the job and exits with the exit code of the command
+func execHandler(id *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, args []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		// Canceling the context closes the stream, so that the server kills the command
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := proto.NewRunnerClient(conn)
+		stream, err := client.Exec(ctx, &proto.ExecRequest{
+			JobId: *id,
+			Args:  args,
+		})
+		if err != nil {
+			log.Fatalf("Failed to exec in the job %s: %v", *id, err)
+		}
+
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				log.Fatalf("Failed to exec in the job %s: %v", *id, err)
+			}
+			switch event := resp.Event.(type) {
+			case *proto.ExecResponse_Output:
+				if _, err := os.Stdout.Write(event.Output); err != nil {
+					log.Fatalf("Failed to write output: %v", err)
+				}
+			case *proto.ExecResponse_ExitCode:
+				cancel()
+				conn.Close()
+				os.Exit(int(event.ExitCode))
+			}
+		}
+	}
+}
+
+func statusHandler(id *string, usage *bool, details *bool) func(*cobra.Command, []string) {
 	return func(_ *cobra.Command, _ []string) {
 		conn := getClientConn()
 		defer conn.Close()
@@ -63,24 +513,327 @@ func statusHandler(id *string) func(*cobra.Command, []string) {
 			log.Fatalf("Failed to get status of the job %s: %v", *id, err)
 		}
 		fmt.Printf("%s", resp.Status)
-		if resp.Status != proto.JobStatus_RUNNING {
+		if hasExitCode(resp.Status) {
 			fmt.Printf(" (%d)", resp.ExitCode)
 		}
 		fmt.Print("\n")
+
+		if *details {
+			if resp.Command != "" {
+				fmt.Printf("Command: %s\n", resp.Command)
+			} else {
+				fmt.Printf("Args: %q\n", resp.Args)
+			}
+			fmt.Printf("Profile: %s\n", resp.Profile)
+			timeout := time.Duration(resp.Timeout) * time.Second
+			if resp.TimeoutMs > 0 {
+				timeout = time.Duration(resp.TimeoutMs) * time.Millisecond
+			}
+			fmt.Printf("Timeout: %s\n", timeout)
+			fmt.Printf("Output complete: %t\n", resp.OutputComplete)
+			if resp.Pid > 0 {
+				fmt.Printf("PID: %d\n", resp.Pid)
+			}
+			if len(resp.Attempts) > 0 {
+				fmt.Printf("Attempts: %v\n", resp.Attempts)
+			}
+			if resp.QueuePosition > 0 {
+				fmt.Printf("Queue position: %d\n", resp.QueuePosition)
+				if resp.EstimatedStart > 0 {
+					start := time.Unix(0, resp.EstimatedStart*int64(time.Millisecond))
+					fmt.Printf("Estimated start: %s\n", start.Format(time.RFC3339))
+				} else {
+					fmt.Print("Estimated start: unknown\n")
+				}
+			}
+		}
+		if *usage && resp.Usage != nil {
+			fmt.Printf("User time: %dms\n", resp.Usage.UserTimeMs)
+			fmt.Printf("System time: %dms\n", resp.Usage.SystemTimeMs)
+			fmt.Printf("Max RSS: %d bytes\n", resp.Usage.MaxRssBytes)
+		}
+		if *usage && resp.LiveUsage != nil {
+			fmt.Printf("CPU time: %dms\n", resp.LiveUsage.CpuTimeMs)
+			fmt.Printf("Current memory: %d bytes\n", resp.LiveUsage.MemoryCurrentBytes)
+		}
+	}
+}
+
+// listOrders maps the values of the sort flag of the list command to the order of the jobs
+var listOrders = map[string]proto.ListOrder{
+	"oldest": proto.ListOrder_OLDEST_FIRST,
+	"newest": proto.ListOrder_NEWEST_FIRST,
+	"status": proto.ListOrder_BY_STATUS,
+}
+
+// listHandler prints a line per job with its ID, status, start time and command
+func listHandler(order *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		listOrder, ok := listOrders[*order]
+		if !ok {
+			log.Fatalf("Invalid sort order %s, must be one of oldest, newest or status", *order)
+		}
+
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.List(context.Background(), &proto.ListRequest{
+			Order: listOrder,
+		})
+		if err != nil {
+			log.Fatalf("Failed to list jobs: %v", err)
+		}
+
+		for _, j := range resp.Jobs {
+			status := j.Status.String()
+			if hasExitCode(j.Status) {
+				status = fmt.Sprintf("%s (%d)", j.Status, j.ExitCode)
+			}
+			command := j.Command
+			if command == "" {
+				command = fmt.Sprintf("%q", j.Args)
+			}
+			createdAt := time.Unix(0, j.CreatedAt*int64(time.Millisecond))
+			fmt.Printf("%s\t%s\t%s\t%s\n", j.JobId, status, createdAt.Format(time.RFC3339), command)
+		}
+	}
+}
+
+// profilesHandler prints the resource profiles with their limits, 0 means unlimited
+func profilesHandler() func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.ListProfiles(context.Background(), &proto.ListProfilesRequest{})
+		if err != nil {
+			log.Fatalf("Failed to list profiles: %v", err)
+		}
+
+		for _, p := range resp.Profiles {
+			fmt.Printf("%s\tcpus=%g\tmemory_max=%d\tpids_max=%d", p.Name, p.Cpus, p.MemoryMaxBytes, p.PidsMax)
+			if p.Cpuset != "" {
+				fmt.Printf("\tcpuset=%s", p.Cpuset)
+			}
+			fmt.Print("\n")
+		}
+	}
+}
+
+func serverStatusHandler() func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		resp, err := client.ServerStatus(context.Background(), &proto.ServerStatusRequest{})
+		if err != nil {
+			log.Fatalf("Failed to get status of the server: %v", err)
+		}
+
+		maxJobs, maxQueued := "unlimited", "unlimited"
+		if resp.MaxJobs > 0 {
+			maxJobs = strconv.Itoa(int(resp.MaxJobs))
+		}
+		if resp.MaxQueued >= 0 {
+			maxQueued = strconv.Itoa(int(resp.MaxQueued))
+		}
+		fmt.Printf("Running jobs: %d (max %s)\n", resp.RunningJobs, maxJobs)
+		fmt.Printf("Queued jobs: %d (max %s)\n", resp.QueuedJobs, maxQueued)
+
+		priorities := make([]int32, 0, len(resp.QueuedByPriority))
+		for p := range resp.QueuedByPriority {
+			priorities = append(priorities, p)
+		}
+		sort.Slice(priorities, func(i, k int) bool { return priorities[i] > priorities[k] })
+		for _, p := range priorities {
+			fmt.Printf("  priority %d: %d\n", p, resp.QueuedByPriority[p])
+		}
+	}
+}
+
+// outputOptions determines how the output of a job is printed
+type outputOptions struct {
+	lines   bool // only print complete lines
+	noColor bool // strip ANSI escape sequences
+	raw     bool // print the output exactly as produced by the job
+
+	finished bool // fetch the whole output of a finished job in one request instead of streaming it
+	compress bool // compress the output with gzip on the wire
+
+	lastBytes int64 // only print the output from the last bytes produced so far, 0 means all
+
+	format string // template every line is formatted with, empty to print the output as is
+}
+
+// outputWriter returns a writer that prints output to stdout according to opts and a function to
+// flush any buffered output
+func outputWriter(opts *outputOptions) (io.Writer, func() error) {
+	if opts.raw {
+		return os.Stdout, func() error { return nil }
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.noColor || !isTerminal(os.Stdout) {
+		// Escape sequences are only stripped from binary output when asked to explicitly
+		out = &ansiWriter{w: out, skipBinary: !opts.noColor}
+	}
+	if !opts.lines {
+		return out, func() error { return nil }
+	}
+
+	lw := &lineWriter{w: out}
+	return lw, lw.Flush
+}
+
+func outputHandler(ids *[]string, opts *outputOptions) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		if len(*ids) == 0 {
+			log.Fatal("At least one job ID is required")
+		}
+
+		var tmpl *template.Template
+		if opts.format != "" {
+			var err error
+			if tmpl, err = template.New("format").Parse(opts.format); err != nil {
+				log.Fatalf("Invalid format: %v", err)
+			}
+		}
+
+		conn := getClientConn()
+		defer conn.Close()
+
+		// Canceling the context closes the streams, so that the server stops streaming output
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Writing to a closed stdout returns EPIPE instead of killing the client with SIGPIPE
+		signal.Ignore(syscall.SIGPIPE)
+
+		out, flush := outputWriter(opts)
+		defer func() {
+			if err := flush(); err != nil && !errors.Is(err, syscall.EPIPE) {
+				log.Fatalf("Failed to write output: %v", err)
+			}
+		}()
+
+		client := proto.NewRunnerClient(conn)
+		if len(*ids) == 1 && tmpl == nil {
+			err := printOutput(ctx, client, (*ids)[0], opts, out)
+			if err != nil && !errors.Is(err, syscall.EPIPE) {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		// The output of each job is printed line by line, formatted with the template or prefixed
+		// with the job ID. The streams finish independently, the client exits once all of them are
+		// done.
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		var failed int32
+		for _, id := range *ids {
+			fw := &lineFormatWriter{w: out, format: prefixFormat("[" + id + "] "), mu: &mu}
+			if tmpl != nil {
+				fw.format = templateFormat(tmpl, id)
+			}
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				err := printOutput(ctx, client, id, opts, fw)
+				if ferr := fw.Flush(); err == nil && ferr != nil && !errors.Is(ferr, syscall.EPIPE) {
+					err = fmt.Errorf("failed to write output: %w", ferr)
+				}
+				if errors.Is(err, syscall.EPIPE) {
+					// there's no one to print the output of the other jobs to either
+					cancel()
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					log.Print(err)
+					atomic.StoreInt32(&failed, 1)
+				}
+			}(id)
+		}
+		wg.Wait()
+
+		if atomic.LoadInt32(&failed) != 0 {
+			if err := flush(); err != nil && !errors.Is(err, syscall.EPIPE) {
+				log.Printf("Failed to write output: %v", err)
+			}
+			os.Exit(1)
+		}
 	}
 }
 
-func outputHandler(id *string) func(*cobra.Command, []string) {
+// printOutput writes the output of the job id to out till the output ends. EPIPE is returned
+// as is when the reader of stdout exited, e.g. head, there's no one to print to then.
+func printOutput(ctx context.Context, client proto.RunnerClient, id string, opts *outputOptions, out io.Writer) error {
+	// The server compresses the output with the compressor used by the request
+	var callOpts []grpc.CallOption
+	if opts.compress {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+
+	if opts.finished {
+		resp, err := client.GetOutput(ctx, &proto.GetOutputRequest{
+			JobId: id,
+		}, callOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to fetch output of the job %s: %w", id, err)
+		}
+		if _, err := out.Write(resp.Output); err != nil {
+			if errors.Is(err, syscall.EPIPE) {
+				return err
+			}
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		return nil
+	}
+
+	stream, err := client.Output(ctx, &proto.OutputRequest{
+		JobId:     id,
+		LastBytes: opts.lastBytes,
+	}, callOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch output of the job %s: %w", id, err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return fmt.Errorf("server error for the job %s: %w", id, err)
+			}
+			return nil
+		}
+		if resp.Heartbeat {
+			// heartbeats only tell that the stream is alive, there's nothing to print
+			continue
+		}
+		if _, err := out.Write(resp.Buffer); err != nil {
+			if errors.Is(err, syscall.EPIPE) {
+				return err
+			}
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+}
+
+// eventsHandler prints the output of a job to stdout and its status changes to stderr
+func eventsHandler(id *string) func(*cobra.Command, []string) {
 	return func(_ *cobra.Command, _ []string) {
 		conn := getClientConn()
 		defer conn.Close()
 
 		client := proto.NewRunnerClient(conn)
-		stream, err := client.Output(context.Background(), &proto.OutputRequest{
+		stream, err := client.Events(context.Background(), &proto.EventsRequest{
 			JobId: *id,
 		})
 		if err != nil {
-			log.Fatalf("Failed to fetch output of the job %s: %v", *id, err)
+			log.Fatalf("Failed to fetch events of the job %s: %v", *id, err)
 		}
 
 		for {
@@ -91,7 +844,136 @@ func outputHandler(id *string) func(*cobra.Command, []string) {
 				}
 				return
 			}
-			fmt.Printf("%s", resp.Buffer)
+
+			if output := resp.GetOutput(); output != nil {
+				if _, err := os.Stdout.Write(output.Buffer); err != nil {
+					log.Fatalf("Failed to write output: %v", err)
+				}
+			}
+			if st := resp.GetStatus(); st != nil {
+				fmt.Fprintf(os.Stderr, "[%s]", st.Status)
+				if hasExitCode(st.Status) {
+					fmt.Fprintf(os.Stderr, " (%d)", st.ExitCode)
+				}
+				fmt.Fprint(os.Stderr, "\n")
+			}
+		}
+	}
+}
+
+// grepOptions are the options of the grep command
+type grepOptions struct {
+	pattern     string // substring or regular expression the lines are matched against
+	regexp      bool   // pattern is a regular expression
+	context     int32  // number of context lines around every matching line
+	lineNumbers bool   // prefix the lines with their line numbers
+}
+
+func grepHandler(id *string, opts *grepOptions) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		if opts.pattern == "" {
+			log.Fatal("A pattern is required")
+		}
+
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		stream, err := client.SearchOutput(context.Background(), &proto.SearchOutputRequest{
+			JobId:   *id,
+			Pattern: opts.pattern,
+			Regexp:  opts.regexp,
+			Context: opts.context,
+		})
+		if err != nil {
+			log.Fatalf("Failed to search output of the job %s: %v", *id, err)
+		}
+
+		// Like grep, the line number of a matching line is followed by ':' and the line number of a
+		// context line by '-'. Groups of lines that aren't adjacent are separated by '--'.
+		var last int64
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Fatalf("Server error for the job %s: %v", *id, err)
+				}
+				return
+			}
+			if opts.context > 0 && last > 0 && resp.LineNumber > last+1 {
+				fmt.Println("--")
+			}
+			last = resp.LineNumber
+
+			if opts.lineNumbers {
+				sep := "-"
+				if resp.Match {
+					sep = ":"
+				}
+				fmt.Printf("%d%s", resp.LineNumber, sep)
+			}
+			fmt.Printf("%s\n", resp.Line)
+		}
+	}
+}
+
+func exportHandler(id *string, out *string) func(*cobra.Command, []string) {
+	return func(_ *cobra.Command, _ []string) {
+		path := *out
+		if path == "" {
+			path = *id + ".tar.gz"
+		}
+
+		conn := getClientConn()
+		defer conn.Close()
+
+		client := proto.NewRunnerClient(conn)
+		stream, err := client.Export(context.Background(), &proto.ExportRequest{
+			JobId: *id,
+		})
+		if err != nil {
+			log.Fatalf("Failed to export the job %s: %v", *id, err)
+		}
+		if path == "-" {
+			if err := receiveExport(stream, os.Stdout); err != nil {
+				log.Fatalf("Failed to export the job %s: %v", *id, err)
+			}
+			return
+		}
+
+		// The archive is written to a temporary file that's renamed once it's complete, so that a
+		// failed export doesn't leave a truncated archive behind
+		f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+		if err != nil {
+			log.Fatalf("Failed to create the archive: %v", err)
+		}
+		err = receiveExport(stream, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			log.Fatalf("Failed to export the job %s: %v", *id, err)
+		}
+		fmt.Printf("%s\n", path)
+	}
+}
+
+// receiveExport writes the archive streamed by Export to w
+func receiveExport(stream proto.Runner_ExportClient, w io.Writer) error {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if _, err := w.Write(resp.Data); err != nil {
+			return err
 		}
 	}
 }
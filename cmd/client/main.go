@@ -6,20 +6,48 @@
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/ronakg/runner/pkg/certs"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
 func getClientConn() *grpc.ClientConn {
-	creds, err := createCredentials(certsDir)
-	if err != nil {
-		log.Fatalf("Failed to set up certificates: %v", err)
+	var creds credentials.TransportCredentials
+	if insecureConn {
+		creds = insecure.NewCredentials()
+	} else {
+		// --certs isn't a required flag, so that shell completion works without it
+		if certsDir == "" && pkcs12Path == "" {
+			log.Fatal(`Required flag "certs" or "pkcs12" not set`)
+		}
+		var err error
+		if creds, err = createCredentials(certsDir); err != nil {
+			log.Fatalf("Failed to set up certificates: %v", err)
+		}
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxRecvMsgSize), grpc.MaxCallSendMsgSize(maxSendMsgSize)),
+	}
+	if keepaliveTime > 0 {
+		// Pings keep idle connections, e.g. output streams of silent jobs, from being dropped
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			PermitWithoutStream: true,
+		}))
 	}
 
-	conn, err := grpc.Dial(fmt.Sprintf(":%s", port), grpc.WithTransportCredentials(creds))
+	conn, err := grpc.Dial(fmt.Sprintf(":%s", port), opts...)
 	if err != nil {
 		log.Fatalf("grpc server error: %v", err)
 	}
@@ -27,6 +55,10 @@ func getClientConn() *grpc.ClientConn {
 }
 
 func createCredentials(certsDir string) (credentials.TransportCredentials, error) {
+	if pkcs12Path != "" {
+		return createPKCS12Credentials()
+	}
+
 	certificate, err := tls.LoadX509KeyPair(
 		filepath.Join(certsDir, "client.crt"),
 		filepath.Join(certsDir, "client.key"),
@@ -35,25 +67,102 @@ func createCredentials(certsDir string) (credentials.TransportCredentials, error
 		return nil, err
 	}
 
-	data, err := ioutil.ReadFile(filepath.Join(certsDir, "ca.crt"))
+	path := caPath
+	if path == "" {
+		path = filepath.Join(certsDir, "ca.crt")
+	}
+	ca, err := loadCAs(path)
 	if err != nil {
 		return nil, err
 	}
 
-	ca := x509.NewCertPool()
-	if !ca.AppendCertsFromPEM(data) {
-		return nil, fmt.Errorf("failed to append CA certificate")
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      ca,
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// createPKCS12Credentials returns the mTLS credentials of the client with the certificate and key
+// in the --pkcs12 file. The server certificate must be issued by one of the CAs in the file, or at
+// --ca if it's set.
+func createPKCS12Credentials() (credentials.TransportCredentials, error) {
+	certificate, cas, err := certs.LoadPKCS12(pkcs12Path, pkcs12Pass)
+	if err != nil {
+		return nil, err
+	}
+
+	var ca *x509.CertPool
+	if caPath != "" {
+		if ca, err = loadCAs(caPath); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(cas) == 0 {
+			return nil, fmt.Errorf("no CA certificates found in %s, set them with --ca", pkcs12Path)
+		}
+		ca = x509.NewCertPool()
+		for _, cert := range cas {
+			ca.AddCert(cert)
+		}
 	}
 
 	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{certificate},
 		RootCAs:      ca,
 	}
-	return credentials.NewTLS(tlsConfig), err
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// loadCAs returns a pool of the CA certificates at path, either a file with one or more PEM encoded
+// certificates or a directory of .crt and .pem files with such certificates. At least one
+// certificate must be found.
+func loadCAs(path string) (*x509.CertPool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".crt" || ext == ".pem") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no CA files found in %s", path)
+		}
+	}
+
+	ca := x509.NewCertPool()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !ca.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no CA certificates found in %s", file)
+		}
+	}
+	return ca, nil
 }
 
 var certsDir string
+var caPath string
+var pkcs12Path string
+var pkcs12Pass string
+var insecureConn bool
 var port string
+var keepaliveTime time.Duration
+var maxRecvMsgSize int
+var maxSendMsgSize int
 
 func main() {
 	cobra.EnableCommandSorting = false
@@ -62,14 +171,36 @@ func main() {
 		Short: "Runner client",
 	}
 	cmd.PersistentFlags().StringVar(&certsDir, "certs", "", "Path to the certs directory containing ca.crt, client.crt and client.key")
+	cmd.PersistentFlags().StringVar(&caPath, "ca", "", "PEM file with one or more CA certificates, or a directory of .crt and .pem CA files, that the server certificate can be issued by (default ca.crt in the certs directory)")
+	cmd.PersistentFlags().StringVar(&pkcs12Path, "pkcs12", "", "PKCS#12 (.p12 or .pfx) file with the certificate and key of the client and the CA certificates, instead of --certs. --ca overrides the CA certificates of the file")
+	cmd.PersistentFlags().StringVar(&pkcs12Pass, "pkcs12-pass", "", "Password of the --pkcs12 file")
+	cmd.PersistentFlags().BoolVar(&insecureConn, "insecure", false, "Connect without TLS to a server run with -insecure for local development, --certs isn't needed")
 	cmd.PersistentFlags().StringVarP(&port, "port", "", "9000", "Server port number")
-	_ = cmd.MarkFlagRequired("certs")
+	cmd.PersistentFlags().IntVar(&maxRecvMsgSize, "max-recv-msg-size", 4*1024*1024, "Maximum size of a message received from the server in bytes")
+	cmd.PersistentFlags().IntVar(&maxSendMsgSize, "max-send-msg-size", math.MaxInt32, "Maximum size of a message sent to the server in bytes")
+	cmd.PersistentFlags().DurationVar(&keepaliveTime, "keepalive", 0, "Ping the server after this much inactivity on the connection, at least 10s (default no pings)")
 	cmd.Flags().SortFlags = false
 
 	cmd.AddCommand(startCmd())
 	cmd.AddCommand(stopCmd())
+	cmd.AddCommand(waitCmd())
+	cmd.AddCommand(pauseCmd())
+	cmd.AddCommand(resumeCmd())
+	cmd.AddCommand(signalCmd())
+	cmd.AddCommand(removeRootFSCmd())
+	cmd.AddCommand(deleteCmd())
+	cmd.AddCommand(volumesCmd())
+	cmd.AddCommand(deleteVolumeCmd())
+	cmd.AddCommand(execCmd())
 	cmd.AddCommand(statusCmd())
+	cmd.AddCommand(listCmd())
+	cmd.AddCommand(profilesCmd())
+	cmd.AddCommand(serverStatusCmd())
 	cmd.AddCommand(outputCmd())
+	cmd.AddCommand(grepCmd())
+	cmd.AddCommand(exportCmd())
+	cmd.AddCommand(eventsCmd())
+	registerCompletions(cmd)
 
 	if err := cmd.Execute(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// lineWriter is an io.Writer that only writes complete lines to w. A trailing partial line is
+// buffered till the newline arrives or Flush is called.
+type lineWriter struct {
+	w   io.Writer
+	buf []byte // trailing partial line
+}
+
+// Write writes all the complete lines in buf and p to w
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+
+	i := bytes.LastIndexByte(lw.buf, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+
+	if _, err := lw.w.Write(lw.buf[:i+1]); err != nil {
+		return 0, err
+	}
+	lw.buf = append(lw.buf[:0], lw.buf[i+1:]...)
+	return len(p), nil
+}
+
+// Flush writes the trailing partial line to w
+func (lw *lineWriter) Flush() error {
+	if len(lw.buf) == 0 {
+		return nil
+	}
+
+	_, err := lw.w.Write(lw.buf)
+	lw.buf = lw.buf[:0]
+	return err
+}
+
+// ansiState is the state of the ANSI escape sequence parser of ansiWriter
+type ansiState int
+
+const (
+	ansiText   ansiState = iota // not in an escape sequence
+	ansiEscape                  // after ESC
+	ansiCSI                     // in a control sequence, ESC [
+	ansiOSC                     // in an operating system command, ESC ]
+	ansiOSCEsc                  // after ESC in an operating system command
+)
+
+// ansiWriter is an io.Writer that strips ANSI escape sequences before writing to w. Escape
+// sequences split across multiple writes are stripped as well.
+type ansiWriter struct {
+	w     io.Writer
+	state ansiState
+
+	// skipBinary writes the output unchanged if the first write looks like binary data
+	skipBinary bool
+	checked    bool // has the first write been checked for binary data?
+	binary     bool // is the output binary data?
+}
+
+// Write writes p to w without ANSI escape sequences
+func (aw *ansiWriter) Write(p []byte) (int, error) {
+	if aw.skipBinary && !aw.checked {
+		aw.checked = true
+		aw.binary = looksBinary(p)
+	}
+	if aw.binary {
+		return aw.w.Write(p)
+	}
+
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch aw.state {
+		case ansiText:
+			if b == 0x1b {
+				aw.state = ansiEscape
+				continue
+			}
+			out = append(out, b)
+		case ansiEscape:
+			switch b {
+			case '[':
+				aw.state = ansiCSI
+			case ']':
+				aw.state = ansiOSC
+			default:
+				// two byte escape sequence
+				aw.state = ansiText
+			}
+		case ansiCSI:
+			// control sequences are terminated by a byte in the range 0x40–0x7E
+			if b >= 0x40 && b <= 0x7e {
+				aw.state = ansiText
+			}
+		case ansiOSC:
+			// operating system commands are terminated by BEL or ESC \
+			if b == 0x07 {
+				aw.state = ansiText
+			} else if b == 0x1b {
+				aw.state = ansiOSCEsc
+			}
+		case ansiOSCEsc:
+			if b == '\\' {
+				aw.state = ansiText
+			} else {
+				aw.state = ansiOSC
+			}
+		}
+	}
+
+	if _, err := aw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// looksBinary returns true if p looks like binary data rather than text. Like most tools, any NUL
+// byte is taken as a sign of binary data.
+func looksBinary(p []byte) bool {
+	return bytes.IndexByte(p, 0) >= 0
+}
+
+// isTerminal returns true if f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// lineFormatWriter is an io.Writer that formats every line with format before writing it to w.
+// Only complete lines are written, so that the lines of several lineFormatWriters sharing w don't
+// get mixed up. A trailing partial line is buffered till the newline arrives or Flush is called.
+type lineFormatWriter struct {
+	w      io.Writer
+	format func(line []byte) ([]byte, error) // formats a line without its newline
+	buf    []byte                            // trailing partial line
+	mu     *sync.Mutex                       // serializes the writes to w of all the writers sharing it
+}
+
+// Write writes all the complete lines in buf and p to w, each line formatted with format
+func (fw *lineFormatWriter) Write(p []byte) (int, error) {
+	fw.buf = append(fw.buf, p...)
+
+	last := bytes.LastIndexByte(fw.buf, '\n')
+	if last < 0 {
+		return len(p), nil
+	}
+
+	var out []byte
+	for _, line := range bytes.Split(fw.buf[:last], []byte{'\n'}) {
+		formatted, err := fw.format(line)
+		if err != nil {
+			return 0, err
+		}
+		out = append(append(out, formatted...), '\n')
+	}
+	fw.buf = append(fw.buf[:0], fw.buf[last+1:]...)
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if _, err := fw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes the trailing partial line to w, terminated by a newline so that the next line
+// written to w starts on a line of its own
+func (fw *lineFormatWriter) Flush() error {
+	if len(fw.buf) == 0 {
+		return nil
+	}
+
+	formatted, err := fw.format(fw.buf)
+	fw.buf = fw.buf[:0]
+	if err != nil {
+		return err
+	}
+
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	_, err = fw.w.Write(append(formatted, '\n'))
+	return err
+}
+
+// prefixFormat returns a line format that prefixes lines with prefix
+func prefixFormat(prefix string) func(line []byte) ([]byte, error) {
+	return func(line []byte) ([]byte, error) {
+		return append([]byte(prefix), line...), nil
+	}
+}
+
+// outputLine is a line of output passed to the template of the --format flag
+type outputLine struct {
+	ID   string    // ID of the job that produced the line
+	Time time.Time // when the client received the line
+	Line string    // the line without its newline
+}
+
+// templateFormat returns a line format that executes tmpl with the lines of the job id
+func templateFormat(tmpl *template.Template, id string) func(line []byte) ([]byte, error) {
+	return func(line []byte) ([]byte, error) {
+		var out bytes.Buffer
+		err := tmpl.Execute(&out, outputLine{ID: id, Time: time.Now(), Line: string(line)})
+		return out.Bytes(), err
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// admissionRequest is the job proposed to the admission webhook, e.g.
+//
+//	{"cn": "client1", "command": "make test", "args": null, "profile": "default", "name": "test"}
+type admissionRequest struct {
+	CN      string   `json:"cn"`               // common name of the client starting the job
+	Command string   `json:"command"`          // command run in a shell, empty if args are run without one
+	Args    []string `json:"args"`             // exact argv run without a shell, if command is empty
+	Profile string   `json:"profile"`          // resource profile of the job
+	Name    string   `json:"name"`             // name of the job, empty if the job doesn't have a name
+	JobID   string   `json:"job_id,omitempty"` // job the args are executed in, only set by Exec
+}
+
+// admissionResponse is the decision of the admission webhook, e.g.
+//
+//	{"allowed": false, "reason": "builds aren't allowed during the freeze"}
+type admissionResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"` // why the job isn't allowed, returned to the client
+}
+
+// errAdmissionDenied is returned by Admit when the webhook doesn't allow the job
+var errAdmissionDenied = errors.New("Job denied by admission webhook")
+
+// admissionWebhook asks an external webhook whether a job can be started. The proposed job is
+// POSTed to the webhook as an admissionRequest and the webhook answers with an admissionResponse.
+type admissionWebhook struct {
+	url      string        // URL the proposed jobs are POSTed to
+	timeout  time.Duration // how long to wait for the webhook to answer
+	failOpen bool          // allow jobs when the webhook can't be reached or fails
+	client   *http.Client
+}
+
+func newAdmissionWebhook(url string, timeout time.Duration, failOpen bool) *admissionWebhook {
+	return &admissionWebhook{
+		url:      url,
+		timeout:  timeout,
+		failOpen: failOpen,
+		client:   &http.Client{},
+	}
+}
+
+// Admit returns nil if the webhook allows the job. An error wrapping errAdmissionDenied with the
+// reason of the webhook is returned if it doesn't. Any other error means that the webhook failed,
+// in which case the job is denied, unless the webhook fails open.
+func (w *admissionWebhook) Admit(ctx context.Context, req admissionRequest) error {
+	resp, err := w.call(ctx, req)
+	if err != nil {
+		if w.failOpen {
+			log.Printf("Admission webhook failed, allowing job of %s: %v", req.CN, err)
+			return nil
+		}
+		return fmt.Errorf("Admission webhook failed: %w", err)
+	}
+	if !resp.Allowed {
+		if resp.Reason == "" {
+			return errAdmissionDenied
+		}
+		return fmt.Errorf("%w: %s", errAdmissionDenied, resp.Reason)
+	}
+	return nil
+}
+
+// call POSTs the proposed job to the webhook and returns its decision
+func (w *admissionWebhook) call(ctx context.Context, req admissionRequest) (*admissionResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := w.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", httpResp.Status)
+	}
+
+	// the decision is tiny, a huge body means the webhook is misbehaving
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	resp := &admissionResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// caFileExts are the extensions of the files loaded from a directory of CA certificates
+var caFileExts = []string{".crt", ".pem"}
+
+// loadCAs returns the CA certificates at path, either a file with one or more PEM encoded
+// certificates or a directory of such files. Only the files of the directory with an extension in
+// caFileExts are loaded, so that e.g. a README can sit next to them. At least one certificate must
+// be found.
+func loadCAs(path string) ([]*x509.Certificate, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA file %s: %w", path, err)
+		}
+		return certs, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var cas []*x509.Certificate
+	for _, entry := range entries {
+		if entry.IsDir() || !hasCAFileExt(entry.Name()) {
+			continue
+		}
+		// Each file can be a bundle too
+		certs, err := loadCAs(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, certs...)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no CA certificates found in %s", path)
+	}
+	return cas, nil
+}
+
+// hasCAFileExt returns true if the file name has one of caFileExts
+func hasCAFileExt(name string) bool {
+	for _, ext := range caFileExts {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCertificates returns the certificates of the PEM encoded data
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCertificates(t *testing.T) {
+	ca1, _ := newCA(t, "ca1")
+	ca2, _ := newCA(t, "ca2")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca1.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca2.Raw})...)
+
+	certs, err := parseCertificates(data)
+	require.Nil(t, err)
+	require.Len(t, certs, 2)
+	assert.Equal(t, "ca1", certs[0].Subject.CommonName)
+	assert.Equal(t, "ca2", certs[1].Subject.CommonName)
+
+	_, err = parseCertificates([]byte("not a certificate"))
+	assert.NotNil(t, err)
+}
+
+func TestLoadCAs(t *testing.T) {
+	ca1, _ := newCA(t, "ca1")
+	ca2, _ := newCA(t, "ca2")
+	ca3, _ := newCA(t, "ca3")
+	encode := func(certs ...[]byte) []byte {
+		var data []byte
+		for _, der := range certs {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		}
+		return data
+	}
+
+	// a bundle and a single CA in a directory, with a file that isn't a CA file
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "bundle.pem"), encode(ca1.Raw, ca2.Raw), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "ca3.crt"), encode(ca3.Raw), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "README"), []byte("CA certificates"), 0600))
+
+	cas, err := loadCAs(dir)
+	require.Nil(t, err)
+	var names []string
+	for _, ca := range cas {
+		names = append(names, ca.Subject.CommonName)
+	}
+	assert.ElementsMatch(t, []string{"ca1", "ca2", "ca3"}, names)
+
+	// a bundle file
+	cas, err = loadCAs(filepath.Join(dir, "bundle.pem"))
+	require.Nil(t, err)
+	assert.Len(t, cas, 2)
+
+	// a CA file without certificates is an error
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "empty.crt"), nil, 0600))
+	_, err = loadCAs(dir)
+	assert.NotNil(t, err)
+
+	// a directory without CA files is an error
+	_, err = loadCAs(t.TempDir())
+	assert.NotNil(t, err)
+
+	_, err = loadCAs(filepath.Join(dir, "missing.crt"))
+	assert.NotNil(t, err)
+}
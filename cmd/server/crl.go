@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// revocationList rejects the client certificates revoked by a CRL. The CRL is loaded from a file
+// and reloaded whenever the file changes.
+type revocationList struct {
+	path string              // path to the CRL file, PEM or DER encoded
+	cas  []*x509.Certificate // CAs one of which must have signed the CRL
+
+	issuer  *x509.Certificate   // CA that signed the loaded CRL
+	revoked map[string]struct{} // serial numbers of the revoked certificates
+	sync.RWMutex
+}
+
+// newRevocationList loads the CRL file at path, signed by one of cas, and starts watching it for
+// changes
+func newRevocationList(path string, cas []*x509.Certificate) (*revocationList, error) {
+	r := &revocationList{path: path, cas: cas}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	if err := watchFile(path, "CRL", r.load); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// load reads the revoked serial numbers from the CRL file
+func (r *revocationList) load() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	// x509.ParseCRL accepts both PEM and DER
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse CRL file %s: %w", r.path, err)
+	}
+
+	var issuer *x509.Certificate
+	for _, ca := range r.cas {
+		if ca.CheckCRLSignature(crl) == nil {
+			issuer = ca
+			break
+		}
+	}
+	if issuer == nil {
+		return fmt.Errorf("CRL file %s isn't signed by a trusted CA", r.path)
+	}
+	if crl.HasExpired(time.Now()) {
+		// An outdated CRL still revokes what it lists, so it's used anyway
+		log.Printf("WARNING: CRL file %s is past its next update time %v", r.path, crl.TBSCertList.NextUpdate)
+	}
+
+	revoked := make(map[string]struct{}, len(crl.TBSCertList.RevokedCertificates))
+	for _, c := range crl.TBSCertList.RevokedCertificates {
+		revoked[serialKey(c.SerialNumber)] = struct{}{}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.issuer = issuer
+	r.revoked = revoked
+	return nil
+}
+
+// isRevoked returns true if cert was issued by the issuer of the CRL and is revoked by it
+func (r *revocationList) isRevoked(cert *x509.Certificate) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	if !bytes.Equal(cert.RawIssuer, r.issuer.RawSubject) {
+		return false
+	}
+	_, ok := r.revoked[serialKey(cert.SerialNumber)]
+	return ok
+}
+
+// VerifyPeerCertificate is a tls.Config.VerifyPeerCertificate that fails the handshake if a
+// certificate of the verified chains of the client is revoked
+func (r *revocationList) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if r.isRevoked(cert) {
+				return fmt.Errorf("certificate %s with serial number %s is revoked",
+					cert.Subject, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// serialKey returns the key of a serial number in the revoked serial numbers
+func serialKey(serial *big.Int) string {
+	return serial.String()
+}
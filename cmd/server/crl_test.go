@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationList(t *testing.T) {
+	ca, caKey := newCA(t, "ca")
+	client1 := newClientCert(t, ca, caKey, 1)
+	client2 := newClientCert(t, ca, caKey, 2)
+
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	writeCRL(t, path, ca, caKey, 1)
+
+	r, err := newRevocationList(path, []*x509.Certificate{ca})
+	require.Nil(t, err)
+	assert.NotNil(t, r.VerifyPeerCertificate(nil, [][]*x509.Certificate{{client1, ca}}))
+	assert.Nil(t, r.VerifyPeerCertificate(nil, [][]*x509.Certificate{{client2, ca}}))
+
+	// the CRL is reloaded when the file changes
+	writeCRL(t, path, ca, caKey, 2)
+	assert.Eventually(t, func() bool {
+		return r.VerifyPeerCertificate(nil, [][]*x509.Certificate{{client1, ca}}) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotNil(t, r.VerifyPeerCertificate(nil, [][]*x509.Certificate{{client2, ca}}))
+
+	// the previous CRL stays in effect if the file isn't signed by the CA
+	other, otherKey := newCA(t, "other")
+	writeCRL(t, path, other, otherKey, 1)
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, r.VerifyPeerCertificate(nil, [][]*x509.Certificate{{client1, ca}}))
+	assert.NotNil(t, r.VerifyPeerCertificate(nil, [][]*x509.Certificate{{client2, ca}}))
+
+	// a CRL that isn't signed by one of the CAs is an error
+	_, err = newRevocationList(path, []*x509.Certificate{ca})
+	assert.NotNil(t, err)
+}
+
+// newCA returns a self-signed CA certificate with the given common name and its key
+func newCA(t *testing.T, cn string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert, key
+}
+
+// newClientCert returns a client certificate with the given serial number issued by ca
+func newClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert
+}
+
+// writeCRL writes a PEM encoded CRL signed by ca revoking the given serial numbers to path
+func writeCRL(t *testing.T, path string, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serials ...int64) {
+	revoked := make([]pkix.RevokedCertificate, 0, len(serials))
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := ca.CreateCRL(rand.Reader, caKey, revoked, time.Now(), time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ronakg/runner/pkg/lib"
+)
+
+// enforceDeadline stops j if it's still running once the server-enforced deadline expires. The
+// deadline is enforced independently of the library timeout of the job. It isn't enforced if the
+// library timeout of the job expires first, so the two never fire for the same job.
+func (s *runnerServer) enforceDeadline(j *serverJob) {
+	deadline := s.config.jobDeadline
+	if deadline <= 0 || (j.config.Timeout > 0 && j.config.Timeout <= deadline) {
+		return
+	}
+
+	go func() {
+		// The deadline of a scheduled or queued job starts once it's launched, however long it
+		// waited for its start time or a slot of its queue
+		updates, cancel := j.StatusUpdates()
+		defer cancel()
+		for !launched(j) {
+			select {
+			case _, ok := <-updates:
+				if !ok {
+					return
+				}
+			case <-j.Done():
+				return
+			}
+		}
+
+		t := time.NewTimer(deadline)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			log.Printf("Server-enforced deadline of %s exceeded, stopping %s", deadline, j)
+			j.StopAsync()
+		case <-j.Done():
+		}
+	}()
+}
+
+// launched checks whether j has left StatusScheduled and StatusQueued
+func launched(j *serverJob) bool {
+	status, _ := j.Status()
+	return status != lib.StatusScheduled && status != lib.StatusQueued
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/ronakg/runner/pkg/lib"
+)
+
+// diskLimit caps the disk usage of the jobs and volumes under RunnerHome
+type diskLimit struct {
+	limit int64 // maximum number of bytes used by RunnerHome, 0 means unlimited
+	sync.Mutex
+}
+
+// diskUsage returns the number of bytes used by the files of all the jobs and all the volumes
+func diskUsage() (int64, error) {
+	jobs, err := lib.DiskUsage("")
+	if err != nil {
+		return 0, err
+	}
+	volumes, err := volumeUsage("")
+	return jobs + volumes, err
+}
+
+// reclaimDisk removes the files of the oldest finished jobs till the disk usage of RunnerHome is
+// below the disk limit. The files of the jobs retained forever are never removed, and neither are
+// volumes, which are only removed by their clients. It returns false if the disk usage can't be
+// brought below the limit. Concurrent start requests reclaim disk space one at a time.
+func (s *runnerServer) reclaimDisk() (bool, error) {
+	if s.disk.limit <= 0 {
+		return true, nil
+	}
+
+	s.disk.Lock()
+	defer s.disk.Unlock()
+
+	usage, err := diskUsage()
+	if err != nil {
+		return false, err
+	}
+	if usage < s.disk.limit {
+		return true, nil
+	}
+
+	for _, j := range s.jobs.Finished() {
+		if retainedForever(j) {
+			continue
+		}
+		log.Printf("Disk usage %d exceeds %d bytes, removing files of %s", usage, s.disk.limit, j)
+		// the job can't be used without its output, so it's forgotten as well
+		if err := s.removeFiles(j); err != nil {
+			return false, err
+		}
+
+		usage, err = diskUsage()
+		if err != nil {
+			return false, err
+		}
+		if usage < s.disk.limit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/ronakg/runner/pkg/lib"
+	"github.com/ronakg/runner/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	exportManifestVersion = 1         // version of the format of exportManifest
+	exportChunkSize       = 32 * 1024 // maximum number of bytes of the archive sent in one response
+)
+
+// exportManifest describes a job in the archive created by Export. The environment of the job
+// isn't exported, it may hold secrets.
+type exportManifest struct {
+	Version     int          `json:"version"`
+	ID          string       `json:"id"`
+	Client      string       `json:"client"`
+	Command     string       `json:"command,omitempty"`
+	Args        []string     `json:"args,omitempty"`
+	Profile     string       `json:"profile,omitempty"`
+	TimeoutMs   int64        `json:"timeout_ms,omitempty"`
+	Nice        int          `json:"nice,omitempty"`
+	CPUSet      string       `json:"cpuset,omitempty"`
+	Retries     int          `json:"retries,omitempty"`
+	Status      string       `json:"status"`
+	ExitCode    int          `json:"exit_code"`
+	Attempts    []int        `json:"attempts,omitempty"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	Usage       *exportUsage `json:"usage,omitempty"`
+	Output      string       `json:"output,omitempty"` // name of the output in the archive, empty if it's discarded
+	OutputBytes int64        `json:"output_bytes"`
+}
+
+// exportUsage is the resource usage of a job in exportManifest
+type exportUsage struct {
+	UserTimeMs   int64 `json:"user_time_ms"`
+	SystemTimeMs int64 `json:"system_time_ms"`
+	MaxRSSBytes  int64 `json:"max_rss_bytes"`
+}
+
+// newExportManifest describes j, which has reached a terminal state. The output of j is described
+// by the caller.
+func newExportManifest(j *serverJob) exportManifest {
+	status, exitCode := j.Status()
+	m := exportManifest{
+		Version:   exportManifestVersion,
+		ID:        j.ID(),
+		Client:    j.cn,
+		Command:   j.config.Command,
+		Args:      j.config.Args,
+		Profile:   string(j.config.Profile),
+		TimeoutMs: j.config.Timeout.Milliseconds(),
+		Nice:      j.config.Nice,
+		CPUSet:    j.config.CPUSet,
+		Retries:   j.config.Retries,
+		Status:    proto.JobStatus(status).String(),
+		ExitCode:  exitCode,
+		Attempts:  j.Attempts(),
+		StartedAt: j.startedAt.UTC(),
+	}
+	if finishedAt, ok := j.FinishedAt(); ok {
+		finishedAt = finishedAt.UTC()
+		m.FinishedAt = &finishedAt
+	}
+	if usage, ok := j.Usage(); ok {
+		m.Usage = &exportUsage{
+			UserTimeMs:   usage.UserTime.Milliseconds(),
+			SystemTimeMs: usage.SystemTime.Milliseconds(),
+			MaxRSSBytes:  usage.MaxRSS,
+		}
+	}
+	return m
+}
+
+// writeArchive writes a gzipped tar archive with the manifest in manifest.json and the output of
+// the job to w. output writes the m.OutputBytes bytes of the output, it's only called if m.Output
+// is set.
+func writeArchive(w io.Writer, m exportManifest, output func(io.Writer) error) error {
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifest = append(manifest, '\n')
+
+	modTime := m.StartedAt
+	if m.FinishedAt != nil {
+		modTime = *m.FinishedAt
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err = tw.WriteHeader(&tar.Header{
+		Name:    "manifest.json",
+		Mode:    0600,
+		Size:    int64(len(manifest)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	if m.Output != "" {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    m.Output,
+			Mode:    0600,
+			Size:    m.OutputBytes,
+			ModTime: modTime,
+		})
+		if err != nil {
+			return err
+		}
+		if err := output(tw); err != nil {
+			return err
+		}
+	}
+
+	// Close fails if the output was shorter than m.OutputBytes
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readOutput calls fn with the whole output of a finished job, chunk by chunk
+func readOutput(ctx context.Context, j *serverJob, fn func([]byte) error) error {
+	out, cancel, err := j.Output()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for {
+		select {
+		case buf, ok := <-out:
+			if !ok {
+				return nil
+			}
+			if buf.Err != nil {
+				return buf.Err
+			}
+			if err := fn(buf.Bytes); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// exportWriter sends the bytes written to it to the client in ExportResponses
+type exportWriter struct {
+	ctx    context.Context
+	s      *runnerServer
+	cn     string
+	strSrv proto.Runner_ExportServer
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	if err := w.s.rate.Wait(w.ctx, w.cn, len(p)); err != nil {
+		return 0, err
+	}
+	// Send marshals the response before it returns, so p can be reused by the caller
+	if err := w.strSrv.Send(&proto.ExportResponse{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Export streams a gzipped tar archive with the record of a finished job, a manifest.json with its
+// configuration, status, timestamps and resource usage, and its whole output in output.log. The
+// output is read twice, once to size it in the archive and once to archive it.
+func (s *runnerServer) Export(req *proto.ExportRequest, strSrv proto.Runner_ExportServer) error {
+	ctx := strSrv.Context()
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Export request from %s for job id %s", cn, req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	select {
+	case <-j.Done():
+	default:
+		return status.Errorf(codes.FailedPrecondition, "Job %s hasn't finished", req.JobId)
+	}
+	if !s.streams.Acquire(cn) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for %s", cn)
+	}
+	defer s.streams.Release(cn)
+
+	m := newExportManifest(j)
+	if !j.config.DiscardOutput {
+		m.Output = "output.log"
+		err = readOutput(ctx, j, func(b []byte) error {
+			m.OutputBytes += int64(len(b))
+			return nil
+		})
+		if err != nil {
+			return exportError(req.JobId, err)
+		}
+	}
+
+	bw := bufio.NewWriterSize(&exportWriter{ctx: ctx, s: s, cn: cn, strSrv: strSrv}, exportChunkSize)
+	err = writeArchive(bw, m, func(w io.Writer) error {
+		return readOutput(ctx, j, func(b []byte) error {
+			_, err := w.Write(b)
+			return err
+		})
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		log.Printf("Failed to export %s to %s: %v", j, cn, err)
+		return exportError(req.JobId, err)
+	}
+	return nil
+}
+
+// exportError converts an error of Export to a status error
+func exportError(id string, err error) error {
+	switch {
+	case errors.Is(err, lib.ErrTooManyStreams):
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for job %s", id)
+	case errors.Is(err, lib.ErrFilesRemoved):
+		return status.Errorf(codes.NotFound, "Job %s was deleted", id)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Errorf(codes.Internal, "Failed to export job %s: %v", id, err)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readArchive returns the files of a gzipped tar archive by name
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	gr, err := gzip.NewReader(r)
+	require.Nil(t, err)
+	tr := tar.NewReader(gr)
+
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.Nil(t, err)
+		b, err := io.ReadAll(tr)
+		require.Nil(t, err)
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	finishedAt := time.Date(2021, 6, 1, 12, 0, 5, 0, time.UTC)
+	m := exportManifest{
+		Version:     exportManifestVersion,
+		ID:          "abc",
+		Client:      "client1",
+		Command:     "echo 123",
+		Status:      "COMPLETED",
+		StartedAt:   time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		FinishedAt:  &finishedAt,
+		Usage:       &exportUsage{UserTimeMs: 10},
+		Output:      "output.log",
+		OutputBytes: int64(len("123\n")),
+	}
+
+	var buf bytes.Buffer
+	err := writeArchive(&buf, m, func(w io.Writer) error {
+		_, err := io.WriteString(w, "123\n")
+		return err
+	})
+	require.Nil(t, err)
+
+	files := readArchive(t, &buf)
+	require.Len(t, files, 2)
+	assert.Equal(t, "123\n", files["output.log"])
+
+	// the manifest describes the job and the output in the archive
+	var manifest exportManifest
+	require.Nil(t, json.Unmarshal([]byte(files["manifest.json"]), &manifest))
+	assert.Equal(t, m, manifest)
+}
+
+func TestWriteArchiveWithoutOutput(t *testing.T) {
+	m := exportManifest{Version: exportManifestVersion, ID: "abc", Status: "COMPLETED"}
+
+	var buf bytes.Buffer
+	err := writeArchive(&buf, m, func(w io.Writer) error {
+		t.Error("output of a job without output is written")
+		return nil
+	})
+	require.Nil(t, err)
+
+	files := readArchive(t, &buf)
+	require.Len(t, files, 1)
+	assert.Contains(t, files, "manifest.json")
+}
+
+func TestWriteArchiveOutputSize(t *testing.T) {
+	m := exportManifest{Version: exportManifestVersion, ID: "abc", Output: "output.log", OutputBytes: 10}
+
+	// output that doesn't match the size in the manifest, e.g. because it changed in the meantime
+	for _, output := range []string{"12345", strings.Repeat("1", 11)} {
+		err := writeArchive(io.Discard, m, func(w io.Writer) error {
+			_, err := io.WriteString(w, output)
+			return err
+		})
+		assert.NotNil(t, err, output)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ronakg/runner/pkg/proto"
+	"google.golang.org/grpc/status"
+)
+
+// idempotentStart is a start request with an idempotency key. Duplicates of the request wait for
+// the first request to finish and get its response.
+type idempotentStart struct {
+	done    chan struct{}        // closed once the first request finished
+	resp    *proto.StartResponse // response of the first request, set once done is closed
+	expires time.Time            // when the key expires, set once done is closed
+}
+
+// idempotencyKey is a key of idempotencyCache. The keys of different clients never collide.
+type idempotencyKey struct {
+	cn  string // common name of the client that sent the request
+	key string // idempotency key of the request
+}
+
+// idempotencyCache remembers the responses of start requests by their idempotency key per client,
+// so that a retried start request doesn't start the job again
+type idempotencyCache struct {
+	ttl     time.Duration                       // how long a key is remembered once its request finished
+	entries map[idempotencyKey]*idempotentStart // keyed by client and idempotency key
+	sync.Mutex
+}
+
+// Begin returns the request with key of cn. first is true if there's no such request yet, in
+// which case the caller must start the job and call Finish.
+func (c *idempotencyCache) Begin(cn, key string) (call *idempotentStart, first bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	k := idempotencyKey{cn: cn, key: key}
+	if call, ok := c.entries[k]; ok {
+		return call, false
+	}
+	call = &idempotentStart{done: make(chan struct{})}
+	c.entries[k] = call
+	return call, true
+}
+
+// Finish records the response of the first request with key of cn. Failed requests aren't
+// remembered, so that they can be retried.
+func (c *idempotencyCache) Finish(cn, key string, call *idempotentStart, resp *proto.StartResponse, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err != nil {
+		delete(c.entries, idempotencyKey{cn: cn, key: key})
+	} else {
+		call.resp = resp
+		call.expires = time.Now().Add(c.ttl)
+	}
+	close(call.done)
+}
+
+// Wait waits for the first request to finish and returns its response. ok is false if the first
+// request failed, in which case the duplicate has to be started again.
+func (call *idempotentStart) Wait(ctx context.Context) (resp *proto.StartResponse, ok bool, err error) {
+	select {
+	case <-call.done:
+		return call.resp, call.resp != nil, nil
+	case <-ctx.Done():
+		return nil, false, status.FromContextError(ctx.Err()).Err()
+	}
+}
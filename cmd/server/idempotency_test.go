@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ronakg/runner/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	c := idempotencyCache{
+		ttl:     100 * time.Millisecond,
+		entries: make(map[idempotencyKey]*idempotentStart),
+	}
+
+	call, first := c.Begin("client1", "key")
+	require.True(t, first)
+
+	// a duplicate waits for the first request
+	dup, first := c.Begin("client1", "key")
+	require.False(t, first)
+	waited := make(chan *proto.StartResponse)
+	go func() {
+		resp, ok, err := dup.Wait(context.Background())
+		assert.True(t, ok)
+		assert.Nil(t, err)
+		waited <- resp
+	}()
+
+	c.Finish("client1", "key", call, &proto.StartResponse{JobId: "job1"}, nil)
+	assert.Equal(t, "job1", (<-waited).JobId)
+
+	// keys are per client
+	_, first = c.Begin("client2", "key")
+	assert.True(t, first)
+	_, first = c.Begin("bc", "a")
+	require.True(t, first)
+	_, first = c.Begin("c", "ab")
+	assert.True(t, first)
+
+	// failed requests aren't remembered
+	call, first = c.Begin("client1", "failed")
+	require.True(t, first)
+	dup, _ = c.Begin("client1", "failed")
+	c.Finish("client1", "failed", call, nil, errors.New("failed"))
+	_, ok, err := dup.Wait(context.Background())
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	_, first = c.Begin("client1", "failed")
+	assert.True(t, first)
+
+	// keys expire after the TTL
+	time.Sleep(200 * time.Millisecond)
+	_, first = c.Begin("client1", "key")
+	assert.True(t, first)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// identitySource selects the part of a client certificate that identifies the client. The
+// identity of a client owns its jobs and volumes, and its files are grouped under it.
+type identitySource string
+
+const (
+	identityCN     identitySource = "cn"      // common name of the subject
+	identitySANDNS identitySource = "san-dns" // first DNS name in the subject alternative names
+	identitySANURI identitySource = "san-uri" // first URI in the subject alternative names
+	identitySPIFFE identitySource = "spiffe"  // SPIFFE ID in the URI SAN of an X.509 SVID
+)
+
+// clientIdentity is the source of the identity of the clients of the server
+var clientIdentity = identityCN
+
+// spiffeTrustDomain is the only trust domain whose SPIFFE IDs are accepted, any trust domain is
+// accepted if it's empty
+var spiffeTrustDomain string
+
+// parseIdentitySource returns the identity source named s
+func parseIdentitySource(s string) (identitySource, error) {
+	switch source := identitySource(s); source {
+	case identityCN, identitySANDNS, identitySANURI, identitySPIFFE:
+		return source, nil
+	}
+	return "", fmt.Errorf("unknown identity source %q, expected one of %s, %s, %s or %s", s,
+		identityCN, identitySANDNS, identitySANURI, identitySPIFFE)
+}
+
+// certIdentity returns the identity of the client that presented cert. The identity is escaped so
+// that it's a single path element, e.g. spiffe:%2F%2Fexample.org%2Fworkload for the URI
+// spiffe://example.org/workload. Common names that are plain names stay as they are.
+func certIdentity(cert *x509.Certificate, source identitySource) (string, error) {
+	var id string
+	switch source {
+	case identityCN:
+		id = cert.Subject.CommonName
+	case identitySANDNS:
+		if len(cert.DNSNames) > 0 {
+			id = cert.DNSNames[0]
+		}
+	case identitySANURI:
+		if len(cert.URIs) > 0 {
+			id = cert.URIs[0].String()
+		}
+	case identitySPIFFE:
+		spiffeID, err := svidID(cert, spiffeTrustDomain)
+		if err != nil {
+			return "", err
+		}
+		id = spiffeID.String()
+	}
+
+	id = url.PathEscape(id)
+	if id == "" || id == "." || id == ".." {
+		return "", fmt.Errorf("Client certificate has no valid %s identity", source)
+	}
+	return id, nil
+}
+
+// svidID returns the SPIFFE ID of an X.509 SVID. An SVID has exactly one URI SAN, which is a
+// SPIFFE ID of the form spiffe://trust-domain/path. The ID must be in trustDomain, unless it's
+// empty.
+func svidID(cert *x509.Certificate, trustDomain string) (*url.URL, error) {
+	if len(cert.URIs) != 1 {
+		return nil, fmt.Errorf("Client certificate has %d URI SANs, an SVID has exactly one", len(cert.URIs))
+	}
+
+	id := cert.URIs[0]
+	switch {
+	case id.Scheme != "spiffe":
+		return nil, fmt.Errorf("URI SAN %s of the client certificate isn't a SPIFFE ID", id)
+	case id.Host == "" || id.Port() != "" || id.User != nil:
+		return nil, fmt.Errorf("SPIFFE ID %s has an invalid trust domain", id)
+	case id.Path == "" || id.Path == "/" || strings.HasSuffix(id.Path, "/") || id.RawQuery != "" || id.Fragment != "":
+		return nil, fmt.Errorf("SPIFFE ID %s has an invalid path", id)
+	case trustDomain != "" && id.Host != trustDomain:
+		return nil, fmt.Errorf("SPIFFE ID %s isn't in the trust domain %s", id, trustDomain)
+	}
+	return id, nil
+}
+
+// matchIdentity returns true if the escaped identity returned by certIdentity is prefix or is
+// under prefix in the path hierarchy, e.g. spiffe://example.org/ns/prod matches itself and
+// spiffe://example.org/ns/prod/workload, but not spiffe://example.org/ns/production
+func matchIdentity(identity, prefix string) bool {
+	raw, err := url.PathUnescape(identity)
+	if err != nil {
+		return false
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	return raw == prefix || strings.HasPrefix(raw, prefix+"/")
+}
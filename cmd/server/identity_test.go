@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertIdentity(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/prod/workload")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "client1"},
+		DNSNames: []string{"client1.example.org", "client1"},
+		URIs:     []*url.URL{spiffeID},
+	}
+
+	testCases := []struct {
+		name     string            // test case name
+		cert     *x509.Certificate // client certificate
+		source   identitySource    // part of the certificate that identifies the client
+		identity string            // identity of the client
+		nilErr   bool              // nil error from certIdentity?
+	}{
+		{name: "cn", cert: cert, source: identityCN, identity: "client1", nilErr: true},
+		{name: "dns", cert: cert, source: identitySANDNS, identity: "client1.example.org", nilErr: true},
+		{name: "uri", cert: cert, source: identitySANURI, identity: "spiffe:%2F%2Fexample.org%2Fns%2Fprod%2Fworkload", nilErr: true},
+		{name: "no cn", cert: &x509.Certificate{DNSNames: []string{"client1"}}, source: identityCN, nilErr: false},
+		{name: "no dns", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "client1"}}, source: identitySANDNS, nilErr: false},
+		{name: "no uri", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "client1"}}, source: identitySANURI, nilErr: false},
+		{name: "dot dot", cert: &x509.Certificate{Subject: pkix.Name{CommonName: ".."}}, source: identityCN, nilErr: false},
+		{name: "slash", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "../client2"}}, source: identityCN, identity: "..%2Fclient2", nilErr: true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			identity, err := certIdentity(tc.cert, tc.source)
+			assert.Equal(t, tc.nilErr, err == nil, "%v", err)
+			assert.Equal(t, tc.identity, identity)
+		})
+	}
+}
+
+func TestParseIdentitySource(t *testing.T) {
+	for _, s := range []string{"cn", "san-dns", "san-uri", "spiffe"} {
+		source, err := parseIdentitySource(s)
+		assert.Nil(t, err)
+		assert.Equal(t, identitySource(s), source)
+	}
+	_, err := parseIdentitySource("email")
+	assert.NotNil(t, err)
+}
+
+func TestSVIDID(t *testing.T) {
+	testCases := []struct {
+		name        string   // test case name
+		uris        []string // URI SANs of the SVID
+		trustDomain string   // accepted trust domain
+		id          string   // SPIFFE ID of the SVID
+	}{
+		{name: "workload", uris: []string{"spiffe://example.org/ns/prod/workload"}, id: "spiffe://example.org/ns/prod/workload"},
+		{name: "trust domain", uris: []string{"spiffe://example.org/workload"}, trustDomain: "example.org", id: "spiffe://example.org/workload"},
+		{name: "other trust domain", uris: []string{"spiffe://evil.org/workload"}, trustDomain: "example.org"},
+		{name: "no uri", uris: nil},
+		{name: "multiple uris", uris: []string{"spiffe://example.org/a", "spiffe://example.org/b"}},
+		{name: "not spiffe", uris: []string{"https://example.org/workload"}},
+		{name: "no path", uris: []string{"spiffe://example.org"}},
+		{name: "trailing slash", uris: []string{"spiffe://example.org/workload/"}},
+		{name: "port", uris: []string{"spiffe://example.org:443/workload"}},
+		{name: "query", uris: []string{"spiffe://example.org/workload?a=b"}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			id, err := svidID(newSVID(t, tc.uris...), tc.trustDomain)
+			if tc.id == "" {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.id, id.String())
+		})
+	}
+}
+
+func TestMatchIdentity(t *testing.T) {
+	id, err := certIdentity(newSVID(t, "spiffe://example.org/ns/prod/workload"), identitySANURI)
+	require.Nil(t, err)
+
+	assert.True(t, matchIdentity(id, "spiffe://example.org/ns/prod/workload"))
+	assert.True(t, matchIdentity(id, "spiffe://example.org/ns/prod"))
+	assert.True(t, matchIdentity(id, "spiffe://example.org/ns/prod/"))
+	assert.True(t, matchIdentity(id, "spiffe://example.org"))
+	assert.False(t, matchIdentity(id, "spiffe://example.org/ns/pro"))
+	assert.False(t, matchIdentity(id, "spiffe://example.org/ns/prod/workload/1"))
+	assert.False(t, matchIdentity(id, "spiffe://example.com"))
+
+	assert.True(t, matchIdentity("client1", "client1"))
+	assert.False(t, matchIdentity("client1", "client"))
+}
+
+// newSVID returns a self-signed X.509 SVID-style certificate with the given URI SANs
+func newSVID(t *testing.T, uris ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		require.Nil(t, err)
+		template.URIs = append(template.URIs, u)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert
+}
@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ronakg/runner/pkg/certs"
 	"github.com/ronakg/runner/pkg/lib"
 	"github.com/ronakg/runner/pkg/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+
+	// Registers the gzip compressor, responses are compressed when the client compresses its
+	// request with gzip
+	_ "google.golang.org/grpc/encoding/gzip"
 )
 
 func init() {
@@ -21,23 +34,44 @@ func init() {
 	lib.RootFSSource = "/tmp/runner/rootfs"
 }
 
-func createCredentials(certsDir string) (credentials.TransportCredentials, error) {
-	certificate, err := tls.LoadX509KeyPair(
-		filepath.Join(certsDir, "server.crt"),
-		filepath.Join(certsDir, "server.key"),
-	)
-	if err != nil {
-		return nil, err
-	}
+// insecureClientCN is the common name of all the clients of a server run with -insecure
+const insecureClientCN = "insecure"
 
-	data, err := ioutil.ReadFile(filepath.Join(certsDir, "ca.crt"))
+// createCredentials returns the mTLS credentials of the server. The certificate and key of the
+// server are loaded from bundle if its path is set, or from server.crt and server.key in certsDir
+// otherwise. Client certificates must be issued by one of the CAs at caPath, see loadCAs. The CAs
+// in bundle, or ca.crt in certsDir without a bundle, are used if caPath is empty. Client
+// certificates revoked by the CRL file at crlPath are rejected, if crlPath isn't empty.
+func createCredentials(certsDir string, caPath string, crlPath string, bundle pkcs12Bundle) (credentials.TransportCredentials, error) {
+	var certificate tls.Certificate
+	var cas []*x509.Certificate
+	var err error
+	if bundle.path != "" {
+		certificate, cas, err = certs.LoadPKCS12(bundle.path, bundle.password)
+	} else {
+		certificate, err = tls.LoadX509KeyPair(
+			filepath.Join(certsDir, "server.crt"),
+			filepath.Join(certsDir, "server.key"),
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if caPath != "" || bundle.path == "" {
+		if caPath == "" {
+			caPath = filepath.Join(certsDir, "ca.crt")
+		}
+		if cas, err = loadCAs(caPath); err != nil {
+			return nil, err
+		}
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no CA certificates found in %s, set them with -ca", bundle.path)
+	}
 	ca := x509.NewCertPool()
-	if !ca.AppendCertsFromPEM(data) {
-		return nil, fmt.Errorf("failed to append CA certificate")
+	for _, cert := range cas {
+		ca.AddCert(cert)
 	}
 
 	tlsConfig := &tls.Config{
@@ -51,29 +85,211 @@ func createCredentials(certsDir string) (credentials.TransportCredentials, error
 			tls.TLS_CHACHA20_POLY1305_SHA256,
 		},
 	}
-	return credentials.NewTLS(tlsConfig), err
+
+	if crlPath != "" {
+		crl, err := newRevocationList(crlPath, cas)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.VerifyPeerCertificate = crl.VerifyPeerCertificate
+	}
+	return credentials.NewTLS(tlsConfig), nil
 }
 
 func main() {
-	// TODO: configuration for server certificates
-	// ca.crt, server.crt and server.key are looked up in certsDir
-	certsDir := os.Args[1]
-	creds, err := createCredentials(certsDir)
+	lib.RegisterReexec()
+
+	config := serverConfig{}
+	flag.BoolVar(&config.reuseNames, "reuse-names", false, "Return the existing job when a job is started with a name that's in use")
+	flag.Int64Var(&config.diskQuota, "disk-quota", 0, "Maximum number of output and volume bytes per client (default unlimited)")
+	flag.Int64Var(&config.maxDisk, "max-disk", 0, "Maximum number of bytes used by the jobs and volumes in the runner home, the files of the oldest finished jobs are removed to stay below it (default unlimited)")
+	flag.IntVar(&config.maxCommandLen, "max-command-len", 64*1024, "Maximum length of a command in bytes, 0 means unlimited")
+	flag.BoolVar(&config.warnSuspicious, "warn-suspicious", false, "Log commands containing suspicious shell constructs")
+	flag.DurationVar(&config.jobDeadline, "job-deadline", 0, "Maximum wall-clock time a job can run for before it's stopped by the server, e.g. 1h (default no deadline)")
+	flag.IntVar(&config.maxStreams, "max-streams-per-client", 0, "Maximum number of concurrent output streams per client (default unlimited)")
+	flag.Int64Var(&config.maxOutputSize, "max-output-size", 1024*1024, "Maximum number of output bytes of a finished job returned in one response by GetOutput")
+	flag.DurationVar(&config.outputHeartbeat, "output-heartbeat", 0, "Send a heartbeat on output streams that are silent for this long, e.g. 30s (default no heartbeats)")
+	flag.IntVar(&lib.MaxOutputStreams, "max-streams-per-job", 0, "Maximum number of concurrent output streams per job (default unlimited)")
+	flag.StringVar(&lib.ScratchHome, "scratch-dir", "", "Directory where the root filesystems of jobs are created, e.g. on fast scratch storage (default the runner home)")
+	flag.IntVar(&lib.OutputBufferSize, "output-buffer", 16, fmt.Sprintf("Number of 1KiB chunks of output buffered per output stream, at most %d (0 for unbuffered)", lib.MaxOutputBufferSize))
+	envDenyList := flag.String("env-deny", strings.Join(defaultEnvDenyList, ","), "Comma separated environment variables dropped from the environment of jobs, an entry ending in * matches all the variables with its prefix. LD_* and _RUNNER_* variables are always rejected")
+	flag.DurationVar(&config.idempotencyTTL, "idempotency-ttl", 10*time.Minute, "How long the idempotency key of a start request is remembered, a retried request with the key returns the same job")
+	flag.IntVar(&config.maxJobs, "max-jobs", 0, "Maximum number of jobs running at the same time (default unlimited)")
+	flag.IntVar(&config.maxQueued, "max-queued", 0, "Maximum number of jobs waiting for a slot when -max-jobs jobs are running, they're started by priority as running jobs finish. More jobs are rejected, -1 means unlimited")
+	flag.DurationVar(&config.queueAging, "queue-aging", time.Minute, "Raise the priority of a queued job by one for every this long it waits, so that jobs with a low priority aren't starved, 0 disables it")
+	flag.Int64Var(&config.outputRate, "output-rate", 0, "Maximum number of output bytes per second streamed to a client, faster streams are slowed down (default unlimited)")
+	flag.StringVar(&config.preExec, "pre-exec", "", fmt.Sprintf("Shell command run in every job before its command, in the namespaces and root filesystem of the job, a job fails to start if it fails or runs for longer than %s", lib.PreExecTimeout))
+	flag.BoolVar(&config.exposePIDs, "expose-pids", false, "Include the host PID of running jobs in the status returned to the clients that own them, e.g. to attach host tools")
+	flag.DurationVar(&config.outputRetention, "output-retention", 0, "How long the files of a finished job are kept before they're removed and the job is forgotten, unless the job is started with its own retention (default kept till the job is deleted)")
+	outputRates := flag.String("output-rate-per-client", "", "Comma separated cn=bytes-per-second rates overriding -output-rate for specific clients, 0 means unlimited")
+	insecure := flag.Bool("insecure", false, "Serve plaintext gRPC without mTLS on localhost for local development, all clients share one identity. Requires -insecure-confirm")
+	insecureConfirm := flag.Bool("insecure-confirm", false, "Confirm that the server is run with -insecure on purpose")
+	identity := flag.String("identity", string(identityCN), "Part of the client certificates that identifies the clients and owns their jobs, one of cn, san-dns, san-uri or spiffe")
+	flag.StringVar(&spiffeTrustDomain, "spiffe-trust-domain", "", "Only accept the SPIFFE IDs of this trust domain with -identity spiffe (default any trust domain)")
+	pkcs12Path := flag.String("pkcs12", "", "PKCS#12 (.p12 or .pfx) file with the certificate and key of the server and the CA certificates, instead of the files in the certs directory. -ca overrides the CA certificates of the file")
+	pkcs12Pass := flag.String("pkcs12-pass", "", "Password of the -pkcs12 file")
+	caPath := flag.String("ca", "", "PEM file with one or more CA certificates, or a directory of .crt and .pem CA files, that client certificates can be issued by (default ca.crt in the certs directory)")
+	crlFile := flag.String("crl", "", "PEM or DER file with the CRL of the CA, client certificates it revokes are rejected. Reloaded when it changes")
+	policyFile := flag.String("policy", "", "JSON file with the allow and deny rules for commands, reloaded when it changes")
+	admissionURL := flag.String("admission-webhook", "", "URL the proposed jobs are POSTed to for approval before they're started")
+	admissionTimeout := flag.Duration("admission-timeout", 5*time.Second, "How long to wait for the admission webhook to answer")
+	admissionFailOpen := flag.Bool("admission-fail-open", false, "Allow jobs when the admission webhook fails, they're denied otherwise")
+	profileFile := flag.String("profiles", "", "JSON or YAML file with the resource profiles, reloaded on SIGHUP. A profile can extend another profile and override its limits")
+	keepaliveTime := flag.Duration("keepalive-time", 2*time.Hour, "Ping clients after this much inactivity on the connection")
+	keepaliveTimeout := flag.Duration("keepalive-timeout", 20*time.Second, "Close the connection if a ping isn't acknowledged within this time")
+	keepaliveMinTime := flag.Duration("keepalive-min-time", 10*time.Second, "Minimum time clients should wait between pings, connections of clients pinging more often are closed")
+	maxRecvMsgSize := flag.Int("max-recv-msg-size", 4*1024*1024, "Maximum size of a message received from a client in bytes")
+	maxSendMsgSize := flag.Int("max-send-msg-size", math.MaxInt32, "Maximum size of a message sent to a client in bytes")
+	gcJobs := flag.Bool("gc-jobs", false, "Remove the files of jobs without a live process, e.g. left behind by a crashed server, on startup")
+	gcDryRun := flag.Bool("gc-dry-run", false, "Only log the files of jobs that -gc-jobs would remove")
+	pprofAddr := flag.String("pprof-addr", "", "Serve the unauthenticated net/http/pprof endpoints on this address, e.g. localhost:6060 (default disabled)")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "host:port of the OTLP gRPC collector the spans of the RPCs and jobs are exported to (default tracing disabled)")
+	otlpInsecure := flag.Bool("otlp-insecure", false, "Export spans to -otlp-endpoint without TLS")
+	cgroupParent := flag.String("cgroup-parent", "", "Cgroup under which the cgroups of jobs are created, relative to the cgroup root (default \"runner\")")
+	flag.Parse()
+
+	if *envDenyList != "" {
+		config.envDenyList = strings.Split(*envDenyList, ",")
+	}
+
+	rates, err := parseRates(*outputRates)
 	if err != nil {
-		log.Fatalf("Failed to set up certificates: %v", err)
+		log.Fatalf("Invalid -output-rate-per-client: %v", err)
+	}
+	config.outputRates = rates
+
+	if clientIdentity, err = parseIdentitySource(*identity); err != nil {
+		log.Fatalf("Invalid -identity: %v", err)
+	}
+
+	if *cgroupParent != "" {
+		lib.CgroupParent = *cgroupParent
+		if err := lib.ValidateCgroupParent(); err != nil {
+			log.Fatalf("Invalid cgroup parent: %v", err)
+		}
+	}
+
+	if err := lib.ValidateRunnerHome(); err != nil {
+		log.Fatalf("Invalid runner home: %v", err)
+	}
+	if err := lib.ValidateScratchHome(); err != nil {
+		log.Fatalf("Invalid -scratch-dir: %v", err)
+	}
+
+	// No jobs are started by this server yet, so all the job directories without a live process
+	// are left behind by previous runs
+	if *gcJobs || *gcDryRun {
+		dirs, err := lib.RemoveStaleJobDirs(*gcDryRun)
+		for _, dir := range dirs {
+			if *gcDryRun {
+				log.Printf("Would remove stale job directory %s", dir)
+			} else {
+				log.Printf("Removed stale job directory %s", dir)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to remove stale job directories: %v", err)
+		}
+	}
+
+	if *pprofAddr != "" {
+		if err := servePprof(*pprofAddr); err != nil {
+			log.Fatalf("Failed to serve pprof: %v", err)
+		}
+	}
+
+	if *profileFile != "" {
+		if err := lib.LoadProfiles(*profileFile); err != nil {
+			log.Fatalf("Failed to load profiles: %v", err)
+		}
+		log.Printf("Loaded resource profiles %v", lib.ValidProfiles())
+		reloadProfilesOnHUP(*profileFile)
+	}
+
+	if *otlpEndpoint != "" {
+		if err := setupTracing(context.Background(), *otlpEndpoint, *otlpInsecure); err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+	}
+
+	var policy *commandPolicy
+	if *policyFile != "" {
+		var err error
+		policy, err = newCommandPolicy(*policyFile)
+		if err != nil {
+			log.Fatalf("Failed to load policy: %v", err)
+		}
+	}
+
+	var admission *admissionWebhook
+	if *admissionURL != "" {
+		admission = newAdmissionWebhook(*admissionURL, *admissionTimeout, *admissionFailOpen)
 	}
 
 	// TODO: Add configurable server port number
-	lis, err := net.Listen("tcp", ":9000")
+	addr := ":9000"
+	var opts []grpc.ServerOption
+	if *insecure {
+		if !*insecureConfirm {
+			log.Fatal("Refusing to serve without mTLS, -insecure requires -insecure-confirm")
+		}
+		log.Print("WARNING: serving plaintext gRPC without mTLS. Clients aren't authenticated and " +
+			"share the identity " + insecureClientCN + ", don't use -insecure in production")
+		insecureCN = insecureClientCN
+		// Plaintext is only served to local clients
+		addr = "127.0.0.1:9000"
+	} else {
+		// TODO: configuration for server certificates
+		// server.crt and server.key, and ca.crt without -ca, are looked up in certsDir unless
+		// they're loaded from -pkcs12
+		certsDir := flag.Arg(0)
+		bundle := pkcs12Bundle{path: *pkcs12Path, password: *pkcs12Pass}
+		creds, err := createCredentials(certsDir, *caPath, *crlFile, bundle)
+		if err != nil {
+			log.Fatalf("Failed to set up certificates: %v", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	lis, err := net.Listen("tcp", addr)
 
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
-	proto.RegisterRunnerServer(grpcServer, newRunnerServer())
+	grpcServer := grpc.NewServer(append(opts,
+		grpc.MaxRecvMsgSize(*maxRecvMsgSize),
+		grpc.MaxSendMsgSize(*maxSendMsgSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    *keepaliveTime,
+			Timeout: *keepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             *keepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), logUnary),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), logStream),
+	)...)
+	proto.RegisterRunnerServer(grpcServer, newRunnerServer(config, policy, admission))
 
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %s", err)
 	}
 }
+
+// reloadProfilesOnHUP reloads the profile file whenever the server receives SIGHUP. The previous
+// profiles stay in effect if the profile file can't be loaded.
+func reloadProfilesOnHUP(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := lib.LoadProfiles(path); err != nil {
+				log.Printf("Failed to reload profiles, keeping the previous profiles: %v", err)
+				continue
+			}
+			log.Printf("Reloaded resource profiles %v", lib.ValidProfiles())
+		}
+	}()
+}
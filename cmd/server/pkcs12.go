@@ -0,0 +1,8 @@
+package main
+
+// pkcs12Bundle is a PKCS#12 (.p12 or .pfx) file with the certificate and key of the server and,
+// optionally, the CA certificates that client certificates can be issued by
+type pkcs12Bundle struct {
+	path     string
+	password string
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ronakg/runner/pkg/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestLoadPKCS12(t *testing.T) {
+	// the certificate of a CA stands in for the certificate of the server
+	server, serverKey := newCA(t, "server")
+	ca, _ := newCA(t, "ca")
+	dir := t.TempDir()
+
+	data, err := pkcs12.Encode(rand.Reader, serverKey, server, []*x509.Certificate{ca}, "secret")
+	require.Nil(t, err)
+	path := filepath.Join(dir, "server.p12")
+	require.Nil(t, os.WriteFile(path, data, 0600))
+
+	certificate, cas, err := certs.LoadPKCS12(path, "secret")
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{server.Raw}, certificate.Certificate)
+	assert.True(t, serverKey.Equal(certificate.PrivateKey))
+	require.Len(t, cas, 1)
+	assert.Equal(t, "ca", cas[0].Subject.CommonName)
+
+	_, err = createCredentials("", "", "", pkcs12Bundle{path: path, password: "secret"})
+	assert.Nil(t, err)
+
+	_, _, err = certs.LoadPKCS12(path, "wrong")
+	assert.NotNil(t, err)
+
+	// the CA certificates of a bundle without them are set with -ca
+	data, err = pkcs12.Encode(rand.Reader, serverKey, server, nil, "secret")
+	require.Nil(t, err)
+	path = filepath.Join(dir, "server-only.p12")
+	require.Nil(t, os.WriteFile(path, data, 0600))
+	_, err = createCredentials("", "", "", pkcs12Bundle{path: path, password: "secret"})
+	assert.NotNil(t, err)
+
+	caPath := filepath.Join(dir, "ca.crt")
+	require.Nil(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+	_, err = createCredentials("", caPath, "", pkcs12Bundle{path: path, password: "secret"})
+	assert.Nil(t, err)
+}
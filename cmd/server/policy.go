@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// shellMetacharacters are the characters that make a shell command more than a single simple
+// command. Shell commands containing any of them are rejected when the policy has an allow-list.
+const shellMetacharacters = ";&|<>()$`\\\"'*?[]{}~#!\n"
+
+// policyRules represents the content of the policy file, e.g.
+//
+//	{"allow": ["echo", "/usr/bin/make"], "deny": ["rm -rf"], "clients": ["spiffe://example.org/ci"]}
+type policyRules struct {
+	// Allow is the allow-list of executables. If it isn't empty, argv[0] of the jobs run without a
+	// shell must be in the list. Shell commands are only allowed if they're a single simple command
+	// whose first word is in the list.
+	Allow []string `json:"allow"`
+
+	// Deny is a list of substrings that aren't allowed anywhere in the command
+	Deny []string `json:"deny"`
+
+	// Clients is the allow-list of client identities. If it isn't empty, only the clients whose
+	// identity is in the list, or is under an entry of the list in the path hierarchy of SPIFFE
+	// IDs, can run commands.
+	Clients []string `json:"clients"`
+}
+
+// commandPolicy restricts the commands that clients can run. The policy is loaded from a file and
+// reloaded whenever the file changes.
+type commandPolicy struct {
+	path  string      // path to the policy file
+	rules policyRules // rules loaded from the policy file
+	sync.RWMutex
+}
+
+// newCommandPolicy loads the policy file at path and starts watching it for changes
+func newCommandPolicy(path string) (*commandPolicy, error) {
+	p := &commandPolicy{path: path}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+
+	if err := watchFile(path, "policy", p.load); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// load reads the rules from the policy file
+func (p *commandPolicy) load() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	rules := policyRules{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("failed to parse policy file %s: %w", p.path, err)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.rules = rules
+	return nil
+}
+
+// CheckClient returns an error if the client with the identity returned by getClientCN isn't
+// allowed to run commands by the policy
+func (p *commandPolicy) CheckClient(identity string) error {
+	p.RLock()
+	defer p.RUnlock()
+
+	if len(p.rules.Clients) == 0 {
+		return nil
+	}
+	for _, allowed := range p.rules.Clients {
+		if allowed != "" && matchIdentity(identity, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Client %s isn't allowed by the policy", identity)
+}
+
+// Check returns an error describing why the command or args aren't allowed by the policy
+func (p *commandPolicy) Check(command string, args []string) error {
+	p.RLock()
+	defer p.RUnlock()
+
+	full := command
+	if command == "" {
+		full = strings.Join(args, " ")
+	}
+	for _, denied := range p.rules.Deny {
+		if strings.Contains(full, denied) {
+			return fmt.Errorf("Command contains %q, which is denied by the policy", denied)
+		}
+	}
+
+	if len(p.rules.Allow) == 0 {
+		return nil
+	}
+
+	executable := ""
+	if command == "" {
+		executable = args[0]
+	} else {
+		// A shell command can run anything, only allow commands that the shell doesn't interpret
+		if strings.ContainsAny(command, shellMetacharacters) {
+			return fmt.Errorf("Shell constructs aren't allowed by the policy, run the command without a shell")
+		}
+		words := strings.Fields(command)
+		if len(words) == 0 {
+			return fmt.Errorf("Command is empty")
+		}
+		executable = words[0]
+	}
+
+	for _, allowed := range p.rules.Allow {
+		if executable == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s isn't allowed by the policy", executable)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandPolicyCheck(t *testing.T) {
+	testCases := []struct {
+		name    string      // test case name
+		rules   policyRules // rules of the policy
+		command string      // command run in a shell
+		args    []string    // argv run without a shell
+		allowed bool        // is the command allowed?
+	}{
+		{name: "empty policy", rules: policyRules{}, command: "rm -rf / ; echo $(id)", allowed: true},
+		{name: "denied substring", rules: policyRules{Deny: []string{"rm -rf"}}, command: "rm -rf /data", allowed: false},
+		{name: "denied substring in args", rules: policyRules{Deny: []string{"rm -rf"}}, args: []string{"rm", "-rf", "/data"}, allowed: false},
+		{name: "not denied", rules: policyRules{Deny: []string{"rm -rf"}}, command: "rm /data/file", allowed: true},
+		{name: "allowed command", rules: policyRules{Allow: []string{"echo"}}, command: "echo 123", allowed: true},
+		{name: "allowed args", rules: policyRules{Allow: []string{"/usr/bin/make"}}, args: []string{"/usr/bin/make", "test"}, allowed: true},
+		{name: "not allowed command", rules: policyRules{Allow: []string{"echo"}}, command: "cat /etc/passwd", allowed: false},
+		{name: "not allowed args", rules: policyRules{Allow: []string{"echo"}}, args: []string{"cat", "/etc/passwd"}, allowed: false},
+		{name: "allow is exact", rules: policyRules{Allow: []string{"echo"}}, command: "/bin/echo 123", allowed: false},
+		{name: "command separator", rules: policyRules{Allow: []string{"echo"}}, command: "echo 123; cat /etc/passwd", allowed: false},
+		{name: "pipe", rules: policyRules{Allow: []string{"echo"}}, command: "echo 123 | sh", allowed: false},
+		{name: "command substitution", rules: policyRules{Allow: []string{"echo"}}, command: "echo $(id)", allowed: false},
+		{name: "backticks", rules: policyRules{Allow: []string{"echo"}}, command: "echo `id`", allowed: false},
+		{name: "newline", rules: policyRules{Allow: []string{"echo"}}, command: "echo 123\nid", allowed: false},
+		{name: "metacharacters in args", rules: policyRules{Allow: []string{"echo"}}, args: []string{"echo", "$(id);"}, allowed: true},
+		{name: "deny wins over allow", rules: policyRules{Allow: []string{"echo"}, Deny: []string{"secret"}}, command: "echo secret", allowed: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &commandPolicy{rules: tc.rules}
+			err := p.Check(tc.command, tc.args)
+			assert.Equal(t, tc.allowed, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestCommandPolicyCheckClient(t *testing.T) {
+	p := &commandPolicy{}
+	assert.Nil(t, p.CheckClient("client1"))
+
+	p.rules.Clients = []string{"client1", "spiffe://example.org/ns/prod"}
+	assert.Nil(t, p.CheckClient("client1"))
+	assert.NotNil(t, p.CheckClient("client2"))
+
+	id, err := certIdentity(newSVID(t, "spiffe://example.org/ns/prod/workload"), identitySANURI)
+	require.Nil(t, err)
+	assert.Nil(t, p.CheckClient(id))
+	id, err = certIdentity(newSVID(t, "spiffe://example.org/ns/dev/workload"), identitySANURI)
+	require.Nil(t, err)
+	assert.NotNil(t, p.CheckClient(id))
+}
+
+func TestCommandPolicyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.Nil(t, os.WriteFile(path, []byte(`{"allow": ["echo"]}`), 0600))
+
+	p, err := newCommandPolicy(path)
+	require.Nil(t, err)
+	assert.Nil(t, p.Check("echo 123", nil))
+	assert.NotNil(t, p.Check("cat /etc/passwd", nil))
+
+	// the policy is reloaded when the file changes
+	require.Nil(t, os.WriteFile(path, []byte(`{"allow": ["cat"]}`), 0600))
+	assert.Eventually(t, func() bool {
+		return p.Check("cat /etc/passwd", nil) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotNil(t, p.Check("echo 123", nil))
+
+	// the previous policy stays in effect if the file can't be parsed
+	require.Nil(t, os.WriteFile(path, []byte(`{"allow": [`), 0600))
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, p.Check("cat /etc/passwd", nil))
+
+	// a missing policy file is an error
+	_, err = newCommandPolicy(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// servePprof serves the net/http/pprof endpoints under /debug/pprof/ on addr. The endpoints
+// aren't authenticated, so addr should only be reachable by the operators, e.g. localhost:6060.
+func servePprof(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			log.Printf("WARNING: the unauthenticated pprof endpoint is reachable on %s, not just locally", lis.Addr())
+		}
+	}
+
+	log.Printf("Serving pprof on http://%s/debug/pprof/", lis.Addr())
+	go func() {
+		if err := http.Serve(lis, mux); err != nil {
+			log.Printf("pprof endpoint stopped: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import "sync"
+
+// diskQuota tracks the number of output bytes produced by the jobs of each client and the number
+// of bytes in the volumes of each client. The bytes of a job are credited back to its client once
+// the files of the job are removed.
+type diskQuota struct {
+	limit   int64            // maximum number of bytes per client, 0 means unlimited
+	usage   map[string]int64 // number of output bytes per client
+	jobs    map[string]int64 // number of output bytes per job, keyed by job ID and client
+	volumes map[string]int64 // number of bytes in the volumes per client, as last measured
+	sync.Mutex
+}
+
+// Add accounts n output bytes of the job id to cn. It returns true if the quota of cn is exceeded.
+func (q *diskQuota) Add(cn, id string, n int64) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	q.usage[cn] += n
+	q.jobs[id+cn] += n
+	return q.overLimit(cn)
+}
+
+// Release credits the output bytes of the job id back to cn, once the files of the job are removed
+func (q *diskQuota) Release(cn, id string) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.usage[cn] -= q.jobs[id+cn]
+	delete(q.jobs, id+cn)
+	if q.usage[cn] <= 0 {
+		delete(q.usage, cn)
+	}
+}
+
+// SetVolumeUsage records that the volumes of cn use n bytes. The volumes are written by the jobs
+// directly, so their usage is measured rather than accounted as it's produced.
+func (q *diskQuota) SetVolumeUsage(cn string, n int64) {
+	q.Lock()
+	defer q.Unlock()
+
+	if n <= 0 {
+		delete(q.volumes, cn)
+		return
+	}
+	q.volumes[cn] = n
+}
+
+// Exceeded returns true if cn has used all of its quota
+func (q *diskQuota) Exceeded(cn string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.limit > 0 && q.usage[cn]+q.volumes[cn] >= q.limit
+}
+
+// OverLimit returns true if cn has used more than its quota, i.e. Add returned true for a job of cn
+func (q *diskQuota) OverLimit(cn string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.overLimit(cn)
+}
+
+func (q *diskQuota) overLimit(cn string) bool {
+	return q.limit > 0 && q.usage[cn]+q.volumes[cn] > q.limit
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskQuota(t *testing.T) {
+	q := diskQuota{
+		limit:   100,
+		usage:   make(map[string]int64),
+		jobs:    make(map[string]int64),
+		volumes: make(map[string]int64),
+	}
+
+	assert.False(t, q.Add("client1", "job1", 60))
+	assert.False(t, q.Exceeded("client1"))
+	assert.True(t, q.Add("client1", "job2", 50))
+	assert.True(t, q.Exceeded("client1"))
+	assert.True(t, q.OverLimit("client1"))
+
+	// the quota is per client
+	assert.False(t, q.Add("client2", "job1", 60))
+	assert.False(t, q.Exceeded("client2"))
+
+	// the bytes of a job are credited back once its files are removed
+	q.Release("client1", "job2")
+	assert.False(t, q.Exceeded("client1"))
+	assert.False(t, q.OverLimit("client1"))
+	q.Release("client1", "job1")
+	assert.Empty(t, q.jobs["job1client1"])
+	assert.Equal(t, int64(60), q.usage["client2"])
+
+	// a job that hasn't produced any output doesn't change the usage
+	q.Release("client2", "job3")
+	assert.Equal(t, int64(60), q.usage["client2"])
+
+	// the volumes of a client count against its quota
+	q.SetVolumeUsage("client2", 40)
+	assert.True(t, q.Exceeded("client2"))
+	assert.False(t, q.OverLimit("client2"))
+	assert.True(t, q.Add("client2", "job2", 1))
+	q.SetVolumeUsage("client2", 0)
+	assert.False(t, q.Exceeded("client2"))
+	assert.Empty(t, q.volumes)
+
+	// a limit of 0 means unlimited
+	q.limit = 0
+	assert.False(t, q.Add("client2", "job1", 1<<40))
+	assert.False(t, q.Exceeded("client2"))
+}
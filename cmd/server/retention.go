@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ronakg/runner/pkg/lib"
+)
+
+// outputRetention returns the output retention of a start request in milliseconds as a JobConfig
+// OutputRetention, -1 is lib.RetainForever
+func outputRetention(ms int64) time.Duration {
+	if ms == -1 {
+		return lib.RetainForever
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// retention returns how long the files of j are kept once it reaches a terminal state, the
+// retention j was started with or the default retention of the server. 0 or lib.RetainForever
+// means the files are kept till the job is deleted.
+func (s *runnerServer) retention(j *serverJob) time.Duration {
+	if j.config.OutputRetention != 0 {
+		return j.config.OutputRetention
+	}
+	return s.config.outputRetention
+}
+
+// enforceRetention removes the files of j and forgets about it once its retention expires after
+// it reaches a terminal state. Nothing is removed if j is deleted in the meantime.
+func (s *runnerServer) enforceRetention(j *serverJob) {
+	retention := s.retention(j)
+	if retention <= 0 {
+		return
+	}
+
+	go func() {
+		<-j.Done()
+		t := time.NewTimer(retention)
+		defer t.Stop()
+		<-t.C
+
+		// the job may have been deleted, or its files reclaimed, in the meantime
+		if current, ok := s.jobs.Get(idKey(j.cn, j.ID())); !ok || current != j {
+			return
+		}
+		log.Printf("Output retention of %s expired, removing files of %s", retention, j)
+		if err := s.removeFiles(j); err != nil {
+			log.Printf("Failed to remove files of %s: %v", j, err)
+		}
+	}()
+}
+
+// retainedForever returns true if the files of j are kept till it's deleted, even when disk
+// space is reclaimed
+func retainedForever(j *serverJob) bool {
+	return j.config.OutputRetention == lib.RetainForever
+}
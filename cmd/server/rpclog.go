@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ronakg/runner/pkg/lib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// logUnary is a grpc.UnaryServerInterceptor that logs the method, duration and status code of
+// every unary RPC
+func logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Printf("rpc: method=%s duration=%s code=%s", info.FullMethod, time.Since(start), status.Code(err))
+	return resp, err
+}
+
+// logStream is a grpc.StreamServerInterceptor that logs the method, duration and status code of
+// every streaming RPC
+func logStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	log.Printf("rpc: method=%s duration=%s code=%s", info.FullMethod, time.Since(start), status.Code(err))
+	return err
+}
+
+// logJobStatus returns a status change hook that logs the lifecycle of a job started by cn
+func logJobStatus(cn string) func(id string, status lib.JobStatus) {
+	start := time.Now()
+	return func(id string, status lib.JobStatus) {
+		log.Printf("job: id=%s cn=%s status=%s elapsed=%s", id, cn, status, time.Since(start))
+	}
+}
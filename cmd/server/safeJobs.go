@@ -1,27 +1,179 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ronakg/runner/pkg/lib"
+	"github.com/ronakg/runner/pkg/proto"
 )
 
+// serverJob is a job along with the metadata the server keeps about it
+type serverJob struct {
+	lib.Job
+	config    lib.JobConfig // configuration the job was started with
+	cn        string        // common name of the client that started the job
+	startedAt time.Time     // when the job was started
+
+	finishedAt int64 // when the job reached a terminal state in nanoseconds since the epoch
+}
+
+// recordFinish records when j reaches a terminal state and reports the processes it leaked
+func (j *serverJob) recordFinish() {
+	go func() {
+		<-j.Done()
+		atomic.StoreInt64(&j.finishedAt, time.Now().UnixNano())
+		if pids := j.LeakedPIDs(); len(pids) > 0 {
+			log.Printf("%s of %s left processes %v behind, they were killed", j, j.cn, pids)
+		}
+	}()
+}
+
+// FinishedAt returns when j reached a terminal state, false if it hasn't yet
+func (j *serverJob) FinishedAt() (time.Time, bool) {
+	ns := atomic.LoadInt64(&j.finishedAt)
+	if ns == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+func (j *serverJob) String() string {
+	return fmt.Sprint(j.Job)
+}
+
+// jobKey is a key of safeJobs. A job is stored under its ID and, if it has one, under its name.
+// The keys of different clients, and the IDs and names of a client, never collide.
+type jobKey struct {
+	cn   string // common name of the client that started the job
+	name bool   // id is the name of the job rather than its ID
+	id   string
+}
+
+// idKey returns the key of the job with id started by cn
+func idKey(cn, id string) jobKey {
+	return jobKey{cn: cn, id: id}
+}
+
+// nameKey returns the key of the job named name started by cn
+func nameKey(cn, name string) jobKey {
+	return jobKey{cn: cn, name: true, id: name}
+}
+
 type safeJobs struct {
-	table map[string]lib.Job
+	table map[jobKey]*serverJob
 	sync.RWMutex
 }
 
-func (sj *safeJobs) Set(key string, job lib.Job) {
+func (sj *safeJobs) Set(key jobKey, job *serverJob) {
 	sj.Lock()
 	defer sj.Unlock()
 
 	sj.table[key] = job
 }
 
-func (sj *safeJobs) Get(key string) (job lib.Job, ok bool) {
+// SetIfAbsent sets the job for key only if key isn't set already. It returns false if key is
+// already set.
+func (sj *safeJobs) SetIfAbsent(key jobKey, job *serverJob) bool {
+	sj.Lock()
+	defer sj.Unlock()
+
+	if _, ok := sj.table[key]; ok {
+		return false
+	}
+	sj.table[key] = job
+	return true
+}
+
+func (sj *safeJobs) Get(key jobKey) (job *serverJob, ok bool) {
 	sj.RLock()
 	defer sj.RUnlock()
 
 	job, ok = sj.table[key]
 	return
 }
+
+// Lookup returns the job started by cn with the ID or name ref. IDs take precedence over names.
+func (sj *safeJobs) Lookup(cn, ref string) (job *serverJob, ok bool) {
+	if job, ok = sj.Get(idKey(cn, ref)); ok {
+		return job, true
+	}
+	return sj.Get(nameKey(cn, ref))
+}
+
+// Remove removes all the keys of job
+func (sj *safeJobs) Remove(job *serverJob) {
+	sj.Lock()
+	defer sj.Unlock()
+
+	for key, j := range sj.table {
+		if j == job {
+			delete(sj.table, key)
+		}
+	}
+}
+
+// List returns the jobs started by cn in the order requested by the client. Jobs started at the
+// same time keep the order of their IDs, so that the order is stable across requests.
+func (sj *safeJobs) List(cn string, order proto.ListOrder) []*serverJob {
+	sj.RLock()
+	var jobs []*serverJob
+	for key, j := range sj.table {
+		// a job is stored under its ID and its name, only the ID key is listed
+		if key.cn == cn && !key.name {
+			jobs = append(jobs, j)
+		}
+	}
+	sj.RUnlock()
+
+	sort.Slice(jobs, func(i, k int) bool {
+		if !jobs[i].startedAt.Equal(jobs[k].startedAt) {
+			return jobs[i].startedAt.Before(jobs[k].startedAt)
+		}
+		return jobs[i].ID() < jobs[k].ID()
+	})
+	switch order {
+	case proto.ListOrder_NEWEST_FIRST:
+		for i, k := 0, len(jobs)-1; i < k; i, k = i+1, k-1 {
+			jobs[i], jobs[k] = jobs[k], jobs[i]
+		}
+	case proto.ListOrder_BY_STATUS:
+		sort.SliceStable(jobs, func(i, k int) bool {
+			si, _ := jobs[i].Status()
+			sk, _ := jobs[k].Status()
+			return si < sk
+		})
+	}
+	return jobs
+}
+
+// Finished returns the jobs that reached a terminal state, the oldest job first
+func (sj *safeJobs) Finished() []*serverJob {
+	sj.RLock()
+	defer sj.RUnlock()
+
+	// a job is stored under its ID and its name
+	seen := make(map[*serverJob]bool)
+	var jobs []*serverJob
+	for _, j := range sj.table {
+		if seen[j] {
+			continue
+		}
+		seen[j] = true
+
+		select {
+		case <-j.Done():
+			jobs = append(jobs, j)
+		default:
+		}
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].startedAt.Before(jobs[k].startedAt)
+	})
+	return jobs
+}
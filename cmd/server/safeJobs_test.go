@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeJobsKeys(t *testing.T) {
+	jobs := safeJobs{table: make(map[jobKey]*serverJob)}
+
+	// the names and IDs of different clients concatenate to the same string
+	j1 := &serverJob{cn: "ef"}
+	j2 := &serverJob{cn: "def"}
+	assert.True(t, jobs.SetIfAbsent(nameKey("ef", "abcd"), j1))
+	assert.True(t, jobs.SetIfAbsent(nameKey("def", "abc"), j2))
+
+	j, ok := jobs.Lookup("ef", "abcd")
+	assert.True(t, ok)
+	assert.Same(t, j1, j)
+	j, ok = jobs.Lookup("def", "abc")
+	assert.True(t, ok)
+	assert.Same(t, j2, j)
+	_, ok = jobs.Lookup("def", "abcd")
+	assert.False(t, ok)
+
+	// a name doesn't shadow the ID of another job of the same client
+	id := "0123456789abcdef01234567"
+	j3 := &serverJob{cn: "ef"}
+	jobs.Set(idKey("ef", id), j3)
+	assert.True(t, jobs.SetIfAbsent(nameKey("ef", id), j1))
+	j, ok = jobs.Lookup("ef", id)
+	assert.True(t, ok)
+	assert.Same(t, j3, j)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+const (
+	maxSearchContext = 100       // maximum number of context lines around a matching line
+	maxSearchLineLen = 64 * 1024 // lines are truncated to this many bytes before they're matched
+)
+
+// newLineMatcher returns a function that reports whether a line matches pattern, which is a
+// substring or an RE2 regular expression if isRegexp is true
+func newLineMatcher(pattern string, isRegexp bool) (func([]byte) bool, error) {
+	if !isRegexp {
+		substr := []byte(pattern)
+		return func(line []byte) bool {
+			return bytes.Contains(line, substr)
+		}, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.Match, nil
+}
+
+// searchLine is a line of output sent by SearchOutput
+type searchLine struct {
+	number int64  // number of the line, starting at 1
+	line   []byte // the line without its newline
+	match  bool   // the line matches, it's a context line otherwise
+}
+
+// grepper splits output into lines and emits the matching lines with the lines of context around
+// them. Every line is emitted at most once, in order.
+type grepper struct {
+	match   func([]byte) bool      // reports whether a line matches
+	context int                    // number of lines emitted before and after every match
+	emit    func(searchLine) error // called for every emitted line
+
+	partial   []byte       // the unterminated line at the end of the output written so far
+	truncated bool         // partial is already maxSearchLineLen bytes long
+	number    int64        // number of the last complete line
+	before    []searchLine // the last lines that weren't emitted, up to context of them
+	after     int          // number of lines still to be emitted after the last match
+}
+
+// Write matches the complete lines in p, the rest of p is kept till its line is complete
+func (g *grepper) Write(p []byte) error {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			g.appendPartial(p)
+			return nil
+		}
+		g.appendPartial(p[:i])
+		p = p[i+1:]
+
+		line := g.partial
+		g.partial, g.truncated = nil, false
+		if err := g.line(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush matches the unterminated line at the end of the output, if any
+func (g *grepper) Flush() error {
+	if len(g.partial) == 0 {
+		return nil
+	}
+	line := g.partial
+	g.partial, g.truncated = nil, false
+	return g.line(line)
+}
+
+// appendPartial appends b to the unterminated line, lines longer than maxSearchLineLen are
+// truncated
+func (g *grepper) appendPartial(b []byte) {
+	if g.truncated {
+		return
+	}
+	if n := maxSearchLineLen - len(g.partial); len(b) >= n {
+		b = b[:n]
+		g.truncated = true
+	}
+	// partial is copied, so that the lines don't share the buffer of the output
+	g.partial = append(g.partial, b...)
+}
+
+// line matches a complete line and emits it if it matches or is in the context of a match
+func (g *grepper) line(line []byte) error {
+	g.number++
+	l := searchLine{number: g.number, line: line}
+
+	if g.match(line) {
+		for _, b := range g.before {
+			if err := g.emit(b); err != nil {
+				return err
+			}
+		}
+		g.before = g.before[:0]
+		g.after = g.context
+		l.match = true
+		return g.emit(l)
+	}
+	if g.after > 0 {
+		g.after--
+		return g.emit(l)
+	}
+	if g.context > 0 {
+		if len(g.before) == g.context {
+			copy(g.before, g.before[1:])
+			g.before = g.before[:len(g.before)-1]
+		}
+		g.before = append(g.before, l)
+	}
+	return nil
+}
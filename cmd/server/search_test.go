@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLineMatcher(t *testing.T) {
+	match, err := newLineMatcher("a.c", false)
+	require.Nil(t, err)
+	assert.True(t, match([]byte("xa.cx")))
+	assert.False(t, match([]byte("abc")))
+
+	match, err = newLineMatcher("^a.c$", true)
+	require.Nil(t, err)
+	assert.True(t, match([]byte("abc")))
+	assert.False(t, match([]byte("xabc")))
+
+	_, err = newLineMatcher("a(", true)
+	assert.NotNil(t, err)
+}
+
+func TestGrepper(t *testing.T) {
+	testCases := []struct {
+		name     string   // test case name
+		chunks   []string // output written to the grepper
+		context  int      // number of context lines
+		expected []string // emitted lines as <number>:<line> for matches and <number>-<line> for context
+	}{
+		{
+			name:     "no match",
+			chunks:   []string{"a\nb\nc\n"},
+			expected: nil,
+		},
+		{
+			name:     "matches",
+			chunks:   []string{"match 1\nb\nmatch 3\n"},
+			expected: []string{"1:match 1", "3:match 3"},
+		},
+		{
+			name:     "lines split across chunks",
+			chunks:   []string{"a\nmat", "ch 2", "\nc\n"},
+			expected: []string{"2:match 2"},
+		},
+		{
+			name:     "unterminated last line",
+			chunks:   []string{"a\nmatch 2"},
+			expected: []string{"2:match 2"},
+		},
+		{
+			name:     "context",
+			chunks:   []string{"a\nb\nc\nmatch 4\ne\nf\ng\n"},
+			context:  2,
+			expected: []string{"2-b", "3-c", "4:match 4", "5-e", "6-f"},
+		},
+		{
+			name:     "overlapping context",
+			chunks:   []string{"match 1\nb\nmatch 3\nd\ne\nf\nmatch 7\n"},
+			context:  1,
+			expected: []string{"1:match 1", "2-b", "3:match 3", "4-d", "6-f", "7:match 7"},
+		},
+		{
+			name:     "context at the start",
+			chunks:   []string{"match 1\nb\nc\n"},
+			context:  5,
+			expected: []string{"1:match 1", "2-b", "3-c"},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			match, err := newLineMatcher("match", false)
+			require.Nil(t, err)
+
+			var emitted []string
+			g := &grepper{
+				match:   match,
+				context: tc.context,
+				emit: func(l searchLine) error {
+					sep := "-"
+					if l.match {
+						sep = ":"
+					}
+					emitted = append(emitted, fmt.Sprintf("%d%s%s", l.number, sep, l.line))
+					return nil
+				},
+			}
+			for _, chunk := range tc.chunks {
+				require.Nil(t, g.Write([]byte(chunk)))
+			}
+			require.Nil(t, g.Flush())
+			assert.Equal(t, tc.expected, emitted)
+		})
+	}
+}
+
+func TestGrepperLongLine(t *testing.T) {
+	match, err := newLineMatcher("a", false)
+	require.Nil(t, err)
+
+	var lines []searchLine
+	g := &grepper{
+		match: match,
+		emit: func(l searchLine) error {
+			lines = append(lines, l)
+			return nil
+		},
+	}
+	long := strings.Repeat("a", maxSearchLineLen+10)
+	require.Nil(t, g.Write([]byte(long[:maxSearchLineLen/2])))
+	require.Nil(t, g.Write([]byte(long[maxSearchLineLen/2:]+"\na\n")))
+	require.Nil(t, g.Flush())
+
+	// the long line is truncated and doesn't affect the numbers of the next lines
+	require.Len(t, lines, 2)
+	assert.Equal(t, int64(1), lines[0].number)
+	assert.Len(t, lines[0].line, maxSearchLineLen)
+	assert.Equal(t, int64(2), lines[1].number)
+	assert.Equal(t, "a", string(lines[1].line))
+}
@@ -2,8 +2,14 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"math"
+	"regexp"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ronakg/runner/pkg/lib"
@@ -14,25 +20,148 @@
 	"google.golang.org/grpc/status"
 )
 
+// reconcileTimeout is how long Status waits for a job whose process died out of band to reach a
+// terminal state
+const reconcileTimeout = time.Second
+
+// jobNameRegexp matches valid client provided job names
+var jobNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// serverConfig represents the configuration of the runner server
+type serverConfig struct {
+	reuseNames     bool  // return the existing job when a job is started with a name that's in use
+	diskQuota      int64 // maximum number of output and volume bytes per client, 0 means unlimited
+	maxCommandLen  int   // maximum length of a command in bytes, 0 means unlimited
+	warnSuspicious bool  // log commands containing suspicious shell constructs
+
+	// jobDeadline is the maximum wall-clock time a job can run for before it's stopped by the
+	// server, regardless of its timeout. 0 means no deadline.
+	jobDeadline time.Duration
+
+	maxStreams int // maximum number of concurrent output streams per client, 0 means unlimited
+
+	maxOutputSize int64 // maximum number of output bytes returned by GetOutput
+
+	// maxDisk is the maximum number of bytes used by the jobs and volumes under RunnerHome, the
+	// files of the oldest finished jobs are removed to stay below it. 0 means unlimited.
+	maxDisk int64
+
+	// outputHeartbeat is how long an output stream can be silent before a heartbeat is sent to
+	// the client. 0 means no heartbeats.
+	outputHeartbeat time.Duration
+
+	// envDenyList are the environment variables dropped from the environment of a job, an entry
+	// ending in * matches all the variables with its prefix
+	envDenyList []string
+
+	idempotencyTTL time.Duration // how long the idempotency key of a start request is remembered
+
+	// maxJobs is the maximum number of jobs running at the same time, 0 means unlimited. Up to
+	// maxQueued more jobs wait for a running job to finish, the jobs beyond that are rejected.
+	maxJobs   int
+	maxQueued int
+
+	// outputRate is the maximum number of output bytes per second streamed to a client, 0 means
+	// unlimited. outputRates overrides it for specific clients.
+	outputRate  int64
+	outputRates map[string]int64
+
+	// queueAging is how long a job waits for a slot before its priority is raised by one, 0 means
+	// it's never raised
+	queueAging time.Duration
+
+	// exposePIDs includes the host PID of running jobs in the status of the jobs, it exposes host
+	// internals to the clients
+	exposePIDs bool
+
+	// outputRetention is how long the files of a finished job are kept before they're removed and
+	// the job is forgotten, unless the job is started with its own retention. 0 means they're kept
+	// till the job is deleted.
+	outputRetention time.Duration
+
+	// preExec is a shell command run in every job before its command, e.g. to seed files. It's
+	// set by the operator of the server, clients can't supply their own. Empty means no hook.
+	preExec string
+}
+
 type runnerServer struct {
 	proto.UnimplementedRunnerServer
-	jobs safeJobs
+	config  serverConfig
+	jobs    safeJobs
+	quota   diskQuota
+	disk    diskLimit
+	streams streamLimit
+	rate    outputThrottle
+	starts  idempotencyCache
+	volumes sync.RWMutex   // held for writing while a volume is deleted
+	queue   *lib.Queue     // limits the number of jobs running at the same time
+	policy  *commandPolicy // restricts the commands that clients can run, nil if there's no policy
+
+	// admission decides whether jobs can be started, nil if there's no admission webhook
+	admission *admissionWebhook
 }
 
-func newRunnerServer() *runnerServer {
+func newRunnerServer(config serverConfig, policy *commandPolicy, admission *admissionWebhook) *runnerServer {
+	// The queue counts the running jobs even if their number is unlimited
+	maxJobs := config.maxJobs
+	if maxJobs <= 0 {
+		maxJobs = math.MaxInt32
+	}
+
 	return &runnerServer{
+		queue:     lib.NewQueue(maxJobs, config.maxQueued, config.queueAging),
+		config:    config,
+		policy:    policy,
+		admission: admission,
 		jobs: safeJobs{
-			table: make(map[string]lib.Job),
+			table: make(map[jobKey]*serverJob),
+		},
+		quota: diskQuota{
+			limit:   config.diskQuota,
+			usage:   make(map[string]int64),
+			jobs:    make(map[string]int64),
+			volumes: make(map[string]int64),
+		},
+		disk: diskLimit{
+			limit: config.maxDisk,
+		},
+		streams: streamLimit{
+			limit:  config.maxStreams,
+			active: make(map[string]int),
+		},
+		rate: outputThrottle{
+			rate:    config.outputRate,
+			rates:   config.outputRates,
+			buckets: make(map[string]*tokenBucket),
+		},
+		starts: idempotencyCache{
+			ttl:     config.idempotencyTTL,
+			entries: make(map[idempotencyKey]*idempotentStart),
 		},
 	}
 }
 
+// insecureCN is the common name of all the clients of a server that runs without TLS, empty if
+// the server runs with mTLS
+var insecureCN string
+
+// getClientCN returns the identity of the client, the common name of its certificate unless
+// another clientIdentity is configured
 func getClientCN(ctx context.Context) (string, error) {
 	var cn string
 	peer, ok := peer.FromContext(ctx)
 	if ok {
-		tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-		cn = tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+		switch tlsInfo, isTLS := peer.AuthInfo.(credentials.TLSInfo); {
+		case isTLS && len(tlsInfo.State.VerifiedChains) > 0:
+			id, err := certIdentity(tlsInfo.State.VerifiedChains[0][0], clientIdentity)
+			if err != nil {
+				log.Print(err)
+				return "", err
+			}
+			cn = id
+		case !isTLS:
+			cn = insecureCN
+		}
 	}
 	if cn == "" {
 		log.Printf("Client did not provide common name")
@@ -47,26 +176,301 @@ func (s *runnerServer) Start(ctx context.Context, req *proto.StartRequest) (*pro
 		return nil, status.Errorf(codes.Unauthenticated, err.Error())
 	}
 
+	// A retried request with the same idempotency key gets the response of the first request
+	for req.IdempotencyKey != "" {
+		call, first := s.starts.Begin(cn, req.IdempotencyKey)
+		if first {
+			resp, err := s.start(ctx, cn, req)
+			s.starts.Finish(cn, req.IdempotencyKey, call, resp, err)
+			return resp, err
+		}
+
+		resp, ok, err := call.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			log.Printf("Returning %s to %s for idempotency key %s", resp.JobId, cn, req.IdempotencyKey)
+			return resp, nil
+		}
+		// the first request failed, try again
+	}
+	return s.start(ctx, cn, req)
+}
+
+// start starts the job of a start request by cn
+func (s *runnerServer) start(ctx context.Context, cn string, req *proto.StartRequest) (*proto.StartResponse, error) {
+	if err := validateEnv(req.Env); err != nil {
+		log.Printf("Rejected environment from %s: %v", cn, err)
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	err := s.checkCommand(ctx, admissionRequest{
+		CN:      cn,
+		Command: req.Command,
+		Args:    req.Args,
+		Profile: req.Profile,
+		Name:    req.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		if !jobNameRegexp.MatchString(req.Name) {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid job name %s", req.Name)
+		}
+		if j, ok := s.jobs.Get(nameKey(cn, req.Name)); ok {
+			return s.existingJob(j, req.Name)
+		}
+	}
+
+	if len(req.Volumes) > 0 {
+		// a volume isn't deleted till the job that mounts it is stored and can be found
+		s.volumes.RLock()
+		defer s.volumes.RUnlock()
+	}
+
+	if s.quota.limit > 0 {
+		usage, err := volumeUsage(cn)
+		if err != nil {
+			log.Printf("Failed to measure the volumes of %s: %v", cn, err)
+			return nil, status.Errorf(codes.Internal, "Failed to measure the volumes of %s: %v", cn, err)
+		}
+		s.quota.SetVolumeUsage(cn, usage)
+	}
+	if s.quota.Exceeded(cn) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Disk quota exceeded for %s", cn)
+	}
+	reclaimed, err := s.reclaimDisk()
+	if err != nil {
+		log.Printf("Failed to reclaim disk space: %v", err)
+		return nil, status.Errorf(codes.Internal, "Failed to reclaim disk space: %v", err)
+	}
+	if !reclaimed {
+		log.Printf("Disk limit of %d bytes reached, rejecting job of %s", s.disk.limit, cn)
+		return nil, status.Errorf(codes.ResourceExhausted, "Disk limit of %d bytes reached", s.disk.limit)
+	}
+
+	if int(req.Nice) < lib.MinNice || int(req.Nice) > lib.MaxNice {
+		return nil, status.Errorf(codes.InvalidArgument, "Nice level %d is out of range [%d, %d]",
+			req.Nice, lib.MinNice, lib.MaxNice)
+	}
+	if int(req.Retries) < 0 || int(req.Retries) > lib.MaxRetries || req.RetryBackoffMs < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Retries %d is out of range [0, %d] or retry backoff %dms is negative",
+			req.Retries, lib.MaxRetries, req.RetryBackoffMs)
+	}
+	if int(req.Priority) < 0 || int(req.Priority) > lib.MaxPriority {
+		return nil, status.Errorf(codes.InvalidArgument, "Priority %d is out of range [0, %d]", req.Priority, lib.MaxPriority)
+	}
+	if req.InactivityTimeoutMs < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Negative inactivity timeout %dms", req.InactivityTimeoutMs)
+	}
+	if req.OutputRetentionMs < -1 {
+		return nil, status.Errorf(codes.InvalidArgument, "Output retention %dms is negative and isn't -1", req.OutputRetentionMs)
+	}
+	startAt, err := startTime(req.StartAfterMs, req.StartAt, time.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	mounts, err := volumeMounts(cn, req.Volumes)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	env, droppedEnv := sanitizeEnv(req.Env, s.config.envDenyList)
+	if len(droppedEnv) > 0 {
+		log.Printf("Dropped environment variables %q of %s", droppedEnv, cn)
+	}
+
+	span := startJobSpan(ctx, cn)
+	logStatus := logJobStatus(cn)
+
+	timeout := time.Duration(req.Timeout) * time.Second
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+
 	config := lib.JobConfig{
 		Command: req.Command,
-		Timeout: time.Duration(req.Timeout) * time.Second,
+		Args:    req.Args,
+		Timeout: timeout,
 		Profile: lib.ResProfile(req.Profile),
+		Nice:    int(req.Nice),
+		CPUSet:  req.Cpuset,
+		Mounts:  mounts,
+		Env:     env,
+
+		KeepRootFS:   req.KeepRootfs,
+		Retries:      int(req.Retries),
+		RetryBackoff: time.Duration(req.RetryBackoffMs) * time.Millisecond,
+		StartAt:      startAt,
+		Queue:        s.queue,
+		Priority:     int(req.Priority),
+
+		PreExec:           s.config.preExec,
+		InactivityTimeout: time.Duration(req.InactivityTimeoutMs) * time.Millisecond,
+		OutputRetention:   outputRetention(req.OutputRetentionMs),
+
+		// group the files of the jobs started by a client under <RunnerHome>/jobs/<cn>
+		Namespace: cn,
+		OnStatusChange: func(id string, status lib.JobStatus) {
+			logStatus(id, status)
+			span.statusChanged(id, status)
+		},
+		OnOutput: s.enforceQuota(cn),
 	}
-	log.Printf("Start request: %+v", config)
+	logged := config
+	logged.Env = envKeys(config.Env)
+	log.Printf("Start request: %+v", logged)
 
-	j, err := lib.StartJob(config)
+	// A job started for a client that's gone would be leaked, the client never learns its ID
+	lj, err := lib.StartJobContext(ctx, config)
 	if err != nil {
+		span.fail(err)
+		if ctx.Err() != nil {
+			log.Printf("%s canceled the start request", cn)
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		var setupErr *lib.SetupError
+		if errors.As(err, &setupErr) {
+			log.Printf("Host isn't set up to run jobs: %v", err)
+			return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, lib.ErrQueueFull) {
+			log.Printf("Maximum number of jobs reached, rejecting job of %s", cn)
+			return nil, status.Errorf(codes.ResourceExhausted, "Maximum number of %d running jobs and %d queued jobs reached",
+				s.config.maxJobs, s.config.maxQueued)
+		}
+		if errors.Is(err, lib.ErrUnknownProfile) || errors.Is(err, lib.ErrInvalidEnv) || errors.Is(err, lib.ErrInvalidCPUSet) {
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, lib.ErrSetupFailed) {
+			log.Printf("Failed to set up job for %s: %v", cn, err)
+			return nil, status.Errorf(codes.Internal, err.Error())
+		}
 		return nil, status.Errorf(codes.Unknown, err.Error())
 	}
+	j := &serverJob{
+		Job:       lj,
+		config:    config,
+		cn:        cn,
+		startedAt: time.Now(),
+	}
+	if ctx.Err() != nil {
+		log.Printf("%s canceled the start request, stopping %s", cn, j)
+		j.Stop()
+		if err := s.removeFiles(j); err != nil {
+			log.Printf("Failed to remove files of %s: %v", j, err)
+		}
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
 	log.Printf("%s started successfully", j)
 
-	s.jobs.Set(j.ID()+cn, j)
+	s.jobs.Set(idKey(cn, j.ID()), j)
+
+	// The output of the job may have exceeded the quota before the job was stored, when
+	// enforceQuota can't find it to stop it
+	if s.quota.OverLimit(cn) {
+		log.Printf("Disk quota exceeded for %s, stopping %s", cn, j)
+		j.StopAsync()
+	}
+
+	// A concurrent request may have started a job with the same name in the meantime, the job that
+	// lost the name is removed as if it was never started
+	if req.Name != "" && !s.jobs.SetIfAbsent(nameKey(cn, req.Name), j) {
+		log.Printf("Name %s is taken by a concurrent start request, removing %s", req.Name, j)
+		j.Stop()
+		if err := s.removeFiles(j); err != nil {
+			log.Printf("Failed to remove files of %s: %v", j, err)
+			s.jobs.Remove(j)
+		}
+		existing, _ := s.jobs.Get(nameKey(cn, req.Name))
+		return s.existingJob(existing, req.Name)
+	}
+
+	j.recordFinish()
+	s.enforceDeadline(j)
+	s.enforceRetention(j)
+
+	return &proto.StartResponse{
+		JobId:      j.ID(),
+		DroppedEnv: droppedEnv,
+	}, nil
+}
+
+// checkCommand runs the checks a command has to pass before it's run by Start or Exec: the length
+// limit, the command policy and the admission webhook. The returned error is a status error.
+func (s *runnerServer) checkCommand(ctx context.Context, req admissionRequest) error {
+	if err := validateCommand(req.Command, req.Args, s.config.maxCommandLen); err != nil {
+		// don't log the whole command, it may be huge
+		log.Printf("Rejected command from %s: %v", req.CN, err)
+		return status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if s.policy != nil {
+		if err := s.policy.CheckClient(req.CN); err != nil {
+			log.Printf("Client %s denied by policy", req.CN)
+			return status.Errorf(codes.PermissionDenied, err.Error())
+		}
+		if err := s.policy.Check(req.Command, req.Args); err != nil {
+			log.Printf("Command from %s denied by policy: %v", req.CN, err)
+			return status.Errorf(codes.PermissionDenied, err.Error())
+		}
+	}
+	if s.config.warnSuspicious {
+		if found := findSuspicious(req.Command); len(found) > 0 {
+			log.Printf("WARNING: command from %s contains suspicious constructs %q: %s", req.CN, found,
+				req.Command)
+		}
+	}
+	if s.admission != nil {
+		if err := s.admission.Admit(ctx, req); err != nil {
+			// the job is denied when the webhook fails too, unless it fails open
+			log.Printf("Job of %s isn't admitted: %v", req.CN, err)
+			return status.Errorf(codes.PermissionDenied, err.Error())
+		}
+	}
+	return nil
+}
 
+// existingJob returns the response for a start request with a name that's already in use
+func (s *runnerServer) existingJob(j *serverJob, name string) (*proto.StartResponse, error) {
+	if !s.config.reuseNames {
+		return nil, status.Errorf(codes.AlreadyExists, "Job %s already exists", name)
+	}
+
+	log.Printf("Reusing %s for name %s", j, name)
 	return &proto.StartResponse{
 		JobId: j.ID(),
 	}, nil
 }
 
+// removeFiles removes the files of a finished job and forgets about the job, the output bytes of
+// the job are credited back to the quota of its client
+func (s *runnerServer) removeFiles(j *serverJob) error {
+	if err := j.RemoveFiles(); err != nil {
+		return err
+	}
+	s.jobs.Remove(j)
+	s.quota.Release(j.cn, j.ID())
+	return nil
+}
+
+// enforceQuota returns an output hook that accounts the output of a job started by cn against the
+// disk quota of cn. The job is stopped once the quota is exceeded.
+func (s *runnerServer) enforceQuota(cn string) func(id string, n int) {
+	return func(id string, n int) {
+		if !s.quota.Add(cn, id, int64(n)) {
+			return
+		}
+
+		if j, ok := s.jobs.Get(idKey(cn, id)); ok {
+			log.Printf("Disk quota exceeded for %s, stopping %s", cn, j)
+			j.StopAsync()
+		}
+	}
+}
+
 func (s *runnerServer) Stop(ctx context.Context, req *proto.StopRequest) (*proto.StopResponse, error) {
 	cn, err := getClientCN(ctx)
 	if err != nil {
@@ -74,7 +478,7 @@ func (s *runnerServer) Stop(ctx context.Context, req *proto.StopRequest) (*proto
 	}
 
 	log.Printf("Stop request for job id %s", req.JobId)
-	j, ok := s.jobs.Get(req.JobId + cn)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
 	if !ok {
 		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
 	}
@@ -89,6 +493,205 @@ func (s *runnerServer) Stop(ctx context.Context, req *proto.StopRequest) (*proto
 	}, nil
 }
 
+func (s *runnerServer) Wait(ctx context.Context, req *proto.WaitRequest) (*proto.WaitResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Wait request for job id %s", req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	select {
+	case <-j.Done():
+	case <-ctx.Done():
+		// client canceled the request or its deadline expired
+		log.Printf("%s stopped waiting for %s", cn, req.JobId)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	st, ec := j.Status()
+	log.Printf("%s finished. Status: %s (%d)", j, st, ec)
+	return &proto.WaitResponse{
+		Status:   proto.JobStatus(st),
+		ExitCode: int32(ec),
+	}, nil
+}
+
+func (s *runnerServer) Pause(ctx context.Context, req *proto.PauseRequest) (*proto.PauseResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Pause request for job id %s", req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	if err := j.Pause(); err != nil {
+		if errors.Is(err, lib.ErrNotRunning) {
+			return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	st, _ := j.Status()
+	log.Printf("%s paused successfully", j)
+
+	return &proto.PauseResponse{
+		Status: proto.JobStatus(st),
+	}, nil
+}
+
+func (s *runnerServer) Resume(ctx context.Context, req *proto.ResumeRequest) (*proto.ResumeResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Resume request for job id %s", req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	if err := j.Resume(); err != nil {
+		if errors.Is(err, lib.ErrNotPaused) {
+			return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	st, _ := j.Status()
+	log.Printf("%s resumed successfully", j)
+
+	return &proto.ResumeResponse{
+		Status: proto.JobStatus(st),
+	}, nil
+}
+
+func (s *runnerServer) Signal(ctx context.Context, req *proto.SignalRequest) (*proto.SignalResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	sig := syscall.Signal(req.Signal)
+	log.Printf("Signal request for job id %s with %s", req.JobId, sig)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	if err := j.Signal(sig); err != nil {
+		if errors.Is(err, lib.ErrInvalidSignal) {
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		}
+		if errors.Is(err, lib.ErrNotRunning) {
+			return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	st, _ := j.Status()
+	log.Printf("%s sent to %s successfully", sig, j)
+
+	return &proto.SignalResponse{
+		Status: proto.JobStatus(st),
+	}, nil
+}
+
+// RemoveRootFS removes the root filesystem of a finished job that was kept for debugging
+func (s *runnerServer) RemoveRootFS(ctx context.Context, req *proto.RemoveRootFSRequest) (*proto.RemoveRootFSResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("RemoveRootFS request for job id %s", req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	if err := j.RemoveRootFS(); err != nil {
+		if errors.Is(err, lib.ErrNotFinished) {
+			return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	log.Printf("Removed root filesystem of %s", j)
+	return &proto.RemoveRootFSResponse{}, nil
+}
+
+// Delete removes all the files of a finished job, including its output, and forgets about the job
+func (s *runnerServer) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.DeleteResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Delete request for job id %s", req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	if err := s.removeFiles(j); err != nil {
+		if errors.Is(err, lib.ErrNotFinished) {
+			return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	log.Printf("Deleted %s", j)
+	return &proto.DeleteResponse{}, nil
+}
+
+func (s *runnerServer) ListVolumes(ctx context.Context, req *proto.ListVolumesRequest) (*proto.ListVolumesResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	volumes, err := listVolumes(cn)
+	if err != nil {
+		log.Printf("Failed to list the volumes of %s: %v", cn, err)
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	return &proto.ListVolumesResponse{Volumes: volumes}, nil
+}
+
+func (s *runnerServer) DeleteVolume(ctx context.Context, req *proto.DeleteVolumeRequest) (*proto.DeleteVolumeResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Delete request for volume %s of %s", req.Name, cn)
+	dir, err := volumeDir(cn, req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
+	s.volumes.Lock()
+	defer s.volumes.Unlock()
+
+	err = deleteVolume(dir, s.jobs.List(cn, proto.ListOrder_OLDEST_FIRST))
+	switch {
+	case errors.Is(err, errVolumeInUse):
+		return nil, status.Errorf(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, status.Errorf(codes.NotFound, "Cannot find volume %s for %s", req.Name, cn)
+	case err != nil:
+		log.Printf("Failed to delete volume %s of %s: %v", req.Name, cn, err)
+		return nil, status.Errorf(codes.Internal, err.Error())
+	}
+	log.Printf("Deleted volume %s of %s", req.Name, cn)
+	return &proto.DeleteVolumeResponse{}, nil
+}
+
 func (s *runnerServer) Status(ctx context.Context, req *proto.StatusRequest) (*proto.StatusResponse, error) {
 	cn, err := getClientCN(ctx)
 	if err != nil {
@@ -96,18 +699,128 @@ func (s *runnerServer) Status(ctx context.Context, req *proto.StatusRequest) (*p
 	}
 
 	log.Printf("Status request for job id %s", req.JobId)
-	j, ok := s.jobs.Get(req.JobId + cn)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
 	if !ok {
 		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
 	}
 
 	status, ec := j.Status()
+	if status == lib.StatusRunning && !j.Alive() {
+		// The process died out of band, give the job a chance to reach a terminal state
+		select {
+		case <-j.Done():
+		case <-time.After(reconcileTimeout):
+		}
+		status, ec = j.Status()
+	}
 	log.Printf("Status for %s: %s (%d)", req.JobId, status, ec)
 
-	return &proto.StatusResponse{
-		Status:   proto.JobStatus(status),
-		ExitCode: int32(ec),
-	}, nil
+	resp := &proto.StatusResponse{
+		Status:         proto.JobStatus(status),
+		ExitCode:       int32(ec),
+		OutputComplete: j.OutputComplete(),
+	}
+	if usage, ok := j.Usage(); ok {
+		resp.Usage = &proto.Usage{
+			UserTimeMs:   usage.UserTime.Milliseconds(),
+			SystemTimeMs: usage.SystemTime.Milliseconds(),
+			MaxRssBytes:  usage.MaxRSS,
+		}
+	}
+	resp.Command = j.config.Command
+	resp.Args = j.config.Args
+	resp.Profile = string(j.config.Profile)
+	resp.Timeout = int32(j.config.Timeout / time.Second)
+	resp.TimeoutMs = j.config.Timeout.Milliseconds()
+	for _, code := range j.Attempts() {
+		resp.Attempts = append(resp.Attempts, int32(code))
+	}
+	if status == lib.StatusQueued {
+		if position, start, ok := s.queue.Position(j.ID()); ok {
+			resp.QueuePosition = int32(position)
+			if !start.IsZero() {
+				resp.EstimatedStart = start.UnixNano() / int64(time.Millisecond)
+			}
+		}
+	}
+	if s.config.exposePIDs {
+		// only the owner of the job gets this far
+		resp.Pid = int32(j.PID())
+	}
+	if usage, ok := j.LiveUsage(); ok {
+		resp.LiveUsage = &proto.LiveUsage{
+			CpuTimeMs:          usage.CPUTime.Milliseconds(),
+			MemoryCurrentBytes: usage.MemoryCurrent,
+		}
+	}
+	return resp, nil
+}
+
+// List returns the jobs started by the client in the requested order
+func (s *runnerServer) List(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("List request from %s", cn)
+	resp := &proto.ListResponse{}
+	for _, j := range s.jobs.List(cn, req.Order) {
+		st, ec := j.Status()
+		resp.Jobs = append(resp.Jobs, &proto.JobInfo{
+			JobId:     j.ID(),
+			Status:    proto.JobStatus(st),
+			ExitCode:  int32(ec),
+			CreatedAt: j.startedAt.UnixNano() / int64(time.Millisecond),
+			Command:   j.config.Command,
+			Args:      j.config.Args,
+		})
+	}
+	return resp, nil
+}
+
+// ServerStatus returns the number of jobs running and queued on the server
+func (s *runnerServer) ServerStatus(ctx context.Context, req *proto.ServerStatusRequest) (*proto.ServerStatusResponse, error) {
+	if _, err := getClientCN(ctx); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	resp := &proto.ServerStatusResponse{
+		MaxJobs:          int32(s.config.maxJobs),
+		MaxQueued:        int32(s.config.maxQueued),
+		RunningJobs:      int32(s.queue.Running()),
+		QueuedByPriority: make(map[int32]int32),
+	}
+	for priority, n := range s.queue.QueuedByPriority() {
+		resp.QueuedJobs += int32(n)
+		resp.QueuedByPriority[int32(priority)] = int32(n)
+	}
+	return resp, nil
+}
+
+// ListProfiles returns the resource profiles jobs can be started with
+func (s *runnerServer) ListProfiles(ctx context.Context, req *proto.ListProfilesRequest) (*proto.ListProfilesResponse, error) {
+	if _, err := getClientCN(ctx); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	registered := lib.Profiles()
+	resp := &proto.ListProfilesResponse{}
+	for _, profile := range lib.ValidProfiles() {
+		limits, ok := registered[profile]
+		if !ok {
+			// registered after the limits were fetched
+			continue
+		}
+		resp.Profiles = append(resp.Profiles, &proto.Profile{
+			Name:           string(profile),
+			Cpus:           limits.CPUs,
+			MemoryMaxBytes: limits.MemoryMax,
+			PidsMax:        limits.PidsMax,
+			Cpuset:         limits.CPUSet,
+		})
+	}
+	return resp, nil
 }
 
 func (s *runnerServer) Output(req *proto.OutputRequest, strSrv proto.Runner_OutputServer) error {
@@ -118,16 +831,45 @@ func (s *runnerServer) Output(req *proto.OutputRequest, strSrv proto.Runner_Outp
 	}
 
 	log.Printf("Output request from %s for job id %s", cn, req.JobId)
-	j, ok := s.jobs.Get(req.JobId + cn)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
 	if !ok {
 		return status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
 	}
-	out, cancel, err := j.Output()
+	if !s.streams.Acquire(cn) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for %s", cn)
+	}
+	defer s.streams.Release(cn)
+
+	var out <-chan *lib.Output
+	var cancel func()
+	switch {
+	case req.LastBytes < 0:
+		return status.Errorf(codes.InvalidArgument, "Negative number of last bytes %d", req.LastBytes)
+	case req.LastBytes > 0:
+		out, cancel, err = j.OutputLast(req.LastBytes)
+	default:
+		out, cancel, err = j.Output()
+	}
+	if errors.Is(err, lib.ErrTooManyStreams) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for job %s", req.JobId)
+	}
+	if errors.Is(err, lib.ErrFilesRemoved) {
+		return status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+	}
 	if err != nil {
 		return err
 	}
 	defer cancel()
 
+	// Heartbeats let the client tell a silent job apart from a broken stream
+	var heartbeat <-chan time.Time
+	if s.config.outputHeartbeat > 0 {
+		ticker := time.NewTicker(s.config.outputHeartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	lastSent := time.Now()
+
 	for {
 		select {
 		case buf, ok := <-out:
@@ -135,6 +877,14 @@ func (s *runnerServer) Output(req *proto.OutputRequest, strSrv proto.Runner_Outp
 				// out channel closed
 				return nil
 			}
+			if errors.Is(buf.Err, lib.ErrFilesRemoved) {
+				log.Printf("%s was deleted while its output was streamed to %s", j, cn)
+				return status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+			}
+			if err := s.rate.Wait(ctx, cn, len(buf.Bytes)); err != nil {
+				log.Printf("%s disconnected output for %s", cn, req.JobId)
+				return nil
+			}
 			err := strSrv.Send(&proto.OutputResponse{
 				Buffer: buf.Bytes,
 			})
@@ -142,6 +892,16 @@ func (s *runnerServer) Output(req *proto.OutputRequest, strSrv proto.Runner_Outp
 				log.Printf("Error sending output to client: %v", err)
 				return err
 			}
+			lastSent = time.Now()
+		case <-heartbeat:
+			if time.Since(lastSent) < s.config.outputHeartbeat {
+				continue
+			}
+			if err := strSrv.Send(&proto.OutputResponse{Heartbeat: true}); err != nil {
+				log.Printf("Error sending heartbeat to client: %v", err)
+				return err
+			}
+			lastSent = time.Now()
 		case <-ctx.Done():
 			// client disconnected
 			log.Printf("%s disconnected output for %s", cn, req.JobId)
@@ -149,3 +909,325 @@ func (s *runnerServer) Output(req *proto.OutputRequest, strSrv proto.Runner_Outp
 		}
 	}
 }
+
+// GetOutput returns the whole output of a finished job in one response. ResourceExhausted is
+// returned if the output is larger than maxOutputSize, the output must be streamed with Output then.
+func (s *runnerServer) GetOutput(ctx context.Context, req *proto.GetOutputRequest) (*proto.GetOutputResponse, error) {
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("GetOutput request from %s for job id %s", cn, req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	select {
+	case <-j.Done():
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "Job %s hasn't finished", req.JobId)
+	}
+	if !s.streams.Acquire(cn) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Too many output streams for %s", cn)
+	}
+	defer s.streams.Release(cn)
+
+	// The output of a finished job is read from its output file till the end
+	out, cancel, err := j.Output()
+	switch {
+	case errors.Is(err, lib.ErrOutputDiscarded):
+		return nil, status.Errorf(codes.FailedPrecondition, "Output of job %s is discarded", req.JobId)
+	case errors.Is(err, lib.ErrTooManyStreams):
+		return nil, status.Errorf(codes.ResourceExhausted, "Too many output streams for job %s", req.JobId)
+	case errors.Is(err, lib.ErrFilesRemoved):
+		return nil, status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+	case err != nil:
+		return nil, err
+	}
+	defer cancel()
+
+	var output []byte
+	for {
+		select {
+		case buf, ok := <-out:
+			if !ok {
+				return &proto.GetOutputResponse{
+					Output: output,
+				}, nil
+			}
+			if errors.Is(buf.Err, lib.ErrFilesRemoved) {
+				return nil, status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+			}
+			if int64(len(output)+len(buf.Bytes)) > s.config.maxOutputSize {
+				return nil, status.Errorf(codes.ResourceExhausted,
+					"Output of job %s is larger than %d bytes, use Output to stream it", req.JobId, s.config.maxOutputSize)
+			}
+			output = append(output, buf.Bytes...)
+		case <-ctx.Done():
+			// client canceled the request or its deadline expired
+			log.Printf("%s stopped reading output of %s", cn, req.JobId)
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// SearchOutput streams the lines of the output of a job that match a pattern, with the lines of
+// context around them, so that large outputs don't have to be downloaded to be searched. The output
+// of a running job is searched as it's produced till the job finishes.
+func (s *runnerServer) SearchOutput(req *proto.SearchOutputRequest, strSrv proto.Runner_SearchOutputServer) error {
+	ctx := strSrv.Context()
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("SearchOutput request from %s for job id %s", cn, req.JobId)
+	if req.Pattern == "" {
+		return status.Errorf(codes.InvalidArgument, "Empty pattern")
+	}
+	if req.Context < 0 || req.Context > maxSearchContext {
+		return status.Errorf(codes.InvalidArgument, "Context %d is out of range [0, %d]", req.Context, maxSearchContext)
+	}
+	match, err := newLineMatcher(req.Pattern, req.Regexp)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid pattern: %v", err)
+	}
+
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+	if !s.streams.Acquire(cn) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for %s", cn)
+	}
+	defer s.streams.Release(cn)
+
+	out, cancel, err := j.Output()
+	switch {
+	case errors.Is(err, lib.ErrOutputDiscarded):
+		return status.Errorf(codes.FailedPrecondition, "Output of job %s is discarded", req.JobId)
+	case errors.Is(err, lib.ErrTooManyStreams):
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for job %s", req.JobId)
+	case errors.Is(err, lib.ErrFilesRemoved):
+		return status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+	case err != nil:
+		return err
+	}
+	defer cancel()
+
+	g := &grepper{
+		match:   match,
+		context: int(req.Context),
+		emit: func(l searchLine) error {
+			if err := s.rate.Wait(ctx, cn, len(l.line)); err != nil {
+				return err
+			}
+			return strSrv.Send(&proto.SearchOutputResponse{
+				LineNumber: l.number,
+				Line:       l.line,
+				Match:      l.match,
+			})
+		},
+	}
+	for {
+		select {
+		case buf, ok := <-out:
+			if !ok {
+				return g.Flush()
+			}
+			if errors.Is(buf.Err, lib.ErrFilesRemoved) {
+				log.Printf("%s was deleted while its output was searched by %s", j, cn)
+				return status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+			}
+			if err := g.Write(buf.Bytes); err != nil {
+				log.Printf("Error sending matching lines to client: %v", err)
+				return err
+			}
+		case <-ctx.Done():
+			// client disconnected
+			log.Printf("%s disconnected search of %s", cn, req.JobId)
+			return nil
+		}
+	}
+}
+
+// Events streams the output and the status changes of a job interleaved in the order they happen.
+// The current status is sent first and the terminal status is sent last, once all the output has
+// been sent.
+func (s *runnerServer) Events(req *proto.EventsRequest, strSrv proto.Runner_EventsServer) error {
+	ctx := strSrv.Context()
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Events request from %s for job id %s", cn, req.JobId)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+
+	// Subscribe before fetching the current status so that no status change is missed
+	updates, unsubscribe := j.StatusUpdates()
+	defer unsubscribe()
+
+	if !s.streams.Acquire(cn) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for %s", cn)
+	}
+	defer s.streams.Release(cn)
+
+	out, cancel, err := j.Output()
+	if errors.Is(err, lib.ErrTooManyStreams) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for job %s", req.JobId)
+	}
+	if errors.Is(err, lib.ErrFilesRemoved) {
+		return status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+	}
+	if err != nil && !errors.Is(err, lib.ErrOutputDiscarded) {
+		return err
+	}
+	if cancel != nil {
+		defer cancel()
+	}
+
+	sendStatus := func(st lib.JobStatus, ec int) error {
+		return strSrv.Send(&proto.EventsResponse{
+			Event: &proto.EventsResponse_Status{
+				Status: &proto.StatusEvent{
+					Status:   proto.JobStatus(st),
+					ExitCode: int32(ec),
+				},
+			},
+		})
+	}
+
+	if st, ec := j.Status(); !st.Terminal() {
+		if err := sendStatus(st, ec); err != nil {
+			return err
+		}
+	}
+
+	// out is nil if the output of the job is discarded
+	done := j.Done()
+	for out != nil || done != nil {
+		select {
+		case buf, ok := <-out:
+			if !ok {
+				out = nil
+				continue
+			}
+			if errors.Is(buf.Err, lib.ErrFilesRemoved) {
+				return status.Errorf(codes.NotFound, "Job %s was deleted", req.JobId)
+			}
+			if err := s.rate.Wait(ctx, cn, len(buf.Bytes)); err != nil {
+				return nil
+			}
+			err := strSrv.Send(&proto.EventsResponse{
+				Event: &proto.EventsResponse_Output{
+					Output: &proto.OutputResponse{
+						Buffer: buf.Bytes,
+					},
+				},
+			})
+			if err != nil {
+				log.Printf("Error sending events to client: %v", err)
+				return err
+			}
+		case st, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
+			}
+			// the terminal status is held back till all the output is sent
+			if st.Terminal() {
+				continue
+			}
+			if err := sendStatus(st, -1); err != nil {
+				log.Printf("Error sending events to client: %v", err)
+				return err
+			}
+		case <-done:
+			done = nil
+		case <-ctx.Done():
+			// client disconnected
+			log.Printf("%s disconnected events for %s", cn, req.JobId)
+			return nil
+		}
+	}
+
+	return sendStatus(j.Status())
+}
+
+// Exec runs a process in the namespaces of a running job and streams its output, followed by its
+// exit code. The process is killed when the client disconnects.
+func (s *runnerServer) Exec(req *proto.ExecRequest, strSrv proto.Runner_ExecServer) error {
+	ctx := strSrv.Context()
+	cn, err := getClientCN(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, err.Error())
+	}
+
+	log.Printf("Exec request from %s for job id %s: %q", cn, req.JobId, req.Args)
+	j, ok := s.jobs.Lookup(cn, req.JobId)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "Cannot find job %s for %s", req.JobId, cn)
+	}
+	// the process runs inside the job, so it's held to the same restrictions as the command of a job
+	err = s.checkCommand(ctx, admissionRequest{
+		CN:      cn,
+		Args:    req.Args,
+		Profile: string(j.config.Profile),
+		JobID:   j.ID(),
+	})
+	if err != nil {
+		return err
+	}
+	if !s.streams.Acquire(cn) {
+		return status.Errorf(codes.ResourceExhausted, "Too many output streams for %s", cn)
+	}
+	defer s.streams.Release(cn)
+
+	// stdout and stderr share the writer, so it's never written to concurrently
+	out := &execWriter{strSrv: strSrv}
+	exitCode, err := j.Exec(ctx, req.Args, out, out)
+	switch {
+	case errors.Is(err, lib.ErrNoCommand):
+		return status.Errorf(codes.InvalidArgument, err.Error())
+	case errors.Is(err, lib.ErrNotRunning):
+		return status.Errorf(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, lib.ErrExecNotSupported):
+		return status.Errorf(codes.Unimplemented, err.Error())
+	case ctx.Err() != nil:
+		// client disconnected
+		log.Printf("%s disconnected exec in %s", cn, req.JobId)
+		return nil
+	case err != nil:
+		return status.Errorf(codes.Internal, err.Error())
+	}
+
+	return strSrv.Send(&proto.ExecResponse{
+		Event: &proto.ExecResponse_ExitCode{
+			ExitCode: int32(exitCode),
+		},
+	})
+}
+
+// execWriter sends the output of a process run by Exec to the client
+type execWriter struct {
+	strSrv proto.Runner_ExecServer
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	// Send doesn't retain the buffer, it's serialized before Send returns
+	err := w.strSrv.Send(&proto.ExecResponse{
+		Event: &proto.ExecResponse_Output{
+			Output: p,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "sync"
+
+// streamLimit tracks the number of concurrent output streams of each client
+type streamLimit struct {
+	limit  int            // maximum number of concurrent output streams per client, 0 means unlimited
+	active map[string]int // number of concurrent output streams per client
+	sync.Mutex
+}
+
+// Acquire accounts a new output stream to cn. It returns false if cn already has the maximum
+// number of output streams, in which case the stream isn't accounted.
+func (l *streamLimit) Acquire(cn string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.limit > 0 && l.active[cn] >= l.limit {
+		return false
+	}
+	l.active[cn]++
+	return true
+}
+
+// Release removes an output stream acquired by cn
+func (l *streamLimit) Release(cn string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.active[cn]--
+	if l.active[cn] <= 0 {
+		delete(l.active, cn)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputThrottle limits the rate at which the output of jobs is streamed to each client, all the
+// output streams of a client share its rate. A stream that exceeds the rate is slowed down.
+type outputThrottle struct {
+	rate    int64            // bytes per second per client, 0 means unlimited
+	rates   map[string]int64 // bytes per second of specific clients, overriding rate
+	buckets map[string]*tokenBucket
+	sync.Mutex
+}
+
+// Wait blocks till n more bytes can be streamed to cn or ctx is done
+func (t *outputThrottle) Wait(ctx context.Context, cn string, n int) error {
+	b := t.bucket(cn)
+	if b == nil {
+		return nil
+	}
+
+	delay := b.reserve(n, time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bucket returns the token bucket of cn, nil if the rate of cn is unlimited
+func (t *outputThrottle) bucket(cn string) *tokenBucket {
+	t.Lock()
+	defer t.Unlock()
+
+	rate, ok := t.rates[cn]
+	if !ok {
+		rate = t.rate
+	}
+	if rate <= 0 {
+		return nil
+	}
+
+	b, ok := t.buckets[cn]
+	if !ok {
+		b = &tokenBucket{rate: rate, tokens: float64(rate), last: time.Now()}
+		t.buckets[cn] = b
+	}
+	return b
+}
+
+// tokenBucket hands out up to rate tokens per second, a second's worth of tokens can be saved up
+// for bursts
+type tokenBucket struct {
+	rate   int64     // tokens added per second
+	tokens float64   // tokens available, negative if tokens are owed
+	last   time.Time // when tokens were last added
+	sync.Mutex
+}
+
+// reserve takes n tokens at now and returns how long to wait till they're available. Tokens can
+// be owed, so that more tokens than are saved up can be taken at once.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(b.rate), b.tokens+now.Sub(b.last).Seconds()*float64(b.rate))
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+// parseRates parses comma separated cn=bytes-per-second rates of clients. The clients are keyed by
+// their escaped identity, the way certIdentity returns it, so that identities with characters like
+// / or spaces match.
+func parseRates(s string) (map[string]int64, error) {
+	rates := make(map[string]int64)
+	if s == "" {
+		return rates, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		// the rate follows the last =, an identity may contain = itself
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid rate %q, expected cn=bytes-per-second", entry)
+		}
+		rate, err := strconv.ParseInt(entry[i+1:], 10, 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate %q, expected a non-negative number of bytes per second", entry)
+		}
+		rates[url.PathEscape(entry[:i])] = rate
+	}
+	return rates, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 1000, tokens: 1000, last: now}
+
+	// a second's worth of tokens is available right away
+	assert.Equal(t, time.Duration(0), b.reserve(1000, now))
+
+	// the next tokens are owed
+	assert.Equal(t, 500*time.Millisecond, b.reserve(500, now))
+	assert.Equal(t, time.Second, b.reserve(500, now))
+
+	// tokens owed are paid back over time
+	assert.Equal(t, 500*time.Millisecond, b.reserve(0, now.Add(500*time.Millisecond)))
+
+	// no more than a second's worth of tokens is saved up
+	assert.Equal(t, time.Duration(0), b.reserve(1000, now.Add(time.Hour)))
+	assert.Equal(t, 100*time.Millisecond, b.reserve(100, now.Add(time.Hour)))
+}
+
+func TestOutputThrottle(t *testing.T) {
+	throttle := outputThrottle{
+		rate:    0,
+		rates:   map[string]int64{"client1": 1000},
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	// the rate of clients without a rate of their own is unlimited
+	assert.Nil(t, throttle.bucket("client2"))
+	require.Nil(t, throttle.Wait(context.Background(), "client2", 1<<30))
+
+	start := time.Now()
+	require.Nil(t, throttle.Wait(context.Background(), "client1", 1000))
+	require.Nil(t, throttle.Wait(context.Background(), "client1", 200))
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// waiting ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, throttle.Wait(ctx, "client1", 1000))
+}
+
+func TestParseRates(t *testing.T) {
+	testCases := []struct {
+		name   string           // test case name
+		rates  string           // comma separated rates
+		parsed map[string]int64 // parsed rates
+		nilErr bool             // nil error from parseRates?
+	}{
+		{name: "empty", rates: "", parsed: map[string]int64{}, nilErr: true},
+		{name: "single", rates: "client1=1024", parsed: map[string]int64{"client1": 1024}, nilErr: true},
+		{name: "multiple", rates: "client1=1024,client2=0", parsed: map[string]int64{"client1": 1024, "client2": 0}, nilErr: true},
+		{name: "spiffe id", rates: "spiffe://example.org/x=1000", parsed: map[string]int64{"spiffe:%2F%2Fexample.org%2Fx": 1000}, nilErr: true},
+		{name: "cn with a space", rates: "client 1=1024", parsed: map[string]int64{"client%201": 1024}, nilErr: true},
+		{name: "missing rate", rates: "client1", nilErr: false},
+		{name: "missing cn", rates: "=1024", nilErr: false},
+		{name: "negative", rates: "client1=-1", nilErr: false},
+		{name: "not a number", rates: "client1=1k", nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			parsed, err := parseRates(tc.rates)
+			assert.Equal(t, tc.nilErr, err == nil, "%v", err)
+			if tc.nilErr {
+				assert.Equal(t, tc.parsed, parsed)
+			}
+		})
+	}
+}
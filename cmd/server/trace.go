@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ronakg/runner/pkg/lib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the server
+const tracerName = "github.com/ronakg/runner/cmd/server"
+
+// setupTracing exports the spans of the server to the OTLP collector at endpoint over gRPC. Spans
+// aren't recorded unless setupTracing is called. The spans are exported in batches, the spans of a
+// server that exits may not be exported.
+func setupTracing(ctx context.Context, endpoint string, insecure bool) error {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("runner-server"))),
+	)
+	otel.SetTracerProvider(tp)
+	// the trace context of the clients is picked up by the RPC spans
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// jobSpan is a span covering the lifecycle of a job, from its start request till it reaches a
+// terminal state. The status changes of the job are recorded as events of the span.
+type jobSpan struct {
+	span trace.Span
+}
+
+// startJobSpan starts the span of a job started by cn. It's a child of the span of the start
+// request in ctx, if any.
+func startJobSpan(ctx context.Context, cn string) *jobSpan {
+	_, span := otel.Tracer(tracerName).Start(ctx, "job", trace.WithAttributes(attribute.String("runner.client", cn)))
+	return &jobSpan{span: span}
+}
+
+// statusChanged is a status change hook of the job, the span ends once the job reaches a terminal
+// state
+func (s *jobSpan) statusChanged(id string, status lib.JobStatus) {
+	s.span.SetAttributes(attribute.String("runner.job_id", id))
+	s.span.AddEvent(status.String())
+	if !status.Terminal() {
+		return
+	}
+	if status == lib.StatusFailed {
+		s.span.SetStatus(codes.Error, "job failed")
+	}
+	s.span.End()
+}
+
+// fail ends the span of a job that couldn't be started
+func (s *jobSpan) fail(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+	s.span.End()
+}
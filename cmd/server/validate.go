@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// suspiciousConstructs are shell constructs that are logged when warnSuspicious is enabled.
+// Commands run through "sh -c", so these aren't rejected, they're only surfaced for auditing.
+var suspiciousConstructs = []string{
+	"$(",       // command substitution
+	"`",        // command substitution
+	"eval ",    // evaluation of arbitrary strings
+	"/dev/tcp", // network redirection
+	"/dev/udp", // network redirection
+	"base64 -d",
+}
+
+// reservedEnvPrefixes are the prefixes of environment variables a client can't set. LD_ variables
+// change how the dynamic linker loads programs and _RUNNER_ variables are used internally by the
+// processes that set up a job.
+var reservedEnvPrefixes = []string{"LD_", "_RUNNER_"}
+
+// validateEnv makes sure that none of the KEY=VALUE environment variables has a reserved key
+func validateEnv(env []string) error {
+	for _, kv := range env {
+		key := strings.SplitN(kv, "=", 2)[0]
+		for _, prefix := range reservedEnvPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return fmt.Errorf("Environment variable %s is reserved", key)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultEnvDenyList are the environment variables dropped from the environment of a job by
+// default. The shell running the command of a job reads commands from the files in BASH_ENV and
+// ENV, and runs PS4 when tracing.
+var defaultEnvDenyList = []string{"BASH_ENV", "ENV", "PS4", "SHELLOPTS"}
+
+// sanitizeEnv returns the KEY=VALUE environment variables of env whose key isn't in denyList and
+// the keys that are dropped. An entry of denyList ending in * matches all the keys with the prefix
+// before the *.
+func sanitizeEnv(env, denyList []string) (kept, dropped []string) {
+	for _, kv := range env {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if envDenied(key, denyList) {
+			dropped = append(dropped, key)
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	return kept, dropped
+}
+
+// envKeys returns the keys of the KEY=VALUE environment variables of env, so that the environment
+// of a job can be logged without the values, which may be secrets
+func envKeys(env []string) []string {
+	keys := make([]string, 0, len(env))
+	for _, kv := range env {
+		keys = append(keys, strings.SplitN(kv, "=", 2)[0])
+	}
+	return keys
+}
+
+// envDenied returns true if key matches an entry of denyList
+func envDenied(key string, denyList []string) bool {
+	for _, denied := range denyList {
+		if prefix := strings.TrimSuffix(denied, "*"); prefix != denied {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == denied {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCommand makes sure that exactly one of the command and args is set and that it isn't
+// longer than maxLen bytes. A maxLen of 0 means unlimited.
+func validateCommand(command string, args []string, maxLen int) error {
+	if command == "" && len(args) == 0 {
+		return fmt.Errorf("Command is empty")
+	}
+	if command != "" && len(args) > 0 {
+		return fmt.Errorf("Only one of command and args can be set")
+	}
+
+	length := len(command)
+	for _, arg := range args {
+		length += len(arg)
+	}
+	if maxLen > 0 && length > maxLen {
+		return fmt.Errorf("Command is %d bytes long, maximum allowed is %d bytes", length, maxLen)
+	}
+	return nil
+}
+
+// startTime returns the time to launch a job at given the delay in milliseconds after now or the
+// time in milliseconds since the epoch. The zero time, which launches the job right away, is
+// returned if neither is set or the time has passed already.
+func startTime(afterMs, atMs int64, now time.Time) (time.Time, error) {
+	if afterMs < 0 || atMs < 0 {
+		return time.Time{}, fmt.Errorf("Start delay %dms or start time %d is negative", afterMs, atMs)
+	}
+	if afterMs > 0 && atMs > 0 {
+		return time.Time{}, errors.New("Start delay and start time can't both be set")
+	}
+
+	var at time.Time
+	switch {
+	case afterMs > 0:
+		at = now.Add(time.Duration(afterMs) * time.Millisecond)
+	case atMs > 0:
+		at = time.Unix(0, atMs*int64(time.Millisecond))
+	}
+	if !at.After(now) {
+		return time.Time{}, nil
+	}
+	return at, nil
+}
+
+// findSuspicious returns the suspicious constructs found in the command
+func findSuspicious(command string) []string {
+	found := make([]string, 0)
+	for _, c := range suspiciousConstructs {
+		if strings.Contains(command, c) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
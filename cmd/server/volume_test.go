@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ronakg/runner/pkg/lib"
+	"github.com/ronakg/runner/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// doneJob is a lib.Job that's only good for telling whether it's finished
+type doneJob struct {
+	lib.Job
+	done chan struct{}
+}
+
+func (j *doneJob) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *doneJob) String() string {
+	return "Job done"
+}
+
+func TestVolumes(t *testing.T) {
+	// not parallel since RunnerHome applies to all the volumes
+	defer func(home string) { lib.RunnerHome = home }(lib.RunnerHome)
+	lib.RunnerHome = t.TempDir()
+
+	mounts, err := volumeMounts("client1", []*proto.Volume{{Name: "cache", Target: "/cache"}})
+	require.Nil(t, err)
+	require.Len(t, mounts, 1)
+	require.Nil(t, os.WriteFile(filepath.Join(mounts[0].Source, "data"), make([]byte, 100), 0600))
+	_, err = volumeMounts("client2", []*proto.Volume{{Name: "cache", Target: "/cache"}})
+	require.Nil(t, err)
+
+	// the volumes are counted per client and for all the clients
+	usage, err := volumeUsage("client1")
+	require.Nil(t, err)
+	assert.Equal(t, int64(100), usage)
+	usage, err = volumeUsage("client2")
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), usage)
+	usage, err = volumeUsage("")
+	require.Nil(t, err)
+	assert.Equal(t, int64(100), usage)
+	usage, err = volumeUsage("client3")
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), usage)
+
+	volumes, err := listVolumes("client1")
+	require.Nil(t, err)
+	require.Len(t, volumes, 1)
+	assert.Equal(t, "cache", volumes[0].Name)
+	assert.Equal(t, int64(100), volumes[0].SizeBytes)
+	volumes, err = listVolumes("client3")
+	require.Nil(t, err)
+	assert.Empty(t, volumes)
+
+	// a volume isn't deleted while an unfinished job mounts it
+	dir, err := volumeDir("client1", "cache")
+	require.Nil(t, err)
+	j := &doneJob{done: make(chan struct{})}
+	jobs := []*serverJob{{Job: j, cn: "client1", config: lib.JobConfig{Mounts: mounts}}}
+	err = deleteVolume(dir, jobs)
+	assert.True(t, errors.Is(err, errVolumeInUse))
+	assert.DirExists(t, dir)
+
+	close(j.done)
+	require.Nil(t, deleteVolume(dir, jobs))
+	assert.NoDirExists(t, dir)
+	usage, err = volumeUsage("client1")
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), usage)
+
+	// the volume of the other client is left alone
+	other, err := volumeDir("client2", "cache")
+	require.Nil(t, err)
+	assert.DirExists(t, other)
+
+	err = deleteVolume(dir, nil)
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = volumeDir("client1", "../client2")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile calls load whenever the file at path changes. What was loaded before stays in effect
+// if load fails. what describes the file in the logs.
+func watchFile(path string, what string, load func() error) error {
+	// Editors usually replace the file instead of writing to it, so watch the directory instead
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) ||
+					event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if err := load(); err != nil {
+					log.Printf("Failed to reload %s, keeping the previous %s: %v", what, what, err)
+					continue
+				}
+				log.Printf("Reloaded %s from %s", what, path)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching %s file %s: %v", what, path, err)
+			}
+		}
+	}()
+	return nil
+}
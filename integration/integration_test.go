@@ -1,11 +1,20 @@
 package integration
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -184,8 +193,1263 @@ func TestConcurrentOutput(t *testing.T) {
 	wg.Wait()
 }
 
-func startClient(client, command string, timeout int) (id string, err error) {
-	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "start", "--timeout", strconv.Itoa(timeout), command}
+func TestJobName(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	name := "named-job"
+	_, err := startClient(client, "echo 123", 0, "--name", name)
+	require.Nil(t, err)
+
+	// name is unique per client
+	_, err = startClient(client, "echo 123", 0, "--name", name)
+	require.NotNil(t, err)
+	_, err = startClient("validclient2", "echo 123", 0, "--name", name)
+	require.Nil(t, err)
+
+	// name can be used in place of the job id
+	output, err := getOutput(client, name)
+	require.Nil(t, err)
+	assert.Equal(t, "123\n", output)
+
+	status, err := getStatus(client, name)
+	require.Nil(t, err)
+	assert.Equal(t, "COMPLETED (0)", status)
+}
+
+func TestLineOutput(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "printf 'abc'; sleep 1; printf 'xyz\\n123'", 0)
+	require.Nil(t, err)
+
+	// partial lines are buffered till the newline arrives or the output ends
+	output, err := getOutput(client, id, "--lines")
+	require.Nil(t, err)
+	assert.Equal(t, "abcxyz\n123", output)
+}
+
+func TestColorOutput(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "printf '\\033[31mred\\033[0m\\n'", 0)
+	require.Nil(t, err)
+
+	// ANSI escape sequences are stripped when the output isn't printed to a terminal
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "red\n", output)
+
+	output, err = getOutput(client, id, "--raw")
+	require.Nil(t, err)
+	assert.Equal(t, "\033[31mred\033[0m\n", output)
+}
+
+func TestOutputLastBytes(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo 123; echo 456; echo 789", 0)
+	require.Nil(t, err)
+	_, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "wait", "--id", id).CombinedOutput()
+	require.Nil(t, err)
+
+	output, err := getOutput(client, id, "--last-bytes", "8")
+	require.Nil(t, err)
+	assert.Equal(t, "456\n789\n", output)
+
+	// the whole output is printed if it's smaller
+	output, err = getOutput(client, id, "--last-bytes", "4096")
+	require.Nil(t, err)
+	assert.Equal(t, "123\n456\n789\n", output)
+}
+
+func TestBinaryOutput(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "printf '\\000\\001\\377\\033[31m\\r\\200'", 0)
+	require.Nil(t, err)
+
+	// binary output is printed byte for byte even though stdout isn't a terminal
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "\x00\x01\xff\x1b[31m\r\x80", output)
+
+	output, err = getOutput(client, id, "--raw")
+	require.Nil(t, err)
+	assert.Equal(t, "\x00\x01\xff\x1b[31m\r\x80", output)
+}
+
+func TestCompressedOutput(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "seq 1 1000", 0)
+	require.Nil(t, err)
+
+	expected, err := getOutput(client, id)
+	require.Nil(t, err)
+
+	// compression doesn't change the output
+	output, err := getOutput(client, id, "--compress")
+	require.Nil(t, err)
+	assert.Equal(t, expected, output)
+
+	output, err = getOutput(client, id, "--compress", "--finished")
+	require.Nil(t, err)
+	assert.Equal(t, expected, output)
+}
+
+func TestClosedStdout(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "yes | head -n 1000000", 0)
+	require.Nil(t, err)
+
+	// simulate "client output | head -n 1"
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "output", "--id", id}
+	cmd := exec.Command(clientBin, clientArgs...)
+	stdout, err := cmd.StdoutPipe()
+	require.Nil(t, err)
+	require.Nil(t, cmd.Start())
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(stdout, buf)
+	require.Nil(t, err)
+	require.Nil(t, stdout.Close())
+
+	// the client exits cleanly once stdout is closed
+	assert.Nil(t, cmd.Wait())
+}
+
+func TestExecArgs(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "start", "--exec", "--",
+		"echo", "file with  spaces", "$HOME"}
+	startOutput, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	id := string(startOutput[:len(startOutput)-1])
+
+	// arguments are passed to the command without shell interpretation
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "file with  spaces $HOME\n", output)
+}
+
+func TestEvents(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo abc; sleep 1; echo xyz", 0)
+	require.Nil(t, err)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "events", "--id", id}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+
+	// the terminal status comes after all the output
+	assert.Equal(t, "[RUNNING]\nabc\nxyz\n[COMPLETED] (0)\n", string(output))
+}
+
+func TestWait(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 1; exit 3", 0)
+	require.Nil(t, err)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "COMPLETED (3)\n", string(output))
+
+	// only the owner of the job can wait for it
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, "validclient2"), "wait", "--id", id}
+	_, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.NotNil(t, err)
+}
+
+func TestGetOutput(t *testing.T) {
+	// server
+	defer startServer(t, "-max-output-size", "16")()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo abc; echo xyz", 0)
+	require.Nil(t, err)
+
+	// the output of a running job can't be fetched at once
+	running, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+	_, err = getOutput(client, running, "--finished")
+	require.NotNil(t, err)
+	_, err = stopClient(client, running)
+	require.Nil(t, err)
+
+	_, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "wait", "--id", id).CombinedOutput()
+	require.Nil(t, err)
+	output, err := getOutput(client, id, "--finished")
+	require.Nil(t, err)
+	assert.Equal(t, "abc\nxyz\n", output)
+
+	// output larger than the maximum size must be streamed
+	id, err = startClient(client, "seq 1 100", 0)
+	require.Nil(t, err)
+	_, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "wait", "--id", id).CombinedOutput()
+	require.Nil(t, err)
+	output, err = getOutput(client, id, "--finished")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "ResourceExhausted")
+}
+
+func TestKeepalive(t *testing.T) {
+	// server pings the client every second and accepts pings from it
+	defer startServer(t, "-keepalive-time", "1s", "-keepalive-timeout", "1s", "-keepalive-min-time", "5s")()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 12; echo done", 0)
+	require.Nil(t, err)
+
+	// the output stream of a silent job survives the pings of both sides
+	output, err := getOutput(client, id, "--keepalive", "10s")
+	require.Nil(t, err)
+	assert.Equal(t, "done\n", output)
+}
+
+func TestOutputHeartbeat(t *testing.T) {
+	// server sends heartbeats on output streams silent for half a second
+	defer startServer(t, "-output-heartbeat", "500ms")()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo start; sleep 2; echo done", 0)
+	require.Nil(t, err)
+
+	// heartbeats aren't printed
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "start\ndone\n", output)
+}
+
+func TestMaxMsgSize(t *testing.T) {
+	// server
+	defer startServer(t, "-max-recv-msg-size", "1024")()
+
+	// the start request of a long command is larger than the server accepts
+	client := "validclient1"
+	output, err := startClient(client, "echo "+strings.Repeat("a", 2048), 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "ResourceExhausted")
+
+	// the client rejects output messages larger than it accepts
+	id, err := startClient(client, "seq 1 1000", 0)
+	require.Nil(t, err)
+	_, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "wait", "--id", id).CombinedOutput()
+	require.Nil(t, err)
+	output, err = getOutput(client, id, "--finished", "--max-recv-msg-size", "1024")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "ResourceExhausted")
+}
+
+func TestMaxDisk(t *testing.T) {
+	// the files of a job always use more than a byte, only the files of jobs are counted
+	defer startServer(t, "-max-disk", "1")()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+
+	// nothing can be reclaimed without finished jobs
+	output, err := startClient(client, "echo 123", 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "ResourceExhausted")
+
+	// the files of the finished job are reclaimed and the job is forgotten
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+	_, err = startClient(client, "echo 123", 0)
+	require.Nil(t, err)
+	_, err = getStatus(client, id)
+	require.NotNil(t, err)
+}
+
+func TestOutputRetention(t *testing.T) {
+	defer startServer(t, "-output-retention", "1s")()
+
+	client := "validclient1"
+	expired, err := startClient(client, "echo 123", 0)
+	require.Nil(t, err)
+	kept, err := startClient(client, "echo 123", 0, "--output-retention", "forever")
+	require.Nil(t, err)
+	longer, err := startClient(client, "echo 123", 0, "--output-retention", "1h")
+	require.Nil(t, err)
+
+	// the job is forgotten once the server retention expires after it finishes
+	assert.Eventually(t, func() bool {
+		_, err := getStatus(client, expired)
+		return err != nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// the jobs with their own retention are still there
+	output, err := getOutput(client, kept)
+	require.Nil(t, err)
+	assert.Equal(t, "123\n", output)
+	_, err = getStatus(client, longer)
+	assert.Nil(t, err)
+
+	output, err = startClient(client, "echo 123", 0, "--output-retention=-1s")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "invalid output retention")
+}
+
+func TestList(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	first, err := startClient(client, "true", 0)
+	require.Nil(t, err)
+	second, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+	defer stopClient(client, second)
+	// jobs of other clients aren't listed
+	_, err = startClient("validclient2", "true", 0)
+	require.Nil(t, err)
+
+	listIDs := func(order string) []string {
+		clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "list", "--sort", order}
+		output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+		require.Nil(t, err, string(output))
+
+		var ids []string
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			ids = append(ids, strings.Fields(line)[0])
+		}
+		return ids
+	}
+	assert.Equal(t, []string{first, second}, listIDs("oldest"))
+	assert.Equal(t, []string{second, first}, listIDs("newest"))
+
+	// running jobs come before completed jobs
+	_, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "wait", "--id", first).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, []string{second, first}, listIDs("status"))
+}
+
+func TestTimeoutDuration(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0, "--timeout", "1s")
+	require.Nil(t, err)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "TIMEDOUT (-1)\n", string(output))
+
+	// whole seconds are still accepted
+	id, err = startClient(client, "echo 123", 0, "--timeout", "90")
+	require.Nil(t, err)
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details"}
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), "Timeout: 1m30s\n")
+
+	// sub-second timeouts aren't rounded to seconds
+	start := time.Now()
+	id, err = startClient(client, "sleep 10", 0, "--timeout", "200ms")
+	require.Nil(t, err)
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "TIMEDOUT (-1)\n", string(output))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details"}
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), "Timeout: 200ms\n")
+
+	for _, timeout := range []string{"-5s", "-5", "5 minutes"} {
+		_, err := startClient(client, "true", 0, "--timeout", timeout)
+		assert.NotNil(t, err, timeout)
+	}
+}
+
+func TestInactivityTimeout(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo 123 && sleep 60", 0, "--inactivity-timeout", "2s")
+	require.Nil(t, err)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "INACTIVE (-1)\n", string(output))
+
+	jobOutput, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "123\n", jobOutput)
+}
+
+func TestJobDeadline(t *testing.T) {
+	// server
+	defer startServer(t, "-job-deadline", "1s")()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+
+	// the server stops the job once the deadline expires even though it has no timeout
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "STOPPED (-1)\n", string(output))
+
+	// the library timeout takes precedence when it expires before the deadline
+	id, err = startClient(client, "sleep 10", 1)
+	require.Nil(t, err)
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "TIMEDOUT (-1)\n", string(output))
+}
+
+func TestMaxStreams(t *testing.T) {
+	// server
+	defer startServer(t, "-max-streams-per-client", "1")()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 3; echo done", 0)
+	require.Nil(t, err)
+
+	// the first output stream lasts until the job completes
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "output", "--id", id}
+	first := exec.Command(clientBin, clientArgs...)
+	require.Nil(t, first.Start())
+	time.Sleep(time.Second)
+
+	// the second concurrent output stream is rejected
+	output, err := getOutput(client, id)
+	assert.NotNil(t, err)
+	assert.Contains(t, output, "ResourceExhausted")
+
+	// streams are released once they finish
+	require.Nil(t, first.Wait())
+	output, err = getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "done\n", output)
+}
+
+func TestStatusDetails(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo 123", 5)
+	require.Nil(t, err)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details"}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), "Command: echo 123\nProfile: default\nTimeout: 5s\n")
+
+	// more output may come from a running job
+	id, err = startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details"}
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), "Output complete: false\n")
+	// PIDs aren't exposed by default
+	assert.NotContains(t, string(output), "PID:")
+
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	_, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details"}
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), "Output complete: true\n")
+}
+
+func TestExposePIDs(t *testing.T) {
+	defer startServer(t, "-expose-pids")()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+	defer stopClient(client, id)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details"}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Regexp(t, `PID: [1-9][0-9]*\n`, string(output))
+
+	// the PID of a job that isn't running anymore isn't returned
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+	output, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.NotContains(t, string(output), "PID:")
+}
+
+func TestVolume(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo persisted > /data/file", 0, "--volume", "test-volume:/data")
+	require.Nil(t, err)
+	_, err = getOutput(client, id)
+	require.Nil(t, err)
+
+	// the volume persists across jobs
+	id, err = startClient(client, "cat /data/file", 0, "--volume", "test-volume:/data")
+	require.Nil(t, err)
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "persisted\n", output)
+
+	// volumes are isolated between clients
+	id, err = startClient("validclient2", "cat /data/file", 0, "--volume", "test-volume:/data")
+	require.Nil(t, err)
+	output, err = getOutput("validclient2", id)
+	require.Nil(t, err)
+	assert.Contains(t, output, "No such file or directory")
+
+	output, err = volumeClient(client, "volumes")
+	require.Nil(t, err)
+	assert.Contains(t, output, "test-volume\tsize=10\n")
+
+	// a volume isn't deleted while a job mounts it
+	id, err = startClient(client, "sleep 10", 0, "--volume", "test-volume:/data")
+	require.Nil(t, err)
+	output, err = volumeClient(client, "delete-volume", "--name", "test-volume")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "volume is in use")
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+
+	_, err = volumeClient(client, "delete-volume", "--name", "test-volume")
+	require.Nil(t, err)
+	id, err = startClient(client, "cat /data/file", 0, "--volume", "test-volume:/data")
+	require.Nil(t, err)
+	output, err = getOutput(client, id)
+	require.Nil(t, err)
+	assert.Contains(t, output, "No such file or directory")
+
+	// only the volume of the client is deleted
+	_, err = volumeClient("validclient2", "delete-volume", "--name", "test-volume")
+	require.Nil(t, err)
+	_, err = volumeClient("validclient2", "delete-volume", "--name", "test-volume")
+	require.NotNil(t, err)
+	_, err = volumeClient(client, "delete-volume", "--name", "test-volume")
+	require.Nil(t, err)
+}
+
+func TestVolumeQuota(t *testing.T) {
+	// server
+	defer startServer(t, "-disk-quota", "64")()
+
+	// the files in the volumes of a client count against its quota
+	client := "validclient1"
+	id, err := startClient(client, "printf '%0100d' 0 > /data/file", 0, "--volume", "quota-volume:/data")
+	require.Nil(t, err)
+	_, err = getOutput(client, id)
+	require.Nil(t, err)
+
+	output, err := startClient(client, "true", 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "Disk quota exceeded")
+
+	// deleting the volume frees the quota
+	_, err = volumeClient(client, "delete-volume", "--name", "quota-volume")
+	require.Nil(t, err)
+	_, err = startClient(client, "true", 0)
+	require.Nil(t, err)
+}
+
+func volumeClient(client string, args ...string) (string, error) {
+	clientArgs := append([]string{"--certs", filepath.Join(clientCerts, client)}, args...)
+	cmd := exec.Command(clientBin, clientArgs...)
+	fmt.Printf("Running command: %s\n", cmd)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func TestPauseResume(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+
+	status, err := pauseClient(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "PAUSED", status)
+
+	// only a running job can be paused
+	_, err = pauseClient(client, id)
+	require.NotNil(t, err)
+
+	// only the owner of the job can resume it
+	_, err = resumeClient("validclient2", id)
+	require.NotNil(t, err)
+
+	status, err = resumeClient(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "RUNNING", status)
+
+	status, err = stopClient(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "STOPPED (-1)", status)
+}
+
+func TestSignal(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "trap 'echo usr1; exit 0' USR1; while true; do sleep 0.1; done", 0)
+	require.Nil(t, err)
+	defer stopClient(client, id)
+	time.Sleep(500 * time.Millisecond)
+
+	signal := func(client, sig string) (string, error) {
+		clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "signal", "--id", id, "--signal", sig}
+		output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+		return string(output), err
+	}
+
+	// signals outside of the allow-list are rejected
+	output, err := signal(client, "KILL")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "InvalidArgument")
+
+	// only the owner of the job can signal it
+	output, err = signal("validclient2", "USR1")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "PermissionDenied")
+
+	output, err = signal(client, "USR1")
+	require.Nil(t, err, output)
+	assert.Equal(t, "RUNNING\n", output)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	waitOutput, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "COMPLETED (0)\n", string(waitOutput))
+	output, err = getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "usr1\n", output)
+}
+
+func TestSearchOutput(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "for i in $(seq 1 20); do echo line $i; done; echo error 21", 0)
+	require.Nil(t, err)
+	defer stopClient(client, id)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	_, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+
+	grep := func(client string, flags ...string) (string, error) {
+		clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "grep", "--id", id}
+		clientArgs = append(clientArgs, flags...)
+		output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+		return string(output), err
+	}
+
+	output, err := grep(client, "--pattern", "line 1")
+	require.Nil(t, err, output)
+	assert.Equal(t, "line 1\nline 10\nline 11\nline 12\nline 13\nline 14\nline 15\nline 16\nline 17\nline 18\nline 19\n", output)
+
+	output, err = grep(client, "--pattern", "^line [38]$|^error", "--regexp", "--context", "1", "--line-number")
+	require.Nil(t, err, output)
+	assert.Equal(t, "2-line 2\n3:line 3\n4-line 4\n--\n7-line 7\n8:line 8\n9-line 9\n--\n20-line 20\n21:error 21\n", output)
+
+	output, err = grep(client, "--pattern", "a(", "--regexp")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "InvalidArgument")
+
+	// only the owner of the job can search its output
+	output, err = grep("validclient2", "--pattern", "line")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "PermissionDenied")
+}
+
+func TestExport(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo 123; exit 3", 0)
+	require.Nil(t, err)
+	defer stopClient(client, id)
+
+	export := func(client string) (string, error) {
+		clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "export", "--id", id, "--out", filepath.Join(t.TempDir(), "job.tar.gz")}
+		output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+		return strings.TrimSpace(string(output)), err
+	}
+
+	// only finished jobs can be exported
+	id2, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+	defer stopClient(client, id2)
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "export", "--id", id2, "--out", filepath.Join(t.TempDir(), "job.tar.gz")}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.NotNil(t, err)
+	assert.Contains(t, string(output), "FailedPrecondition")
+
+	clientArgs = []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	_, err = exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+
+	// only the owner of the job can export it
+	path, err := export("validclient2")
+	require.NotNil(t, err)
+	assert.Contains(t, path, "PermissionDenied")
+
+	path, err = export(client)
+	require.Nil(t, err, path)
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.Nil(t, err)
+	tr := tar.NewReader(gr)
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		files[hdr.Name], err = io.ReadAll(tr)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, "123\n", string(files["output.log"]))
+
+	var manifest struct {
+		ID          string     `json:"id"`
+		Command     string     `json:"command"`
+		Status      string     `json:"status"`
+		ExitCode    int        `json:"exit_code"`
+		StartedAt   time.Time  `json:"started_at"`
+		FinishedAt  *time.Time `json:"finished_at"`
+		Output      string     `json:"output"`
+		OutputBytes int64      `json:"output_bytes"`
+	}
+	require.Nil(t, json.Unmarshal(files["manifest.json"], &manifest))
+	assert.Equal(t, id, manifest.ID)
+	assert.Equal(t, "echo 123; exit 3", manifest.Command)
+	assert.Equal(t, "COMPLETED", manifest.Status)
+	assert.Equal(t, 3, manifest.ExitCode)
+	require.NotNil(t, manifest.FinishedAt)
+	assert.False(t, manifest.FinishedAt.Before(manifest.StartedAt))
+	assert.Equal(t, "output.log", manifest.Output)
+	assert.Equal(t, int64(len("123\n")), manifest.OutputBytes)
+}
+
+func TestPreExec(t *testing.T) {
+	// server
+	defer startServer(t, "-pre-exec", "echo seeded > /seed")()
+
+	client := "validclient1"
+	id, err := startClient(client, "cat /seed", 0)
+	require.Nil(t, err)
+	defer stopClient(client, id)
+
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "wait", "--id", id}
+	output, err := exec.Command(clientBin, clientArgs...).CombinedOutput()
+	require.Nil(t, err)
+	assert.Equal(t, "COMPLETED (0)\n", string(output))
+	out, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "seeded\n", out)
+}
+
+func TestFailedPreExec(t *testing.T) {
+	// server
+	defer startServer(t, "-pre-exec", "exit 1")()
+
+	output, err := startClient("validclient1", "echo 123", 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "pre-exec hook failed")
+}
+
+func TestEnvFile(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	envFile := filepath.Join(t.TempDir(), "vars.env")
+	require.Nil(t, os.WriteFile(envFile, []byte("# comment\n\nFOO=from-file\nBAR=bar baz\n"), 0600))
+
+	// --env overrides the env file
+	client := "validclient1"
+	id, err := startClient(client, `echo "$FOO,$BAR"`, 0, "--env-file", envFile, "--env", "FOO=from-flag")
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "from-flag,bar baz\n", output)
+
+	// malformed lines are rejected by the client
+	require.Nil(t, os.WriteFile(envFile, []byte("FOO=bar\nmalformed\n"), 0600))
+	output, err = startClient(client, "true", 0, "--env-file", envFile)
+	require.NotNil(t, err)
+	assert.Contains(t, output, ":2:")
+
+	// variables that affect the dynamic linker or the setup of the job are rejected by the server
+	for _, kv := range []string{"LD_PRELOAD=/data/evil.so", "_RUNNER_NSENTER_FDS=4"} {
+		output, err = startClient(client, "true", 0, "--env", kv)
+		require.NotNil(t, err)
+		assert.Contains(t, output, "is reserved")
+	}
+}
+
+func TestEnvDenyList(t *testing.T) {
+	// server
+	defer startServer(t, "-env-deny", "SECRET_*,ENV")()
+
+	client := "validclient1"
+	var stderr bytes.Buffer
+	cmd := exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "start",
+		"--env", "SECRET_TOKEN=abc", "--env", "ENV=/data/rc", "--env", "FOO=bar", `echo "$SECRET_TOKEN,$ENV,$FOO"`)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.Nil(t, err)
+	assert.Contains(t, stderr.String(), "Dropped environment variables: SECRET_TOKEN, ENV")
+
+	time.Sleep(500 * time.Millisecond)
+	output, err := getOutput(client, strings.TrimSpace(string(out)))
+	require.Nil(t, err)
+	assert.Equal(t, ",,bar\n", output)
+}
+
+func TestScript(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	script := "for i in 1 2 3; do\n\techo \"line $i\"\ndone\necho 'single \"quotes\"' | tr a-z A-Z\n"
+	expected := "line 1\nline 2\nline 3\nSINGLE \"QUOTES\"\n"
+
+	path := filepath.Join(t.TempDir(), "run.sh")
+	require.Nil(t, os.WriteFile(path, []byte(script), 0600))
+
+	client := "validclient1"
+	out, err := exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "start", "--script", path).CombinedOutput()
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+	output, err := getOutput(client, strings.TrimSpace(string(out)))
+	require.Nil(t, err)
+	assert.Equal(t, expected, output)
+
+	// the script is read from stdin with -
+	cmd := exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "start", "-")
+	cmd.Stdin = strings.NewReader(script)
+	out, err = cmd.CombinedOutput()
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+	output, err = getOutput(client, strings.TrimSpace(string(out)))
+	require.Nil(t, err)
+	assert.Equal(t, expected, output)
+
+	// scripts longer than a command accepted by the server are rejected by the client
+	require.Nil(t, os.WriteFile(path, []byte(strings.Repeat("# padding\n", 8*1024)), 0600))
+	out, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "start", "--script", path).CombinedOutput()
+	require.NotNil(t, err)
+	assert.Contains(t, string(out), "script is longer than 65536 bytes")
+}
+
+func TestMultipleOutputs(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id1, err := startClient(client, "echo one; sleep 1; printf two", 0)
+	require.Nil(t, err)
+	id2, err := startClient(client, "sleep 2; echo three", 0)
+	require.Nil(t, err)
+
+	// every line is prefixed with its job ID, a trailing partial line is terminated
+	output, err := getOutput(client, id1, "--id", id2)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	assert.ElementsMatch(t, []string{
+		"[" + id1 + "] one",
+		"[" + id1 + "] two",
+		"[" + id2 + "] three",
+	}, lines)
+
+	// the output of the other jobs is printed even if a job can't be found
+	output, err = getOutput(client, id1, "--id", "unknown")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "["+id1+"] one\n")
+}
+
+func TestDelete(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0, "--name", "deleted")
+	require.Nil(t, err)
+
+	deleteJob := func(client, id string) (string, error) {
+		// rm is an alias of delete
+		cmd := exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "rm", "--id", id)
+		output, err := cmd.CombinedOutput()
+		return string(output), err
+	}
+
+	// only a finished job can be deleted
+	output, err := deleteJob(client, id)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "FailedPrecondition")
+
+	// only the owner of the job can delete it
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+	_, err = deleteJob("validclient2", id)
+	require.NotNil(t, err)
+
+	_, err = deleteJob(client, id)
+	require.Nil(t, err)
+	_, err = getStatus(client, id)
+	require.NotNil(t, err)
+	assert.NoDirExists(t, filepath.Join(runnerHome, "client1", id))
+
+	// the name of the deleted job can be used again
+	_, err = startClient(client, "true", 0, "--name", "deleted")
+	require.Nil(t, err)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	// server
+	defer startServer(t, "-idempotency-ttl", "2s")()
+
+	client := "validclient1"
+	first, err := startClient(client, "echo 123", 0, "--idempotency-key", "retried")
+	require.Nil(t, err)
+
+	// a retry with the same key returns the same job
+	second, err := startClient(client, "echo 123", 0, "--idempotency-key", "retried")
+	require.Nil(t, err)
+	assert.Equal(t, first, second)
+
+	// keys are per client
+	other, err := startClient("validclient2", "echo 123", 0, "--idempotency-key", "retried")
+	require.Nil(t, err)
+	assert.NotEqual(t, first, other)
+
+	// the key expires after the TTL
+	time.Sleep(3 * time.Second)
+	third, err := startClient(client, "echo 123", 0, "--idempotency-key", "retried")
+	require.Nil(t, err)
+	assert.NotEqual(t, first, third)
+}
+
+func TestRetries(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	// the command fails twice, then succeeds
+	client := "validclient1"
+	command := "n=$(($(cat /attempts 2>/dev/null || echo 0) + 1)); echo $n > /attempts; [ $n -ge 3 ]"
+	id, err := startClient(client, command, 0, "--retries", "3", "--retry-backoff", "10ms")
+	require.Nil(t, err)
+	time.Sleep(time.Second)
+
+	out, err := exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "status", "--id", id, "--details").CombinedOutput()
+	require.Nil(t, err, string(out))
+	assert.Contains(t, string(out), "COMPLETED (0)")
+	assert.Contains(t, string(out), "Attempts: [1 1 0]")
+}
+
+func TestScheduledStart(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo 123", 0, "--start-after", "1s")
+	require.Nil(t, err)
+	status, err := getStatus(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "SCHEDULED", status)
+
+	time.Sleep(2 * time.Second)
+	status, err = getStatus(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "COMPLETED (0)", status)
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "123\n", output)
+
+	// a job that's stopped before it's due is never launched
+	at := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	id, err = startClient(client, "echo 123", 0, "--start-at", at)
+	require.Nil(t, err)
+	status, err = stopClient(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "STOPPED (-1)", status)
+
+	_, err = startClient(client, "echo 123", 0, "--start-after", "1s", "--start-at", at)
+	assert.NotNil(t, err)
+}
+
+func TestMaxJobs(t *testing.T) {
+	// server
+	defer startServer(t, "-max-jobs", "1", "-max-queued", "1")()
+
+	client := "validclient1"
+	first, err := startClient(client, "sleep 1", 0)
+	require.Nil(t, err)
+	second, err := startClient(client, "echo 123", 0, "--priority", "5")
+	require.Nil(t, err)
+	status, err := getStatus(client, second)
+	require.Nil(t, err)
+	assert.Equal(t, "QUEUED", status)
+
+	// no job has finished yet, so there's no estimate of when the queued job starts
+	out, err := exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "status", "--id", second, "--details").CombinedOutput()
+	require.Nil(t, err, string(out))
+	assert.Contains(t, string(out), "Queue position: 1\nEstimated start: unknown\n")
+
+	out, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "server-status").CombinedOutput()
+	require.Nil(t, err, string(out))
+	assert.Equal(t, "Running jobs: 1 (max 1)\nQueued jobs: 1 (max 1)\n  priority 5: 1\n", string(out))
+
+	// there's no room for a third job
+	out, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "start", "true").CombinedOutput()
+	require.NotNil(t, err)
+	assert.Contains(t, string(out), "ResourceExhausted")
+
+	// the queued job is started once the running job finishes
+	time.Sleep(2 * time.Second)
+	for _, id := range []string{first, second} {
+		status, err = getStatus(client, id)
+		require.Nil(t, err)
+		assert.Equal(t, "COMPLETED (0)", status)
+	}
+	output, err := getOutput(client, second)
+	require.Nil(t, err)
+	assert.Equal(t, "123\n", output)
+}
+
+func TestOutputRate(t *testing.T) {
+	// server
+	defer startServer(t, "-output-rate", "1000")()
+
+	client := "validclient1"
+	id, err := startClient(client, "head -c 3000 /dev/zero", 0)
+	require.Nil(t, err)
+	time.Sleep(time.Second)
+
+	// a second's worth of output is streamed right away, the rest at the rate
+	start := time.Now()
+	output, err := getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, 3000, len(output))
+	assert.GreaterOrEqual(t, time.Since(start), 1500*time.Millisecond)
+}
+
+func TestInsecure(t *testing.T) {
+	// the server refuses to run without mTLS unless it's confirmed
+	out, err := exec.Command(serverBin, "-insecure").CombinedOutput()
+	require.NotNil(t, err)
+	assert.Contains(t, string(out), "-insecure requires -insecure-confirm")
+
+	// server
+	defer startServer(t, "-insecure", "-insecure-confirm")()
+
+	out, err = exec.Command(clientBin, "--insecure", "start", "echo 123").CombinedOutput()
+	require.Nil(t, err, string(out))
+	id := strings.TrimSpace(string(out))
+	time.Sleep(time.Second)
+	out, err = exec.Command(clientBin, "--insecure", "output", "--id", id).CombinedOutput()
+	require.Nil(t, err, string(out))
+	assert.Equal(t, "123\n", string(out))
+
+	// the files of the jobs of insecure clients are kept apart from the files of other clients
+	_, err = os.Stat(filepath.Join(runnerHome, "insecure", id))
+	assert.Nil(t, err)
+
+	// clients with certificates can't connect
+	_, err = startClient("validclient1", "echo 123", 0)
+	assert.NotNil(t, err)
+}
+
+func TestPprof(t *testing.T) {
+	defer startServer(t, "-pprof-addr", "localhost:6060")()
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = http.Get("http://localhost:6060/debug/pprof/goroutine?debug=1")
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCompletion(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	// completion scripts don't need the certs
+	output, err := exec.Command(clientBin, "completion", "bash").CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), "bash completion")
+
+	// job IDs are completed from the jobs of the client
+	client := "validclient1"
+	id, err := startClient(client, "echo 123", 0)
+	require.Nil(t, err)
+	output, err = exec.Command(clientBin, "__complete", "--certs", filepath.Join(clientCerts, client), "status", "--id", "").CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), id+"\t")
+
+	// aliases run the same commands
+	output, err = exec.Command(clientBin, "--certs", filepath.Join(clientCerts, client), "ls").CombinedOutput()
+	require.Nil(t, err)
+	assert.Contains(t, string(output), id)
+}
+
+func TestPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.Nil(t, os.WriteFile(path, []byte(`{"allow": ["echo"], "deny": ["secret"]}`), 0600))
+
+	// server
+	defer startServer(t, "-policy", path)()
+
+	client := "validclient1"
+	_, err := startClient(client, "echo 123", 0)
+	require.Nil(t, err)
+	for _, command := range []string{"cat /etc/passwd", "echo secret", "echo 123; id"} {
+		output, err := startClient(client, command, 0)
+		require.NotNil(t, err, command)
+		assert.Contains(t, output, "PermissionDenied", command)
+	}
+
+	// the policy is reloaded when the file is rewritten while the server is running
+	require.Nil(t, os.WriteFile(path, []byte(`{"allow": ["cat"]}`), 0600))
+	time.Sleep(500 * time.Millisecond)
+	_, err = startClient(client, "cat /etc/hostname", 0)
+	require.Nil(t, err)
+	output, err := startClient(client, "echo 123", 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "PermissionDenied")
+}
+
+func TestAdmissionWebhook(t *testing.T) {
+	// webhook that denies all the commands containing "forbidden"
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CN      string `json:"cn"`
+			Command string `json:"command"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		allowed := !strings.Contains(req.Command, "forbidden")
+		fmt.Fprintf(w, `{"allowed": %t, "reason": "%s can't run forbidden commands"}`, allowed, req.CN)
+	}))
+	defer webhook.Close()
+
+	stop := startServer(t, "-admission-webhook", webhook.URL)
+	client := "validclient1"
+	_, err := startClient(client, "echo allowed", 0)
+	require.Nil(t, err)
+	output, err := startClient(client, "echo forbidden", 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "PermissionDenied")
+	assert.Contains(t, output, "validclient1 can't run forbidden commands")
+	stop()
+
+	// jobs are denied when the webhook can't be reached, unless it fails open
+	url := webhook.URL
+	webhook.Close()
+	stop = startServer(t, "-admission-webhook", url, "-admission-timeout", "1s")
+	output, err = startClient(client, "echo allowed", 0)
+	require.NotNil(t, err)
+	assert.Contains(t, output, "PermissionDenied")
+	stop()
+
+	defer startServer(t, "-admission-webhook", url, "-admission-timeout", "1s", "-admission-fail-open")()
+	_, err = startClient(client, "echo allowed", 0)
+	require.Nil(t, err)
+}
+
+func TestExec(t *testing.T) {
+	// server
+	defer startServer(t)()
+
+	client := "validclient1"
+	id, err := startClient(client, "echo 123 > /marker; sleep 10", 0)
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	// the command sees the root filesystem of the job
+	output, err := execClient(client, id, "cat", "/marker")
+	require.Nil(t, err)
+	assert.Equal(t, "123\n", output)
+
+	// the client exits with the exit code of the command
+	_, err = execClient(client, id, "sh", "-c", "exit 3")
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+
+	// only the owner of the job can exec in it
+	_, err = execClient("validclient2", id, "true")
+	require.NotNil(t, err)
+
+	// the output of the command isn't part of the output of the job
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+	output, err = getOutput(client, id)
+	require.Nil(t, err)
+	assert.Equal(t, "", output)
+
+	// only a running job can exec
+	_, err = execClient(client, id, "true")
+	require.NotNil(t, err)
+}
+
+func startClient(client, command string, timeout int, flags ...string) (id string, err error) {
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "start", "--timeout", strconv.Itoa(timeout)}
+	clientArgs = append(clientArgs, flags...)
+	clientArgs = append(clientArgs, command)
 	cmd := exec.Command(clientBin, clientArgs...)
 	fmt.Printf("Running command: %s\n", cmd)
 	output, err := cmd.CombinedOutput()
@@ -193,8 +1457,9 @@ func startClient(client, command string, timeout int) (id string, err error) {
 	return string(output[:len(output)-1]), err
 }
 
-func getOutput(client, id string) (string, error) {
+func getOutput(client, id string, flags ...string) (string, error) {
 	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "output", "--id", id}
+	clientArgs = append(clientArgs, flags...)
 	cmd := exec.Command(clientBin, clientArgs...)
 	fmt.Printf("Running command: %s\n", cmd)
 	output, err := cmd.CombinedOutput()
@@ -217,9 +1482,55 @@ func stopClient(client, id string) (string, error) {
 	return string(output[:len(output)-1]), err
 }
 
-func startServer(t *testing.T) func() {
+func pauseClient(client, id string) (string, error) {
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "pause", "--id", id}
+	cmd := exec.Command(clientBin, clientArgs...)
+	fmt.Printf("Running command: %s\n", cmd)
+	output, err := cmd.CombinedOutput()
+	return string(output[:len(output)-1]), err
+}
+
+func resumeClient(client, id string) (string, error) {
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "resume", "--id", id}
+	cmd := exec.Command(clientBin, clientArgs...)
+	fmt.Printf("Running command: %s\n", cmd)
+	output, err := cmd.CombinedOutput()
+	return string(output[:len(output)-1]), err
+}
+
+func TestExecCommandChecks(t *testing.T) {
+	// server
+	defer startServer(t, "-max-command-len", "16")()
+
+	client := "validclient1"
+	id, err := startClient(client, "sleep 10", 0)
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	_, err = execClient(client, id, "true")
+	require.Nil(t, err)
+
+	// exec is held to the same limits as the command of a job
+	output, err := execClient(client, id, "echo", strings.Repeat("a", 32))
+	require.NotNil(t, err)
+	assert.Contains(t, output, "maximum allowed is 16 bytes")
+
+	_, err = stopClient(client, id)
+	require.Nil(t, err)
+}
+
+func execClient(client, id string, args ...string) (string, error) {
+	clientArgs := []string{"--certs", filepath.Join(clientCerts, client), "exec", "--id", id, "--"}
+	clientArgs = append(clientArgs, args...)
+	cmd := exec.Command(clientBin, clientArgs...)
+	fmt.Printf("Running command: %s\n", cmd)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+func startServer(t *testing.T, flags ...string) func() {
 	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.CommandContext(ctx, serverBin, serverCerts)
+	cmd := exec.CommandContext(ctx, serverBin, append(flags, serverCerts)...)
 	err := cmd.Start()
 	stop := func() {
 		cancel()
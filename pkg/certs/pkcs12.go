@@ -0,0 +1,30 @@
+// package certs provides the certificate loading shared by the Runner server and client
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// LoadPKCS12 decodes the PKCS#12 (.p12 or .pfx) file at path into the certificate it holds and the
+// CA certificates in the file
+func LoadPKCS12(path, password string) (tls.Certificate, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	key, cert, cas, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	certificate := tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	return certificate, cas, nil
+}
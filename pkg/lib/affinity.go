@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxCPUs is the number of CPUs a CPU set can refer to, the size of unix.CPUSet
+const maxCPUs = 1024
+
+// ErrInvalidCPUSet is returned by StartJob when the CPU set of the job can't be parsed or refers
+// to CPUs the runner can't run on
+var ErrInvalidCPUSet = errors.New("invalid CPU set")
+
+// parseCPUSet parses a CPU set in the list format of cpuset(7), e.g. 0-3,6, into the sorted CPUs
+// of the set
+func parseCPUSet(list string) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCPUSet, list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidCPUSet, list)
+			}
+		}
+		if last >= maxCPUs {
+			return nil, fmt.Errorf("%w: CPU %d is out of range [0, %d)", ErrInvalidCPUSet, last, maxCPUs)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// jobCPUs returns the CPUs of the CPU set of a job, which must all be available to the runner.
+// It returns no CPUs if the CPU set is empty, the job isn't pinned then.
+func jobCPUs(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	cpus, err := parseCPUSet(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var available unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &available); err != nil {
+		return nil, fmt.Errorf("failed to get the CPUs of the runner: %w", err)
+	}
+	for _, cpu := range cpus {
+		if !available.IsSet(cpu) {
+			return nil, fmt.Errorf("%w: CPU %d isn't available", ErrInvalidCPUSet, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// setAffinity pins the handler of a job to cpus, the command and its child processes inherit the
+// affinity. Affinity is per thread, so the handler stays on the thread the command is forked from.
+func setAffinity(cpus []int) error {
+	runtime.LockOSThread()
+
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cgroupMountPoint = "/sys/fs/cgroup" // where the cgroup hierarchies are mounted
+	cpuPeriod        = 100000           // CPU bandwidth period in microseconds
+
+	freezeTimeout      = 5 * time.Second       // how long to wait for a cgroup to be frozen or thawed
+	freezePollInterval = 10 * time.Millisecond // how often the freezer state of a cgroup is checked
+)
+
+// cgroupManager manages the cgroup of a job
+type cgroupManager interface {
+	// Create creates the cgroup and applies the resource limits to it
+	Create(limits ResourceLimits) error
+
+	// RestrictDevices denies the processes in the cgroup access to all the devices except devices
+	RestrictDevices(devices []device) error
+
+	// AddProcess moves the process with pid to the cgroup
+	AddProcess(pid int) error
+
+	// Remove removes the cgroup. The cgroup must not have any processes.
+	Remove() error
+
+	// Stats returns the current resource usage of the processes in the cgroup
+	Stats() (LiveUsage, error)
+
+	// Usage returns the CPU time and the peak memory usage of all the processes that ran in the
+	// cgroup. MaxRSS is 0 if the kernel doesn't track the peak memory usage of cgroups.
+	Usage() (Usage, error)
+
+	// Freeze freezes all the processes in the cgroup if frozen is true, thaws them otherwise. It
+	// returns once the cgroup reached the requested state.
+	Freeze(frozen bool) error
+}
+
+// newCgroupManager detects the cgroup hierarchy of the host and returns a cgroupManager for the
+// cgroup with name under CgroupParent. cgroup v2 is preferred, v1 is used as a fallback when v2
+// isn't available.
+func newCgroupManager(name string) (cgroupManager, error) {
+	if err := validateCgroupParent(CgroupParent); err != nil {
+		return nil, err
+	}
+
+	if cgroupV2Available(cgroupMountPoint) {
+		return &cgroupV2{
+			root: cgroupMountPoint,
+			path: filepath.Join(cgroupMountPoint, CgroupParent, name),
+		}, nil
+	}
+
+	if cgroupV1Available(cgroupMountPoint) {
+		return &cgroupV1{
+			root: cgroupMountPoint,
+			name: filepath.Join(CgroupParent, name),
+		}, nil
+	}
+
+	return nil, noCgroupHierarchyError()
+}
+
+// ValidateCgroupParent checks that the cgroups of jobs can be created under CgroupParent. The
+// CgroupParent cgroup is created if it doesn't exist. A *SetupError is returned if the host isn't
+// set up correctly.
+func ValidateCgroupParent() error {
+	if err := validateCgroupParent(CgroupParent); err != nil {
+		return err
+	}
+
+	var dirs []string
+	switch {
+	case cgroupV2Available(cgroupMountPoint):
+		dirs = []string{filepath.Join(cgroupMountPoint, CgroupParent)}
+	case cgroupV1Available(cgroupMountPoint):
+		for _, controller := range cgroupV1Controllers {
+			dirs = append(dirs, filepath.Join(cgroupMountPoint, controller, CgroupParent))
+		}
+	default:
+		return noCgroupHierarchyError()
+	}
+
+	for _, dir := range dirs {
+		if err := probeCgroupDir(dir); err != nil {
+			return &SetupError{
+				Reason: fmt.Sprintf("cgroup parent %s isn't writable", dir),
+				Hint: "delegate the cgroup parent to the runner, e.g. run it in a systemd unit " +
+					"with Delegate=yes and set the cgroup parent to a path under its cgroup",
+				Err: err,
+			}
+		}
+	}
+	return nil
+}
+
+// validateCgroupParent checks that parent is a clean path relative to the root of the cgroup
+// hierarchy
+func validateCgroupParent(parent string) error {
+	if parent == "" || filepath.IsAbs(parent) || filepath.Clean(parent) != parent ||
+		parent == ".." || strings.HasPrefix(parent, "../") {
+		return fmt.Errorf("invalid cgroup parent %q, must be a relative path", parent)
+	}
+	return nil
+}
+
+// probeCgroupDir creates the cgroup at dir if it doesn't exist and checks that child cgroups can be
+// created in it
+func probeCgroupDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	probe := filepath.Join(dir, fmt.Sprintf("probe-%d", os.Getpid()))
+	if err := os.Mkdir(probe, 0755); err != nil {
+		return err
+	}
+	return os.Remove(probe)
+}
+
+// noCgroupHierarchyError returns the error for hosts without a usable cgroup hierarchy
+func noCgroupHierarchyError() error {
+	return &SetupError{
+		Reason: "no usable cgroup hierarchy",
+		Hint: "mount cgroup v2 at " + cgroupMountPoint + " or cgroup v1 " +
+			strings.Join(cgroupV1Controllers, ", ") + " controllers under " +
+			cgroupMountPoint + "/<controller>",
+		Err: errors.New("cgroup hierarchy not found"),
+	}
+}
+
+// writeCgroupFile writes value to the cgroup interface file at dir/file
+func writeCgroupFile(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0)
+}
+
+// removeCgroupDir removes the cgroup directory at dir, it's not an error if dir doesn't exist
+func removeCgroupDir(dir string) error {
+	if err := os.Remove(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readCgroupInt reads the integer value of the cgroup interface file at dir/file
+func readCgroupInt(dir, file string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readCgroupStat reads the "key value" lines of the cgroup interface file at dir/file, like
+// cpu.stat
+func readCgroupStat(dir, file string) (map[string]int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	stat := make(map[string]int64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in %s/%s: %w", fields[0], dir, file, err)
+		}
+		stat[fields[0]] = value
+	}
+	return stat, nil
+}
+
+// readCgroupPeak reads the peak memory usage in the cgroup interface file at dir/file, 0 if the
+// kernel doesn't provide the file
+func readCgroupPeak(dir, file string) (int64, error) {
+	peak, err := readCgroupInt(dir, file)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	return peak, err
+}
+
+// waitCgroupState polls until done returns true or freezeTimeout expires
+func waitCgroupState(done func() (bool, error)) error {
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the cgroup to change its freezer state")
+		}
+		time.Sleep(freezePollInterval)
+	}
+}
+
+// cpuQuota returns the CPU bandwidth quota in microseconds per cpuPeriod for cpus
+func cpuQuota(cpus float64) string {
+	return strconv.FormatInt(int64(cpus*cpuPeriod), 10)
+}
@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupV1Controllers are the controllers used for the cgroups of the jobs
+var cgroupV1Controllers = []string{"cpu", "cpuacct", "devices", "freezer", "memory", "pids"}
+
+// cgroupV1 is a cgroupManager for the cgroup v1 hierarchies, one per controller
+type cgroupV1 struct {
+	root string // directory where the controller hierarchies are mounted
+	name string // path to the cgroup relative to each controller hierarchy
+}
+
+// cgroupV1Available checks whether all the required cgroup v1 controllers are mounted under root
+func cgroupV1Available(root string) bool {
+	for _, controller := range cgroupV1Controllers {
+		if _, err := os.Stat(filepath.Join(root, controller, "cgroup.procs")); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// path returns the path to the cgroup in the hierarchy of controller
+func (c *cgroupV1) path(controller string) string {
+	return filepath.Join(c.root, controller, c.name)
+}
+
+// Create creates the cgroup in all the controller hierarchies and applies the resource limits
+func (c *cgroupV1) Create(limits ResourceLimits) error {
+	for _, controller := range cgroupV1Controllers {
+		if err := os.MkdirAll(c.path(controller), 0755); err != nil {
+			return err
+		}
+	}
+
+	if limits.CPUs > 0 {
+		if err := writeCgroupFile(c.path("cpu"), "cpu.cfs_period_us", strconv.Itoa(cpuPeriod)); err != nil {
+			return err
+		}
+		if err := writeCgroupFile(c.path("cpu"), "cpu.cfs_quota_us", cpuQuota(limits.CPUs)); err != nil {
+			return err
+		}
+	}
+	if limits.MemoryMax > 0 {
+		value := strconv.FormatInt(limits.MemoryMax, 10)
+		if err := writeCgroupFile(c.path("memory"), "memory.limit_in_bytes", value); err != nil {
+			return err
+		}
+	}
+	if limits.PidsMax > 0 {
+		value := strconv.FormatInt(limits.PidsMax, 10)
+		if err := writeCgroupFile(c.path("pids"), "pids.max", value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestrictDevices denies access to all the devices except devices
+func (c *cgroupV1) RestrictDevices(devices []device) error {
+	if err := writeCgroupFile(c.path("devices"), "devices.deny", "a"); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if err := writeCgroupFile(c.path("devices"), "devices.allow", d.String()+" rwm"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddProcess moves the process with pid to the cgroup in all the controller hierarchies
+func (c *cgroupV1) AddProcess(pid int) error {
+	for _, controller := range cgroupV1Controllers {
+		if err := writeCgroupFile(c.path(controller), "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Freeze freezes or thaws the processes in the cgroup
+func (c *cgroupV1) Freeze(frozen bool) error {
+	state := "THAWED"
+	if frozen {
+		state = "FROZEN"
+	}
+	if err := writeCgroupFile(c.path("freezer"), "freezer.state", state); err != nil {
+		return err
+	}
+
+	// freezer.state reports FREEZING till all the processes are frozen
+	return waitCgroupState(func() (bool, error) {
+		data, err := os.ReadFile(filepath.Join(c.path("freezer"), "freezer.state"))
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(string(data)) == state, nil
+	})
+}
+
+// Stats returns the current resource usage of the processes in the cgroup
+func (c *cgroupV1) Stats() (LiveUsage, error) {
+	memory, err := readCgroupInt(c.path("memory"), "memory.usage_in_bytes")
+	if err != nil {
+		return LiveUsage{}, err
+	}
+	// cpuacct.usage is in nanoseconds
+	cpu, err := readCgroupInt(c.path("cpuacct"), "cpuacct.usage")
+	if err != nil {
+		return LiveUsage{}, err
+	}
+	return LiveUsage{
+		CPUTime:       time.Duration(cpu),
+		MemoryCurrent: memory,
+	}, nil
+}
+
+// Usage returns the CPU time and the peak memory usage of all the processes that ran in the cgroup
+func (c *cgroupV1) Usage() (Usage, error) {
+	// cpuacct.stat is in USER_HZ, which is 100 on Linux
+	const userHZ = 100
+	stat, err := readCgroupStat(c.path("cpuacct"), "cpuacct.stat")
+	if err != nil {
+		return Usage{}, err
+	}
+	user, userOK := stat["user"]
+	system, systemOK := stat["system"]
+	if !userOK || !systemOK {
+		return Usage{}, fmt.Errorf("user or system not found in %s/cpuacct.stat", c.path("cpuacct"))
+	}
+	peak, err := readCgroupPeak(c.path("memory"), "memory.max_usage_in_bytes")
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		UserTime:   time.Duration(user) * time.Second / userHZ,
+		SystemTime: time.Duration(system) * time.Second / userHZ,
+		MaxRSS:     peak,
+	}, nil
+}
+
+// Remove removes the cgroup from all the controller hierarchies
+func (c *cgroupV1) Remove() error {
+	var err error
+	for _, controller := range cgroupV1Controllers {
+		if rerr := removeCgroupDir(c.path(controller)); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
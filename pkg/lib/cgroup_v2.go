@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupV2Controllers are the controllers enabled for the cgroups of the jobs
+const cgroupV2Controllers = "+cpu +memory +pids"
+
+// cgroupV2 is a cgroupManager for the cgroup v2 unified hierarchy
+type cgroupV2 struct {
+	root string // mount point of the unified hierarchy
+	path string // path to the cgroup
+}
+
+// cgroupV2Available checks whether the cgroup v2 unified hierarchy is mounted at root
+func cgroupV2Available(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// Create creates the cgroup and applies the resource limits to it
+func (c *cgroupV2) Create(limits ResourceLimits) error {
+	parent := filepath.Dir(c.path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+
+	// Controllers must be enabled in all the ancestors of the cgroup. The ancestors above a
+	// delegated cgroup parent already have them enabled and usually aren't writable.
+	for dir := parent; ; dir = filepath.Dir(dir) {
+		if err := enableControllers(dir); err != nil {
+			return fmt.Errorf("failed to enable controllers in %s: %w", dir, err)
+		}
+		if dir == c.root {
+			break
+		}
+	}
+
+	if err := os.Mkdir(c.path, 0755); err != nil {
+		return err
+	}
+
+	if limits.CPUs > 0 {
+		value := fmt.Sprintf("%s %d", cpuQuota(limits.CPUs), cpuPeriod)
+		if err := writeCgroupFile(c.path, "cpu.max", value); err != nil {
+			return err
+		}
+	}
+	if limits.MemoryMax > 0 {
+		value := strconv.FormatInt(limits.MemoryMax, 10)
+		if err := writeCgroupFile(c.path, "memory.max", value); err != nil {
+			return err
+		}
+	}
+	if limits.PidsMax > 0 {
+		value := strconv.FormatInt(limits.PidsMax, 10)
+		if err := writeCgroupFile(c.path, "pids.max", value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableControllers enables cgroupV2Controllers for the children of the cgroup at dir, unless
+// they're already enabled
+func enableControllers(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+
+	enabled := strings.Fields(string(data))
+	missing := false
+	for _, controller := range strings.Fields(cgroupV2Controllers) {
+		if !contains(enabled, strings.TrimPrefix(controller, "+")) {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", cgroupV2Controllers)
+}
+
+// contains checks whether s is an element of list
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictDevices denies access to all the devices except devices. cgroup v2 doesn't have a
+// devices controller, access is controlled by an eBPF program attached to the cgroup.
+func (c *cgroupV2) RestrictDevices(devices []device) error {
+	return attachDeviceFilter(c.path, devices)
+}
+
+// AddProcess moves the process with pid to the cgroup
+func (c *cgroupV2) AddProcess(pid int) error {
+	return writeCgroupFile(c.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// Remove removes the cgroup
+func (c *cgroupV2) Remove() error {
+	return removeCgroupDir(c.path)
+}
+
+// Freeze freezes or thaws the processes in the cgroup
+func (c *cgroupV2) Freeze(frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+	if err := writeCgroupFile(c.path, "cgroup.freeze", value); err != nil {
+		return err
+	}
+
+	// cgroup.events reports "frozen 1" once all the processes are frozen
+	return waitCgroupState(func() (bool, error) {
+		data, err := os.ReadFile(filepath.Join(c.path, "cgroup.events"))
+		if err != nil {
+			return false, err
+		}
+		return contains(strings.Split(string(data), "\n"), "frozen "+value), nil
+	})
+}
+
+// Stats returns the current resource usage of the processes in the cgroup
+func (c *cgroupV2) Stats() (LiveUsage, error) {
+	memory, err := readCgroupInt(c.path, "memory.current")
+	if err != nil {
+		return LiveUsage{}, err
+	}
+
+	// usage_usec in cpu.stat is the total CPU time
+	stat, err := readCgroupStat(c.path, "cpu.stat")
+	if err != nil {
+		return LiveUsage{}, err
+	}
+	usec, ok := stat["usage_usec"]
+	if !ok {
+		return LiveUsage{}, fmt.Errorf("usage_usec not found in %s/cpu.stat", c.path)
+	}
+	return LiveUsage{
+		CPUTime:       time.Duration(usec) * time.Microsecond,
+		MemoryCurrent: memory,
+	}, nil
+}
+
+// Usage returns the CPU time and the peak memory usage of all the processes that ran in the
+// cgroup. memory.peak is only available since Linux 5.19.
+func (c *cgroupV2) Usage() (Usage, error) {
+	stat, err := readCgroupStat(c.path, "cpu.stat")
+	if err != nil {
+		return Usage{}, err
+	}
+	user, userOK := stat["user_usec"]
+	system, systemOK := stat["system_usec"]
+	if !userOK || !systemOK {
+		return Usage{}, fmt.Errorf("user_usec or system_usec not found in %s/cpu.stat", c.path)
+	}
+	peak, err := readCgroupPeak(c.path, "memory.peak")
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		UserTime:   time.Duration(user) * time.Microsecond,
+		SystemTime: time.Duration(system) * time.Microsecond,
+		MaxRSS:     peak,
+	}, nil
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+)
+
+// compressedOutFile returns the path to the compressed output file of the job
+func (j *job) compressedOutFile() string {
+	return j.outFile + ".gz"
+}
+
+// compressOutput compresses the output file of a completed job and removes the uncompressed
+// output file. Output readers that already opened the uncompressed output file continue reading
+// from it, new readers read from the compressed output file.
+func (j *job) compressOutput() error {
+	debugLog("Compressing output for %s", j)
+
+	tmpFile := j.compressedOutFile() + ".tmp"
+	if err := gzipFile(j.outFile, tmpFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	if err := os.Rename(tmpFile, j.compressedOutFile()); err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+
+	j.outputLock.Lock()
+	j.compressed = true
+	j.outputLock.Unlock()
+
+	return os.Remove(j.outFile)
+}
+
+// compressedOutput starts a goroutine that decompresses the compressed output file of the job
+// and sends the output to outChan. Only the last bytes of the output are sent if last isn't
+// negative.
+func (j *job) compressedOutput(outChan chan<- *Output, canceled <-chan struct{}, cancel func(), last int64) error {
+	f, err := os.Open(j.compressedOutFile())
+	if err != nil {
+		return err
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// goroutine to decompress the output file and send data to the out channel
+	go func() {
+		defer close(outChan)
+		defer cancel()
+
+		defer func() {
+			if err := f.Close(); err != nil {
+				debugLog("Failed to close %s: %v", j.compressedOutFile(), err)
+			}
+		}()
+
+		if last >= 0 {
+			if err := skipToLast(f, zr, last); err != nil {
+				debugLog("Failed to skip output in %s: %v", j.compressedOutFile(), err)
+				return
+			}
+		}
+
+		debugLog("Starting compressed output for %s", j)
+		buf := make([]byte, outputBufSize)
+		for {
+			if j.outputRemoved(outChan, canceled) {
+				return
+			}
+			n, err := zr.Read(buf)
+
+			// n can be positive even in case of an error
+			// Send the read data to out channel
+			if n > 0 {
+				o := &Output{
+					Bytes: make([]byte, n),
+				}
+				copy(o.Bytes, buf)
+
+				if !j.sendOutput(outChan, o, canceled) {
+					return
+				}
+			}
+
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					debugLog("Failed to read from file %s: %v", j.compressedOutFile(), err)
+				}
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// skipToLast skips the decompressed output read by zr from f, so that only the last bytes of the
+// output are left to be read. The size of the decompressed output isn't stored reliably in the
+// compressed file, so the file is decompressed twice.
+func skipToLast(f *os.File, zr *gzip.Reader, last int64) error {
+	size, err := io.Copy(io.Discard, zr)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := zr.Reset(f); err != nil {
+		return err
+	}
+	if size <= last {
+		return nil
+	}
+	_, err = io.CopyN(io.Discard, zr, size-last)
+	return err
+}
+
+// gzipFile writes the gzip compressed contents of src to dst
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createFile(dst, OutputFileMode)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// device is a device node on the host that a job is allowed to access
+type device struct {
+	path  string // absolute path to the device node
+	block bool   // block device if true, character device otherwise
+	major uint32
+	minor uint32
+}
+
+// String returns the device in the format of the cgroup v1 devices.allow file
+func (d device) String() string {
+	typ := "c"
+	if d.block {
+		typ = "b"
+	}
+	return fmt.Sprintf("%s %d:%d", typ, d.major, d.minor)
+}
+
+// lookupDevices returns the devices of the device nodes at paths on the host
+func lookupDevices(paths []string) ([]device, error) {
+	var devices []device
+	for _, path := range paths {
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+			return nil, fmt.Errorf("invalid device path %q", path)
+		}
+
+		var st syscall.Stat_t
+		if err := syscall.Stat(path, &st); err != nil {
+			return nil, fmt.Errorf("failed to stat device %s: %w", path, err)
+		}
+		mode := st.Mode & syscall.S_IFMT
+		if mode != syscall.S_IFCHR && mode != syscall.S_IFBLK {
+			return nil, fmt.Errorf("%s isn't a device", path)
+		}
+
+		devices = append(devices, device{
+			path:  path,
+			block: mode == syscall.S_IFBLK,
+			major: unix.Major(uint64(st.Rdev)),
+			minor: unix.Minor(uint64(st.Rdev)),
+		})
+	}
+	return devices, nil
+}
+
+// deviceMounts returns the bind mounts of the device nodes of devices into the root filesystem of
+// a job
+func deviceMounts(devices []device) []Mount {
+	var mounts []Mount
+	for _, d := range devices {
+		mounts = append(mounts, Mount{Source: d.path, Target: d.path})
+	}
+	return mounts
+}
+
+// eBPF instructions and arguments used by the device filter program of cgroup v2, see
+// include/uapi/linux/bpf.h
+const (
+	bpfLdxMemW  = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	bpfJneImm   = 0x55 // BPF_JMP | BPF_JNE | BPF_K
+	bpfMovImm64 = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	bpfAndImm32 = 0x54 // BPF_ALU | BPF_AND | BPF_K
+	bpfExit     = 0x95 // BPF_JMP | BPF_EXIT
+
+	bpfProgLoad          = 5  // BPF_PROG_LOAD command
+	bpfProgAttach        = 8  // BPF_PROG_ATTACH command
+	bpfProgTypeCgroupDev = 15 // BPF_PROG_TYPE_CGROUP_DEVICE program type
+	bpfCgroupDevice      = 6  // BPF_CGROUP_DEVICE attach type
+	bpfAllowMulti        = 2  // BPF_F_ALLOW_MULTI, other programs may be attached to the cgroup
+	bpfDevcgDevBlock     = 1  // BPF_DEVCG_DEV_BLOCK device type
+	bpfDevcgDevChar      = 2  // BPF_DEVCG_DEV_CHAR device type
+)
+
+// bpfInsn is struct bpf_insn
+type bpfInsn struct {
+	code uint8
+	regs uint8 // destination register in the low nibble, source register in the high nibble
+	off  int16
+	imm  int32
+}
+
+// deviceFilterProgram returns an eBPF program for the BPF_CGROUP_DEVICE hook that allows access to
+// devices and denies access to all the other devices. The program gets a struct
+// bpf_cgroup_dev_ctx with the access type in the upper and the device type in the lower 16 bits
+// of its first field, followed by the major and minor numbers.
+func deviceFilterProgram(devices []device) []bpfInsn {
+	insns := []bpfInsn{
+		{code: bpfLdxMemW, regs: 2 | 1<<4, off: 0}, // r2 = ctx->access_type
+		{code: bpfAndImm32, regs: 2, imm: 0xffff},  // r2 &= 0xffff, the device type
+		{code: bpfLdxMemW, regs: 4 | 1<<4, off: 4}, // r4 = ctx->major
+		{code: bpfLdxMemW, regs: 5 | 1<<4, off: 8}, // r5 = ctx->minor
+	}
+	for _, d := range devices {
+		typ := int32(bpfDevcgDevChar)
+		if d.block {
+			typ = bpfDevcgDevBlock
+		}
+		// jump to the next device unless the type, major and minor all match
+		insns = append(insns,
+			bpfInsn{code: bpfJneImm, regs: 2, off: 4, imm: typ},
+			bpfInsn{code: bpfJneImm, regs: 4, off: 3, imm: int32(d.major)},
+			bpfInsn{code: bpfJneImm, regs: 5, off: 2, imm: int32(d.minor)},
+			bpfInsn{code: bpfMovImm64, regs: 0, imm: 1}, // allow
+			bpfInsn{code: bpfExit},
+		)
+	}
+	return append(insns,
+		bpfInsn{code: bpfMovImm64, regs: 0, imm: 0}, // deny
+		bpfInsn{code: bpfExit},
+	)
+}
+
+// attachDeviceFilter loads the device filter program for devices and attaches it to the cgroup at
+// dir
+func attachDeviceFilter(dir string, devices []device) error {
+	var prog bytes.Buffer
+	for _, insn := range deviceFilterProgram(devices) {
+		_ = binary.Write(&prog, binary.LittleEndian, insn)
+	}
+	insns := prog.Bytes()
+	license := []byte("Apache-2.0\x00")
+
+	// union bpf_attr for BPF_PROG_LOAD, up to expected_attach_type
+	loadAttr := struct {
+		progType           uint32
+		insnCnt            uint32
+		insns              uint64
+		license            uint64
+		logLevel           uint32
+		logSize            uint32
+		logBuf             uint64
+		kernVersion        uint32
+		progFlags          uint32
+		progName           [16]byte
+		progIfindex        uint32
+		expectedAttachType uint32
+	}{
+		progType:           bpfProgTypeCgroupDev,
+		insnCnt:            uint32(len(insns) / 8),
+		insns:              uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		expectedAttachType: bpfCgroupDevice,
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, bpfProgLoad, uintptr(unsafe.Pointer(&loadAttr)),
+		unsafe.Sizeof(loadAttr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return fmt.Errorf("failed to load device filter: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+
+	// union bpf_attr for BPF_PROG_ATTACH. The cgroup keeps the program attached after its fd is
+	// closed, till the cgroup is removed.
+	attachAttr := struct {
+		targetFd     uint32
+		attachBpfFd  uint32
+		attachType   uint32
+		attachFlags  uint32
+		replaceBpfFd uint32
+	}{
+		targetFd:    uint32(cgroup.Fd()),
+		attachBpfFd: uint32(fd),
+		attachType:  bpfCgroupDevice,
+		attachFlags: bpfAllowMulti,
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, bpfProgAttach, uintptr(unsafe.Pointer(&attachAttr)),
+		unsafe.Sizeof(attachAttr))
+	if errno != 0 {
+		return fmt.Errorf("failed to attach device filter to %s: %w", dir, errno)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// namespacesDir is the directory under RunnerHome and ScratchHome holding the namespaces, so that
+// a namespace can't clash with the other files under RunnerHome, like the root filesystem source
+// or volumes
+const namespacesDir = "jobs"
+
+// namespaceDir returns the directory of namespace under home, which is home itself if namespace
+// is empty
+func namespaceDir(home, namespace string) string {
+	if namespace == "" {
+		return home
+	}
+	return filepath.Join(home, namespacesDir, namespace)
+}
+
+// validateNamespace makes sure that namespace can be used as a single path element under
+// namespacesDir
+func validateNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	if namespace == "." || namespace == ".." || filepath.Base(namespace) != namespace {
+		return fmt.Errorf("invalid namespace %q", namespace)
+	}
+	return nil
+}
+
+// DiskUsage returns the number of bytes used by the files of all the jobs in the namespace. The
+// disk usage of the jobs in all the namespaces is returned if namespace is empty. Other files
+// under RunnerHome, like the root filesystem source or volumes, aren't counted.
+func DiskUsage(namespace string) (int64, error) {
+	if err := validateNamespace(namespace); err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err := walkJobDirs(namespaceDir(RunnerHome, namespace), func(dir string) error {
+		n, err := DirSize(dir)
+		size += n
+		return err
+	})
+	return size, err
+}
+
+// DirSize returns the number of bytes used by the regular files under dir, 0 if dir doesn't exist
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		// files of the jobs may be removed while walking the directory
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
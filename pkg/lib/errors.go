@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SetupError is returned by StartJob when the host isn't set up correctly to run jobs
+type SetupError struct {
+	Reason string // what's wrong with the host
+	Hint   string // how to fix the host
+	Err    error  // underlying error
+}
+
+func (e *SetupError) Error() string {
+	return fmt.Sprintf("%s: %v (hint: %s)", e.Reason, e.Err, e.Hint)
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+// diagnoseRootFSError returns a SetupError if err is caused by a missing root filesystem source,
+// err is returned as is otherwise
+func diagnoseRootFSError(err error) error {
+	if _, serr := os.Stat(RootFSSource); RootFSSource == "" || errors.Is(serr, os.ErrNotExist) {
+		return &SetupError{
+			Reason: fmt.Sprintf("root filesystem source %q doesn't exist", RootFSSource),
+			Hint:   "set RootFSSource to an extracted root filesystem, e.g. by running 'make rootfs'",
+			Err:    err,
+		}
+	}
+	return err
+}
+
+// diagnoseStartError returns a SetupError if err returned when starting the process of a job is
+// caused by a common host misconfiguration, err is returned as is otherwise. procRoot is the path
+// where proc filesystem is mounted.
+func diagnoseStartError(err error, procRoot string) error {
+	if _, serr := os.Stat(filepath.Join(procRoot, "self")); serr != nil {
+		return &SetupError{
+			Reason: fmt.Sprintf("proc filesystem isn't mounted at %s", procRoot),
+			Hint:   fmt.Sprintf("mount it with 'mount -t proc proc %s'", procRoot),
+			Err:    err,
+		}
+	}
+
+	if !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+
+	if readProcValue(procRoot, "sys/kernel/unprivileged_userns_clone") == "0" {
+		return &SetupError{
+			Reason: "unprivileged user namespaces are disabled",
+			Hint:   "enable them with 'sysctl -w kernel.unprivileged_userns_clone=1'",
+			Err:    err,
+		}
+	}
+
+	if readProcValue(procRoot, "sys/user/max_user_namespaces") == "0" {
+		return &SetupError{
+			Reason: "user namespaces are disabled",
+			Hint:   "enable them with 'sysctl -w user.max_user_namespaces=15000'",
+			Err:    err,
+		}
+	}
+
+	return err
+}
+
+// readProcValue returns the trimmed contents of the file at name under procRoot, or an empty
+// string if the file can't be read
+func readProcValue(procRoot, name string) string {
+	data, err := os.ReadFile(filepath.Join(procRoot, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
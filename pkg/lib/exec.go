@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/ronakg/runner/pkg/lib/nsenter"
+)
+
+// execNamespaces are the namespaces of a job entered by Exec, in the order they're entered. The
+// user namespace is entered first, it grants the capabilities needed to enter the others.
+var execNamespaces = []string{"user", "pid", "mnt", "net"}
+
+// Exec runs args in the namespaces of the running job and waits for it to finish. The process is
+// put in the cgroup of the job, so it counts towards the resource limits of the job, and it's
+// killed along with the job. stdout and stderr of the process are written to stdout and stderr
+// instead of the output of the job. The process is killed when ctx is canceled.
+func (j *job) Exec(ctx context.Context, args []string, stdout, stderr io.Writer) (exitCode int, err error) {
+	if len(args) == 0 {
+		return 0, ErrNoCommand
+	}
+	if !nsenter.Available {
+		return 0, ErrExecNotSupported
+	}
+	if j.status.Get() != StatusRunning {
+		return 0, ErrNotRunning
+	}
+
+	nsFiles, err := openNamespaces(j.cmd.Process.Pid)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		for _, f := range nsFiles {
+			_ = f.Close()
+		}
+	}()
+	// the process of the job may have exited and its pid may have been reused before the
+	// namespaces were opened
+	if !j.Alive() {
+		return 0, ErrNotRunning
+	}
+
+	// The read end of the sync pipe is inherited by the process as fd 3, followed by the namespaces.
+	// nsenter waits for it before it forks the process that runs the command.
+	syncR, syncW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer syncW.Close()
+
+	fds := make([]string, len(nsFiles))
+	for i := range nsFiles {
+		fds[i] = strconv.Itoa(4 + i)
+	}
+
+	cmd := reexec.Command(append([]string{"execHandler"}, args...)...)
+	cmd.Env = append(os.Environ(), nsenter.EnvFDs+"="+strings.Join(fds, ","), nsenter.EnvSyncFD+"=3")
+	cmd.ExtraFiles = append([]*os.File{syncR}, nsFiles...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// The process and its children are killed as a process group when ctx is canceled
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	debugLog("Executing %q in %s", args, j)
+	err = cmd.Start()
+	_ = syncR.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	if j.cgroup != nil {
+		if err := j.cgroup.AddProcess(cmd.Process.Pid); err != nil {
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			_ = cmd.Wait()
+			return 0, fmt.Errorf("failed to add process to cgroup: %w", err)
+		}
+	}
+	if _, err := syncW.Write([]byte{0}); err != nil {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		_ = cmd.Wait()
+		return 0, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+				debugLog("Failed to kill %q in %s: %v", args, j, err)
+			}
+		case <-done:
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return 0, err
+		}
+	}
+	return cmd.ProcessState.ExitCode(), ctx.Err()
+}
+
+// openNamespaces opens the execNamespaces of the process with pid
+func openNamespaces(pid int) ([]*os.File, error) {
+	var files []*os.File
+	for _, ns := range execNamespaces {
+		f, err := os.Open(fmt.Sprintf("/proc/%d/ns/%s", pid, ns))
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("failed to open %s namespace: %w", ns, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// execHandler runs the argv of Exec. The namespaces of the job are already entered by nsenter by
+// the time it runs, and it runs in a child forked in the PID namespace of the job after the process
+// was moved to the cgroup of the job.
+func execHandler() {
+	argv := os.Args[1:]
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if cmd.ProcessState == nil {
+			// the command couldn't be started, e.g. the executable doesn't exist
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(127)
+		}
+		os.Exit(cmd.ProcessState.ExitCode())
+	}
+}
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxFlushBytes is the maximum number of bytes buffered when the FlushPolicy only specifies
+// an interval
+const maxFlushBytes int = 64 * 1024
+
+// FlushPolicy determines how the output of a job is flushed to the output file. The zero value
+// writes output through to the output file as soon as it's produced. A policy with only Bytes
+// holds output short of Bytes until the job's output is closed, so streams of the output of a quiet
+// job see nothing until then. Set Interval to bound how long output is held.
+type FlushPolicy struct {
+	Bytes    int           // Flush once these many bytes are buffered
+	Interval time.Duration // Flush buffered output at this interval
+	Sync     bool          // fsync the output file after every flush for durability
+}
+
+// hookWriter is an io.Writer that invokes hook with the number of bytes written to w
+type hookWriter struct {
+	w    io.Writer
+	hook func(n int)
+}
+
+// Write writes p to w and invokes the hook
+func (h *hookWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	if n > 0 {
+		h.hook(n)
+	}
+	return n, err
+}
+
+// flushWriter is an io.WriteCloser that writes to a file according to a FlushPolicy
+type flushWriter struct {
+	policy FlushPolicy
+	f      *os.File
+	buf    []byte        // output that's not yet flushed to f
+	limit  int           // flush once len(buf) reaches limit
+	stop   chan struct{} // channel to stop the interval flusher
+	wg     sync.WaitGroup
+	sync.Mutex
+}
+
+// newFlushWriter creates a flushWriter that writes to f according to policy
+func newFlushWriter(f *os.File, policy FlushPolicy) *flushWriter {
+	w := &flushWriter{
+		policy: policy,
+		f:      f,
+		limit:  policy.Bytes,
+		stop:   make(chan struct{}),
+	}
+	if w.limit <= 0 && policy.Interval > 0 {
+		w.limit = maxFlushBytes
+	}
+
+	if policy.Interval > 0 {
+		w.wg.Add(1)
+		go w.flusher()
+	}
+	return w
+}
+
+// Write buffers p and flushes the buffered output if the policy requires it. If the flush fails,
+// the part of p that didn't reach the file is returned as not written, while the output buffered by
+// earlier writes is kept for the next flush.
+func (w *flushWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	buffered := len(w.buf)
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.limit {
+		return len(p), nil
+	}
+
+	n, err := w.flush()
+	if err == nil {
+		return len(p), nil
+	}
+	written := n - buffered
+	if written < 0 {
+		written = 0
+	}
+	w.buf = w.buf[:len(w.buf)-(len(p)-written)]
+	return written, err
+}
+
+// Close flushes any buffered output and closes the file
+func (w *flushWriter) Close() error {
+	close(w.stop)
+	w.wg.Wait()
+
+	w.Lock()
+	defer w.Unlock()
+
+	_, err := w.flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// flush writes the buffered output to the file and returns the number of bytes written. Output
+// that isn't written stays buffered. w must be locked by the caller.
+func (w *flushWriter) flush() (int, error) {
+	if len(w.buf) == 0 {
+		return 0, nil
+	}
+
+	n, err := w.f.Write(w.buf)
+	w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	if err != nil {
+		return n, err
+	}
+
+	if w.policy.Sync {
+		return n, w.f.Sync()
+	}
+	return n, nil
+}
+
+// flusher is a goroutine that flushes the buffered output at the policy interval
+func (w *flushWriter) flusher() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Lock()
+			if _, err := w.flush(); err != nil {
+				debugLog("Failed to flush %s: %v", w.f.Name(), err)
+			}
+			w.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
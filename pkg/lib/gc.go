@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StaleJobDirs returns the directories of jobs under RunnerHome and ScratchHome that don't have a
+// live process, e.g. the directories left behind by a runner that crashed. The directories of jobs
+// started by the current process are stale too once the jobs finish.
+func StaleJobDirs() ([]string, error) {
+	live, err := liveJobDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	homes := []string{RunnerHome}
+	if ScratchHome != "" {
+		homes = append(homes, ScratchHome)
+	}
+	var stale []string
+	for _, home := range homes {
+		err = walkJobDirs(home, func(dir string) error {
+			if !live[dir] {
+				stale = append(stale, dir)
+			}
+			return nil
+		})
+		if err != nil {
+			return stale, err
+		}
+	}
+	return stale, nil
+}
+
+// walkJobDirs calls fn for the directory of every job under root, which is RunnerHome, ScratchHome
+// or the directory of a namespace. Files that aren't part of a job, e.g. the root filesystem source or
+// volumes, are skipped.
+func walkJobDirs(root string, fn func(dir string) error) error {
+	// Files of jobs are stored under <home>/<job_id> or <home>/jobs/<namespace>/<job_id>
+	root = filepath.Clean(root)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		// files of the jobs may be removed while walking root
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == root {
+			return nil
+		}
+
+		if isJobDir(path) {
+			if err := fn(path); err != nil {
+				return err
+			}
+			return fs.SkipDir
+		}
+		parent := filepath.Dir(path)
+		if isHome(parent) && d.Name() == namespacesDir {
+			return nil
+		}
+		if isHome(filepath.Dir(parent)) && filepath.Base(parent) == namespacesDir {
+			// the directory of a namespace, namespaces aren't nested
+			return nil
+		}
+		return fs.SkipDir
+	})
+}
+
+// RemoveStaleJobDirs removes the directories returned by StaleJobDirs and returns the removed
+// directories. The directories are only returned without removing them if dryRun is true.
+func RemoveStaleJobDirs(dryRun bool) ([]string, error) {
+	stale, err := StaleJobDirs()
+	if err != nil || dryRun {
+		return stale, err
+	}
+
+	var removed []string
+	for _, dir := range stale {
+		debugLog("Removing stale job directory %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
+
+// isHome checks whether dir is RunnerHome or ScratchHome
+func isHome(dir string) bool {
+	return dir == filepath.Clean(RunnerHome) || (ScratchHome != "" && dir == filepath.Clean(ScratchHome))
+}
+
+// isJobDir checks whether dir is the directory of a job, i.e. it's named after a job ID and
+// contains the root filesystem or the output of the job
+func isJobDir(dir string) bool {
+	name := filepath.Base(dir)
+	if _, err := hex.DecodeString(name); err != nil || len(name) != jobIDLen {
+		return false
+	}
+	for _, file := range []string{"rootfs", "output.log", "output.log.gz"} {
+		if _, err := os.Lstat(filepath.Join(dir, file)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// liveJobDirs returns the set of directories of jobs whose process is alive. The process of a job
+// is found by its reexec arguments, the root filesystem of the job follows the handler name. The
+// directory of a job under RunnerHome is live too if its root filesystem is under ScratchHome.
+func liveJobDirs() (map[string]bool, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]bool)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// the process may have exited since /proc was read
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil || !processAlive(pid) {
+			continue
+		}
+		args := bytes.Split(cmdline, []byte{0})
+		if len(args) > 1 && string(args[0]) == "reExecHandler" {
+			dir := filepath.Dir(string(args[1]))
+			live[dir] = true
+			if rel, err := filepath.Rel(scratchHome(), dir); err == nil && !strings.HasPrefix(rel, "..") {
+				live[filepath.Join(RunnerHome, rel)] = true
+			}
+		}
+	}
+	return live, nil
+}
@@ -2,15 +2,20 @@
 package lib
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -19,6 +24,7 @@
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/fsnotify/fsnotify"
 	dirCopy "github.com/otiai10/copy"
+	"golang.org/x/sys/unix"
 )
 
 // TODO: These are global exported variables for now. They should be part of some sort library
@@ -26,41 +32,253 @@
 var (
 	RunnerHome   = "/tmp/runner"
 	RootFSSource string // path to the new root file system for jobs
+
+	// ScratchHome is where the root filesystems of jobs are copied to, under
+	// <ScratchHome>/jobs/<namespace>/<job_id>/rootfs. The root filesystems are large and
+	// short-lived, so they can be put on fast scratch storage while the output of jobs stays under
+	// RunnerHome.
+	// The root filesystems are stored under RunnerHome if ScratchHome is empty.
+	ScratchHome string
+
+	OutputFileMode os.FileMode = 0600 // permissions of the output file of a job
+	JobDirMode     os.FileMode = 0700 // permissions of the directory containing a job's files
+	RunnerHomeMode os.FileMode = 0700 // permissions of RunnerHome when it's created
+
+	// CgroupParent is the cgroup under which the cgroups of jobs are created, relative to the root
+	// of the cgroup hierarchy. On systemd hosts it should be under a cgroup delegated to the runner.
+	CgroupParent = "runner"
+
+	// MaxOutputStreams is the maximum number of concurrent output streams of a job, 0 means
+	// unlimited. Output returns ErrTooManyStreams when the limit is reached.
+	MaxOutputStreams int
+
+	// OutputBufferSize is the number of chunks of output buffered by an output stream, so that the
+	// stream keeps reading ahead of a consumer that's momentarily slow. A chunk is at most 1KiB
+	// and the buffer is capped at MaxOutputBufferSize chunks. 0 means unbuffered, every chunk is
+	// handed over to the consumer before the next one is read.
+	OutputBufferSize int
 )
 
-func init() {
+// MaxOutputBufferSize is the maximum number of chunks buffered by an output stream, it caps the
+// memory of the buffer to 1MiB per stream
+const MaxOutputBufferSize = 1024
+
+// outputBuffer returns the number of chunks of output buffered by an output stream
+func outputBuffer() int {
+	switch {
+	case OutputBufferSize < 0:
+		return 0
+	case OutputBufferSize > MaxOutputBufferSize:
+		return MaxOutputBufferSize
+	}
+	return OutputBufferSize
+}
+
+// reexecRegistered is set once RegisterReexec is called, jobs can't be started before that
+var reexecRegistered int32
+
+// RegisterReexec registers the handler that sets up the processes of jobs. Jobs are started by
+// re-executing the current program, so RegisterReexec must be called at the beginning of main of
+// programs using the library, before any other initialization:
+//
+//	func main() {
+//		lib.RegisterReexec()
+//		...
+//	}
+//
+// RegisterReexec doesn't return when the current process is a job being set up.
+func RegisterReexec() {
 	reexec.Register("reExecHandler", reExecHandler)
+	reexec.Register("execHandler", execHandler)
 
 	// if reexec handler is already invoked, then exit to avoid reexec'ing forever
 	if reexec.Init() {
 		os.Exit(0)
 	}
-
-	// setup RunnerHome if it doesn't exist already
-	err := os.MkdirAll(RunnerHome, 0755)
-	if err != nil {
-		log.Fatalf("Failed to initialize library: %v", err)
-	}
+	atomic.StoreInt32(&reexecRegistered, 1)
 }
 
 // ResProfile is the name of the resource profile that should be applied to the job
 type ResProfile string
 
 const (
-	ResProfileDefault ResProfile = "default"
-	outputBufSize     int        = 1024
+	ResProfileDefault ResProfile    = "default"
+	outputBufSize     int           = 1024
+	MinNice           int           = -20         // highest priority nice level of a job
+	MaxNice           int           = 19          // lowest priority nice level of a job
+	MaxRetries        int           = 100         // maximum number of retries of a job
+	MaxPriority       int           = 9           // highest priority of a queued job
+	RetainForever     time.Duration = -1          // OutputRetention that keeps the files of a job
+	maxHostnameLen    int           = 64          // maximum length of the hostname of a job
+	jobIDLen          int           = 24          // length of a job ID in hex characters
+	reconcileInterval time.Duration = time.Second // how often waiter checks if the process is alive
+	outputRereadDelay time.Duration = time.Second // how often output is re-read without file events
+)
+
+// PreExecTimeout is how long the PreExec hook of a job can run before the job fails to set up
+const PreExecTimeout = 30 * time.Second
+
+var (
+	// ErrOutputDiscarded is returned by Output when the output of the job is discarded
+	ErrOutputDiscarded = errors.New("output of the job is discarded")
+
+	// ErrNotRunning is returned by Pause and Exec when the job isn't running
+	ErrNotRunning = errors.New("job isn't running")
+
+	// ErrNoCommand is returned by Exec when there's no command to execute
+	ErrNoCommand = errors.New("no command to execute")
+
+	// ErrNotPaused is returned by Resume when the job isn't paused
+	ErrNotPaused = errors.New("job isn't paused")
+
+	// ErrNotFinished is returned by RemoveFiles and RemoveRootFS when the job hasn't reached a
+	// terminal state
+	ErrNotFinished = errors.New("job hasn't finished")
+
+	// ErrReexecNotRegistered is returned by StartJob when RegisterReexec hasn't been called
+	ErrReexecNotRegistered = errors.New("RegisterReexec must be called before starting jobs")
+
+	// ErrTooManyStreams is returned by Output when the job has MaxOutputStreams output streams
+	ErrTooManyStreams = errors.New("too many output streams for the job")
+
+	// ErrInvalidEnv is returned by StartJob when an environment variable isn't KEY=VALUE
+	ErrInvalidEnv = errors.New("invalid environment variable")
+
+	// ErrExecNotSupported is returned by Exec when the binary is built without cgo, which is
+	// needed to enter the namespaces of the job
+	ErrExecNotSupported = errors.New("exec isn't supported by binaries built without cgo")
+
+	// ErrSetupFailed is returned by StartJob when the job failed to set itself up to run the
+	// command, e.g. when a mount source doesn't exist
+	ErrSetupFailed = errors.New("failed to set up the job")
+
+	// ErrFilesRemoved is returned when the output of a job is read after its files are removed
+	ErrFilesRemoved = errors.New("files of the job are removed")
 )
 
 // Output represents a few bytes of output generated by a job
 type Output struct {
 	Bytes []byte
+
+	// Err is set on the last Output of a stream that ended before all the output was read, e.g.
+	// ErrFilesRemoved when the files of the job are removed while the output is streamed
+	Err error
 }
 
 // JobConfig represents the configuration required to start a job
 type JobConfig struct {
-	Command string        // Command including arguments to run as a job
+	Command string        // Command including arguments to run as a job in a shell
+	Args    []string      // Args is the exact argv to execute without a shell, if Command is empty
 	Timeout time.Duration // Timeout determines how long a job is allowed to run
 	Profile ResProfile    // Profile determines the resource profile that should be applied to a job
+	Flush   FlushPolicy   // Flush determines how the output of a job is flushed to the output file
+	Nice    int           // Nice is the nice level of the job, from -20 (highest priority) to 19
+
+	// CPUSet pins the processes of the job to the CPUs of the set, in the list format of
+	// cpuset(7), e.g. 0-3,6. It overrides the CPUSet of the profile, the job can run on all the
+	// CPUs of the runner if both are empty.
+	CPUSet string
+
+	// Rlimits are the rlimits of the processes of the job. The limits that are set override the
+	// Rlimits of the profile.
+	Rlimits Rlimits
+
+	// Mounts are the host directories bind mounted into the root filesystem of the job
+	Mounts []Mount
+
+	// Devices are the paths to the device nodes on the host the job can access, e.g. /dev/nvidia0.
+	// They're bind mounted into the root filesystem of the job and access to all the other devices
+	// is denied by the cgroup of the job. Access to devices isn't restricted if Devices is empty.
+	Devices []string
+
+	// Env are the environment variables of the job as KEY=VALUE. They're added to the environment
+	// of the runner, a variable of the runner is overridden by a variable with the same key.
+	Env []string
+
+	// Hostname is the hostname of the job in its UTS namespace. The job ID is used if Hostname is
+	// empty.
+	Hostname string
+
+	// Namespace groups the files of jobs under <RunnerHome>/jobs/<Namespace>. Namespace must be a
+	// single path element. Files are stored directly under RunnerHome if Namespace is empty.
+	Namespace string
+
+	// OutputPath is the absolute path of the output file of the job, e.g. a file on a volume that's
+	// tailed by a log shipper. Its directory is created if it doesn't exist. The output file is
+	// stored in the directory of the job if OutputPath is empty. RemoveFiles leaves the output file
+	// at OutputPath in place. It can't be combined with DiscardOutput or CompressOutput, which would
+	// replace the file with OutputPath.gz.
+	OutputPath string
+
+	// AppendOutput appends the output of the job to an existing output file at OutputPath instead
+	// of truncating it, e.g. to keep the output of the jobs run by a restarted server for the same
+	// task in one file. The output streamed from the job starts with the existing content of the
+	// file. It only changes how the output file is opened: the output of a process that outlived a
+	// previous job can't be captured again, as its stdout and stderr pipes are gone with the runner
+	// that started it. It can't be combined with DiscardOutput or CompressOutput.
+	AppendOutput bool
+
+	// DiscardOutput discards the output of the job instead of storing it in the output file
+	DiscardOutput bool
+
+	// CompressOutput compresses the output file once the job reaches a terminal state
+	CompressOutput bool
+
+	// KeepRootFS keeps the root filesystem of the job once it reaches a terminal state, e.g. to
+	// inspect the files the job left behind. It's removed by RemoveRootFS or RemoveFiles.
+	KeepRootFS bool
+
+	// Retries is the number of times the command is run again when it exits with a non-zero exit
+	// code, at most MaxRetries. All the attempts share the job, its root filesystem and its output,
+	// a separator is written to the output before every retry. The timeout covers all the attempts
+	// and the status of the job reflects the last attempt.
+	Retries int
+
+	// RetryBackoff is how long to wait before the first retry, it's doubled after every retry
+	RetryBackoff time.Duration
+
+	// PreExec is a shell command run once before the command, in the namespaces, root filesystem
+	// and cgroup of the job and with its environment, e.g. to seed files the command expects. Its
+	// output is part of the output of the job. The job fails to set up if PreExec exits with a
+	// non-zero exit code or runs for longer than PreExecTimeout, StartJob blocks till it's done.
+	// Only trusted callers should set it, it runs whatever it's set to.
+	PreExec string
+
+	// StartAt schedules the job to start at the given time instead of right away. The job is
+	// returned in StatusScheduled then, and launched once it's due. A job that's stopped before
+	// then is never launched, and one that fails to launch moves to StatusFailed. The timeout of
+	// the job starts once it's launched.
+	StartAt time.Time
+
+	// Queue limits the number of jobs running at the same time. The job is returned in
+	// StatusQueued if all the slots of the queue are taken, and launched once it's handed a slot.
+	// A scheduled job joins the queue once it's due.
+	Queue *Queue
+
+	// Priority orders the jobs waiting for a slot of Queue, from 0 to MaxPriority. Jobs with a
+	// higher priority are launched first.
+	Priority int
+
+	// InactivityTimeout kills the job with StatusInactive once it produces no output for the given
+	// duration, independently of Timeout. It's checked every reconcileInterval, so it fires up to a
+	// second late. The time the job is paused doesn't count. It can't be combined with
+	// DiscardOutput, as output isn't tracked then.
+	InactivityTimeout time.Duration
+
+	// OutputRetention is how long the files of the job, including its output, are kept once it
+	// reaches a terminal state. The library doesn't remove them by itself, OutputRetention is a
+	// hint for whoever removes the files of finished jobs. 0 means their default retention and
+	// RetainForever means the files are kept till they're removed explicitly.
+	OutputRetention time.Duration
+
+	// OnStatusChange is invoked with the job ID and the new status whenever the status of the job
+	// changes. It's invoked synchronously, so it must not block.
+	OnStatusChange func(id string, status JobStatus)
+
+	// OnOutput is invoked with the job ID and the number of bytes whenever the job produces output.
+	// It's invoked synchronously from the output writer, so it must not block. It may be invoked
+	// concurrently for stdout and stderr.
+	OnOutput func(id string, n int)
 }
 
 // Job is the interface that wraps all the functions of a job
@@ -68,44 +286,237 @@ type Job interface {
 	// ID returns the job identifier
 	ID() string
 
-	// Stop stops a running job
+	// Stop stops a running job and waits for it to finish
 	Stop()
 
+	// StopAsync initiates stopping a running job without waiting for it to finish. Use Done or Wait
+	// to wait for the job to finish
+	StopAsync()
+
 	// Status returns the status and exit code of the job
 	Status() (status JobStatus, exitCode int)
 
 	// Output returns an out channel from which the output of a job can be consumed. The cancel
 	// function can be used to stop streaming output from the job. Once cancel function is invoked,
-	// the out channel is closed
+	// the out channel is closed. ErrOutputDiscarded is returned if the job discards its output and
+	// ErrTooManyStreams if the job already has MaxOutputStreams output streams.
 	Output() (out <-chan *Output, cancel func(), err error)
 
+	// OutputLast is like Output, but the output is streamed starting at the last n bytes of the
+	// output produced so far. The whole output is streamed if it's smaller than n bytes.
+	OutputLast(n int64) (out <-chan *Output, cancel func(), err error)
+
 	// Wait waits for the job to finish
 	Wait()
+
+	// Done returns a channel that's closed when the job reaches a terminal state
+	Done() <-chan struct{}
+
+	// OutputComplete checks whether all the output of the job is written to the output file, i.e.
+	// the job and all the processes sharing its stdout and stderr exited and no more output is
+	// coming. A job that's stopped or times out reaches its terminal state before the remaining
+	// output is drained, so the output may be incomplete for a short while after that. It's always
+	// true if the output of the job is discarded.
+	OutputComplete() bool
+
+	// StatusUpdates returns a channel that receives the status of the job whenever it changes.
+	// The channel is closed once the job reaches a terminal state or cancel is invoked. Updates
+	// are dropped if the channel isn't drained, Status always returns the current status.
+	StatusUpdates() (updates <-chan JobStatus, cancel func())
+
+	// Usage returns the resources used by the job. Usage is only available once the job reaches
+	// a terminal state, ok is false otherwise
+	Usage() (usage Usage, ok bool)
+
+	// Alive checks whether the process of the job is actually alive. The process may have died
+	// even though Status still reports StatusRunning, e.g. when it's killed out of band.
+	Alive() bool
+
+	// PID returns the host PID of the process of a running or paused job, e.g. to attach host
+	// tools to it. It's 0 for jobs in any other status, whose process isn't running or is gone.
+	PID() int
+
+	// LeakedPIDs returns the host PIDs of the processes of the job that were still alive once the
+	// process of the job exited, which are killed then. The processes of a job share its PID
+	// namespace, which the kernel tears down with the process of the job, so no process should
+	// ever be leaked, even one that escapes the process group of the job. It's empty till the job
+	// reaches a terminal state.
+	LeakedPIDs() []int
+
+	// Pause freezes all the processes of a running job till Resume is called. ErrNotRunning is
+	// returned if the job isn't running
+	Pause() error
+
+	// Resume resumes a paused job. ErrNotPaused is returned if the job isn't paused
+	Resume() error
+
+	// Signal sends sig, one of Signals, to all the processes of a running job. A paused job acts on
+	// it once it's resumed. ErrInvalidSignal is returned if sig isn't one of Signals and
+	// ErrNotRunning if the job isn't running or paused.
+	Signal(sig syscall.Signal) error
+
+	// LiveUsage returns the current resource usage of a running job. Live usage is only available
+	// for running jobs that have a cgroup, i.e. jobs whose profile has resource limits, ok is
+	// false otherwise
+	LiveUsage() (usage LiveUsage, ok bool)
+
+	// RemoveFiles removes all the files of a finished job, including its output. ErrNotFinished
+	// is returned if the job hasn't reached a terminal state. The active output streams of the job
+	// end with an Output whose Err is ErrFilesRemoved.
+	RemoveFiles() error
+
+	// RemoveRootFS removes the root filesystem of a finished job that's kept with KeepRootFS.
+	// ErrNotFinished is returned if the job hasn't reached a terminal state
+	RemoveRootFS() error
+
+	// Attempts returns the exit codes of the attempts of the job so far, in the order they were
+	// made. A job that's retried on failure makes more than one attempt, the exit code of the
+	// last attempt is only included once the job reaches a terminal state.
+	Attempts() []int
+
+	// Exec runs the exact argv args in the PID, mount and network namespaces of a running job and
+	// returns its exit code once it finishes. Its stdout and stderr are written to stdout and
+	// stderr instead of the output of the job. The process is killed when ctx is canceled.
+	// ErrNotRunning is returned if the job isn't running
+	Exec(ctx context.Context, args []string, stdout, stderr io.Writer) (exitCode int, err error)
 }
 
 // job is the concrete implementation of Job
 type job struct {
 	config           JobConfig
 	id               string
+	dir              string        // Path to the directory containing the job's files
 	outFile          string        // Path to the file where output is stored
 	status           safeJobStatus // Status of the job
 	exitCode         int32         // Exit code of the job
+	lastOutput       int64         // UnixNano of the last output of the job, accessed atomically
 	cmd              *exec.Cmd
 	outputWriterDone chan struct{}  // channel to notify that outputWriter goroutine is done
+	outputWriterOnce sync.Once      // Used to make sure outputWriterDone is closed only once
+	unscheduled      chan struct{}  // closed when a scheduled job is stopped before it's launched
+	launched         chan struct{}  // closed once the job is launched or won't be launched anymore
+	holdsSlot        int32          // 1 if the job holds a slot of its Queue, accessed atomically
+	slotAt           time.Time      // when the job was handed a slot, protected by the Queue lock
+	done             chan struct{}  // channel to notify that the job reached a terminal state
 	stopOnce         sync.Once      // Used to make sure Stop is executed only once
 	wg               sync.WaitGroup // To make sure all goroutines come to stop
 	rootFSPath       string         // path to the root filesystem for the job
+	compressed       bool           // Is the output file compressed?
+	usage            atomic.Value   // Resources used by the job, set once the job completes
+	outputLock       sync.RWMutex   // Protects compressed and the output file while it's compressed
+	removed          chan struct{}  // closed once RemoveFiles is called, ends the output streams
+	removeOnce       sync.Once      // Used to make sure removed is closed only once
+	limits           ResourceLimits // Resource limits of the job's profile
+	cpus             []int          // CPUs the job is pinned to, none if it isn't pinned
+	rlimits          Rlimits        // rlimits of the processes of the job
+	devices          []device       // Devices the job can access, all devices if empty
+	cgroup           cgroupManager  // cgroup of the job, nil if the job doesn't have any limits
+	syncPipe         *os.File       // Write end of the pipe used to release the job once it's set up
+	diagPipe         *os.File       // Read end of the pipe the job reports setup failures to
+	attemptsPipe     *os.File       // Read end of the pipe the job reports retried attempts to
+	attemptsDone     chan struct{}  // closed once all the retried attempts are read
+	attempts         []int          // Exit codes of the attempts of the job
+	pidNS            string         // PID namespace of the job, e.g. pid:[4026532277]
+	leaked           []int          // host PIDs of the processes that outlived the job, set before done is closed
+	attemptsLock     sync.Mutex     // Protects attempts
+	pauseLock        sync.Mutex     // Serializes pausing, resuming and killing the job
+	subscribers      statusSubscribers
+	outputStreams    int32 // Number of output streams that aren't canceled or finished yet
 }
 
 func (j *job) String() string {
 	return fmt.Sprintf("Job[id='%s', command='%s', status='%s']",
-		j.id, j.config.Command, j.status.Get())
+		j.id, j.config.commandString(), j.status.Get())
+}
+
+// commandString returns a printable representation of the command of the job
+func (c *JobConfig) commandString() string {
+	if c.Command != "" {
+		return c.Command
+	}
+	return fmt.Sprintf("%q", c.Args)
 }
 
-// StartJob starts a new job according to supplied JobConfig
+// StartJob starts a new job according to supplied JobConfig. A *SetupError is returned if the job
+// can't be started because the host isn't set up correctly to run jobs.
 func StartJob(config JobConfig) (Job, error) {
-	if config.Command == "" {
-		return nil, errors.New("config.Path is empty")
+	return StartJobContext(context.Background(), config)
+}
+
+// StartJobContext is like StartJob, but the job isn't started if ctx is done before the job is set
+// up to run its command. The error of ctx is returned then and everything set up for the job is
+// cleaned up. ctx has no effect once StartJobContext returns.
+func StartJobContext(ctx context.Context, config JobConfig) (Job, error) {
+	if atomic.LoadInt32(&reexecRegistered) == 0 {
+		return nil, ErrReexecNotRegistered
+	}
+	if config.Command == "" && len(config.Args) == 0 {
+		return nil, errors.New("config.Command and config.Args are empty")
+	}
+	if config.Command != "" && len(config.Args) > 0 {
+		return nil, errors.New("only one of config.Command and config.Args can be set")
+	}
+	if err := validateNamespace(config.Namespace); err != nil {
+		return nil, err
+	}
+	if err := validateMounts(config.Mounts); err != nil {
+		return nil, err
+	}
+	if err := validateHostname(config.Hostname); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(config.Env); err != nil {
+		return nil, err
+	}
+	if err := validateOutputPath(config.OutputPath); err != nil {
+		return nil, err
+	}
+	if config.OutputPath != "" && (config.DiscardOutput || config.CompressOutput) {
+		return nil, errors.New("config.OutputPath can't be combined with config.DiscardOutput or config.CompressOutput")
+	}
+	if config.AppendOutput && config.OutputPath == "" {
+		return nil, errors.New("config.AppendOutput requires config.OutputPath")
+	}
+	if config.Nice < MinNice || config.Nice > MaxNice {
+		return nil, fmt.Errorf("nice level %d is out of range [%d, %d]", config.Nice, MinNice, MaxNice)
+	}
+	if config.Retries < 0 || config.Retries > MaxRetries {
+		return nil, fmt.Errorf("retries %d is out of range [0, %d]", config.Retries, MaxRetries)
+	}
+	if config.Priority < 0 || config.Priority > MaxPriority {
+		return nil, fmt.Errorf("priority %d is out of range [0, %d]", config.Priority, MaxPriority)
+	}
+	if config.RetryBackoff < 0 {
+		return nil, fmt.Errorf("negative retry backoff %s", config.RetryBackoff)
+	}
+	if config.InactivityTimeout < 0 {
+		return nil, fmt.Errorf("negative inactivity timeout %s", config.InactivityTimeout)
+	}
+	if config.InactivityTimeout > 0 && config.DiscardOutput {
+		return nil, errors.New("config.InactivityTimeout can't be combined with config.DiscardOutput")
+	}
+	if config.OutputRetention < 0 && config.OutputRetention != RetainForever {
+		return nil, fmt.Errorf("negative output retention %s", config.OutputRetention)
+	}
+	limits, err := lookupProfile(config.Profile)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := lookupDevices(config.Devices)
+	if err != nil {
+		return nil, err
+	}
+	cpuSet := config.CPUSet
+	if cpuSet == "" {
+		cpuSet = limits.CPUSet
+	}
+	cpus, err := jobCPUs(cpuSet)
+	if err != nil {
+		return nil, err
+	}
+	rlimits := limits.Rlimits.override(config.Rlimits)
+	if err := rlimits.validate(); err != nil {
+		return nil, err
 	}
 
 	id, err := generateJobID()
@@ -116,43 +527,282 @@ func StartJob(config JobConfig) (Job, error) {
 	j := &job{
 		id:               id,
 		config:           config,
-		outFile:          filepath.Join(RunnerHome, id, "output.log"),
+		dir:              filepath.Join(namespaceDir(RunnerHome, config.Namespace), id),
+		outFile:          config.OutputPath,
 		status:           safeJobStatus{value: StatusCreated},
 		exitCode:         -1,
 		outputWriterDone: make(chan struct{}),
-		rootFSPath:       filepath.Join(RunnerHome, id, "rootfs"),
+		attemptsDone:     make(chan struct{}),
+		unscheduled:      make(chan struct{}),
+		launched:         make(chan struct{}),
+		removed:          make(chan struct{}),
+		done:             make(chan struct{}),
+		rootFSPath:       filepath.Join(namespaceDir(scratchHome(), config.Namespace), id, "rootfs"),
+		limits:           limits,
+		devices:          devices,
+		cpus:             cpus,
+		rlimits:          rlimits,
+	}
+	if j.outFile == "" {
+		j.outFile = filepath.Join(j.dir, "output.log")
 	}
 	debugLog("%s created", j)
 
+	// Set up RunnerHome and ScratchHome if they don't exist already
+	err = ValidateRunnerHome()
+	if err != nil {
+		debugLog("Failed to set up %s: %v", RunnerHome, err)
+		return nil, err
+	}
+	err = ValidateScratchHome()
+	if err != nil {
+		debugLog("Failed to set up %s: %v", ScratchHome, err)
+		return nil, err
+	}
+
+	// Set up the directory for the job's files
+	// <RunnerHome>/jobs/<namespace>/<job_id>
+	err = j.createJobDir()
+	if err != nil {
+		debugLog("Failed to create directory for %s: %v", j, err)
+		return nil, err
+	}
+
+	if time.Until(config.StartAt) > 0 {
+		return j.schedule(StatusScheduled, nil)
+	}
+	if config.Queue != nil {
+		ready, err := config.Queue.acquire(j)
+		if err != nil {
+			j.removeJobDir()
+			return nil, err
+		}
+		if ready != nil {
+			return j.schedule(StatusQueued, ready)
+		}
+	}
+
+	// No one learns the ID of a job that fails to start, so its files are removed
+	if err := j.start(ctx); err != nil {
+		j.dequeue()
+		j.removeJobDir()
+		return nil, err
+	}
+	track(j)
+	close(j.launched)
+	return j, nil
+}
+
+// start sets up the job and starts running its command. Everything set up for the job, except for
+// its directory, is cleaned up when it fails to start.
+func (j *job) start(ctx context.Context) error {
 	// Set up root filesystem for the job
-	// <RunnerHome>/<job_id>/rootfs
-	err = j.createRootFSTree()
+	// <ScratchHome>/jobs/<namespace>/<job_id>/rootfs
+	err := j.createRootFSTree()
 	if err != nil {
 		debugLog("Failed to create root filesystem for %s: %v", j, err)
-		return nil, err
+		j.undoSetup()
+		return diagnoseRootFSError(err)
+	}
+
+	// Set up the cgroup for the job according to its resource profile
+	err = j.createCgroup()
+	if err != nil {
+		debugLog("Failed to create cgroup for %s: %v", j, err)
+		j.undoSetup()
+		return err
 	}
 
-	j.setupReExecCommand()
+	if err := j.setupReExecCommand(); err != nil {
+		j.undoSetup()
+		return err
+	}
 
 	if err := j.startOutputWriter(); err != nil {
-		return nil, err
+		j.closePipes()
+		j.undoSetup()
+		return err
 	}
 
 	debugLog("Starting %s", j)
 	err = j.cmd.Start()
+
+	// The job's ends of the pipes are owned by the job now
+	for _, f := range j.cmd.ExtraFiles {
+		if cerr := f.Close(); cerr != nil {
+			debugLog("Failed to close %s for %s: %v", f.Name(), j, cerr)
+		}
+	}
 	if err != nil {
 		debugLog("Failed to start %s: %v", j, err)
-		return nil, err
+		_ = j.syncPipe.Close()
+		_ = j.diagPipe.Close()
+		_ = j.attemptsPipe.Close()
+		// Start closes the stdout and stderr pipes when it fails, which stops outputWriter
+		j.wg.Wait()
+		j.undoSetup()
+		return diagnoseStartError(err, "/proc")
+	}
+
+	// The process of the job is PID 1 of its PID namespace, the namespace identifies the processes
+	// of the job once it exits
+	if j.pidNS, err = os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", j.cmd.Process.Pid)); err != nil {
+		debugLog("Failed to read PID namespace of %s: %v", j, err)
+	}
+
+	// Start attemptsReader
+	j.wg.Add(1)
+	go j.attemptsReader()
+
+	// The job is blocked on the sync pipe till it's moved to its cgroup, so that the user's command
+	// and all its child processes are subject to the resource limits
+	err = j.release(ctx)
+
+	// Wait till the job is set up and starts running the command, or fails to set up. The job may
+	// fail to set up before it's released, so the diagnostics explain release failures as well.
+	diag := j.readDiagnostics()
+	if len(diag) > 0 && (err == nil || errors.Is(err, syscall.EPIPE)) {
+		err = fmt.Errorf("%w: %s", ErrSetupFailed, diag)
+	}
+	if err != nil {
+		debugLog("Failed to set up %s: %v", j, err)
+		j.abort()
+		j.status.Set(StatusFailed)
+		j.statusChanged(StatusFailed)
+		return err
 	}
 	j.status.Set(StatusRunning)
+	j.statusChanged(StatusRunning)
+
+	// The inactivity timeout starts once the job is running
+	atomic.StoreInt64(&j.lastOutput, time.Now().UnixNano())
 
 	// Start waiter
 	j.wg.Add(1)
 	go j.waiter()
 
+	// Start timer
+	if j.config.Timeout > 0 {
+		j.wg.Add(1)
+		go j.timer()
+	}
+
+	return nil
+}
+
+// schedule creates the output file of a job that isn't launched right away and returns the job in
+// status, which is StatusScheduled for a job that starts at StartAt or StatusQueued for a job that
+// waits for ready to be closed. The job is launched by scheduler.
+func (j *job) schedule(status JobStatus, ready <-chan struct{}) (Job, error) {
+	if !j.config.DiscardOutput {
+		// The output of a job can be streamed before it's launched
+		f, err := j.openOutputFile()
+		if err != nil {
+			debugLog("Failed to create output file for %s: %v", j, err)
+			j.dequeue()
+			j.removeJobDir()
+			return nil, err
+		}
+		_ = f.Close()
+	}
+
+	debugLog("Scheduling %s", j)
+	j.status.Set(status)
+	j.statusChanged(status)
+	track(j)
+	go j.scheduler(ready)
 	return j, nil
 }
 
+// scheduler is a goroutine that launches a job once it's due and, if it's started with a Queue,
+// once it's handed a slot. A job that's stopped before then is never launched.
+func (j *job) scheduler(ready <-chan struct{}) {
+	defer close(j.launched)
+
+	if ready == nil {
+		t := time.NewTimer(time.Until(j.config.StartAt))
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-j.unscheduled:
+			return
+		}
+		if ready = j.enqueue(); ready == nil && j.status.Get() != StatusScheduled {
+			return
+		}
+	}
+	if ready != nil {
+		select {
+		case <-ready:
+		case <-j.unscheduled:
+			return
+		}
+	}
+
+	// Stopping the job waits till it's launched, so that a launched job is always killed
+	j.pauseLock.Lock()
+	defer j.pauseLock.Unlock()
+	if !j.status.UpdateIf(StatusScheduled, StatusCreated) && !j.status.UpdateIf(StatusQueued, StatusCreated) {
+		return
+	}
+
+	debugLog("Launching %s", j)
+	if err := j.start(context.Background()); err != nil {
+		debugLog("Failed to launch %s: %v", j, err)
+		if j.status.UpdateIf(StatusCreated, StatusFailed) {
+			j.statusChanged(StatusFailed)
+		}
+		j.endUnlaunched()
+	}
+}
+
+// enqueue asks the Queue of a scheduled job that's due for a slot and returns the channel that's
+// closed once the job is handed one, if it's queued. A job that can't be queued fails.
+func (j *job) enqueue() <-chan struct{} {
+	if j.config.Queue == nil {
+		return nil
+	}
+
+	j.pauseLock.Lock()
+	defer j.pauseLock.Unlock()
+	if j.status.Get() != StatusScheduled {
+		return nil
+	}
+
+	ready, err := j.config.Queue.acquire(j)
+	switch {
+	case err != nil:
+		debugLog("Failed to queue %s: %v", j, err)
+		j.status.Set(StatusFailed)
+		j.statusChanged(StatusFailed)
+		j.endUnlaunched()
+	case ready != nil:
+		j.status.Set(StatusQueued)
+		j.statusChanged(StatusQueued)
+	}
+	return ready
+}
+
+// dequeue removes a job from its Queue and frees its slot, if it holds one
+func (j *job) dequeue() {
+	if j.config.Queue == nil {
+		return
+	}
+	j.config.Queue.remove(j)
+	if atomic.CompareAndSwapInt32(&j.holdsSlot, 1, 0) {
+		j.config.Queue.release(j)
+	}
+}
+
+// endUnlaunched marks a job that reached a terminal state without running its command as done
+func (j *job) endUnlaunched() {
+	j.dequeue()
+	j.closeOutputWriterDone()
+	j.subscribers.close()
+	close(j.done)
+}
+
 // ID returns the job identifier
 func (j *job) ID() string {
 	return j.id
@@ -162,23 +812,116 @@ func (j *job) ID() string {
 func (j *job) kill(status JobStatus) {
 	// Make sure that we only kill the process once
 	j.stopOnce.Do(func() {
-		if j.status.Get() == StatusRunning {
+		j.pauseLock.Lock()
+		defer j.pauseLock.Unlock()
+
+		current := j.status.Get()
+		if current == StatusScheduled || current == StatusQueued {
+			// The job is never launched
+			j.status.Set(status)
+			j.statusChanged(status)
+			close(j.unscheduled)
+			j.endUnlaunched()
+			return
+		}
+		if current == StatusRunning || current == StatusPaused {
 			// Just cancelling the context doesn't stop all child processes
 			// Passing a negative PID to the syscall sends a SIGKILL signal to all the child processes
 			err := syscall.Kill(-j.cmd.Process.Pid, syscall.SIGKILL)
 			if err != nil {
 				debugLog("Failed to stop the job: %v", err)
 			}
+
+			// Frozen processes only handle the SIGKILL once they're thawed
+			if current == StatusPaused {
+				if err := j.freeze(false); err != nil {
+					debugLog("Failed to thaw %s: %v", j, err)
+				}
+			}
 			j.status.Set(status)
+			j.statusChanged(status)
 		}
 	})
 }
 
+// statusChanged publishes the status change to the subscribers and invokes the status change hook
+// of the job, if any
+func (j *job) statusChanged(status JobStatus) {
+	j.subscribers.publish(status)
+	if j.config.OnStatusChange != nil {
+		j.config.OnStatusChange(j.id, status)
+	}
+}
+
 // Stop stops the job and waits for all the goroutines to finish processing
 func (j *job) Stop() {
+	j.StopAsync()
+	j.Wait()
+}
+
+// StopAsync initiates stopping the job without waiting for the goroutines to finish processing
+func (j *job) StopAsync() {
 	debugLog("Stopping %s", j)
 	j.kill(StatusStopped)
-	j.wg.Wait()
+}
+
+// Pause freezes all the processes of the job
+func (j *job) Pause() error {
+	j.pauseLock.Lock()
+	defer j.pauseLock.Unlock()
+
+	if j.status.Get() != StatusRunning {
+		return ErrNotRunning
+	}
+
+	debugLog("Pausing %s", j)
+	if err := j.freeze(true); err != nil {
+		return fmt.Errorf("failed to pause the job: %w", err)
+	}
+
+	if !j.status.UpdateIf(StatusRunning, StatusPaused) {
+		// the job reached a terminal state while it was being frozen
+		if err := j.freeze(false); err != nil {
+			debugLog("Failed to thaw %s: %v", j, err)
+		}
+		return ErrNotRunning
+	}
+	j.statusChanged(StatusPaused)
+	return nil
+}
+
+// Resume thaws all the processes of the paused job
+func (j *job) Resume() error {
+	j.pauseLock.Lock()
+	defer j.pauseLock.Unlock()
+
+	if j.status.Get() != StatusPaused {
+		return ErrNotPaused
+	}
+
+	debugLog("Resuming %s", j)
+	if err := j.freeze(false); err != nil {
+		return fmt.Errorf("failed to resume the job: %w", err)
+	}
+
+	if j.status.UpdateIf(StatusPaused, StatusRunning) {
+		j.statusChanged(StatusRunning)
+	}
+	return nil
+}
+
+// freeze freezes or thaws the processes of the job using the cgroup freezer. Jobs without a
+// cgroup are stopped and continued with SIGSTOP and SIGCONT instead.
+func (j *job) freeze(frozen bool) error {
+	if j.cgroup != nil {
+		return j.cgroup.Freeze(frozen)
+	}
+
+	sig := syscall.SIGCONT
+	if frozen {
+		sig = syscall.SIGSTOP
+	}
+	return syscall.Kill(-j.cmd.Process.Pid, sig)
 }
 
 // Status returns the status of the job and the exit code.
@@ -191,6 +934,30 @@ func (j *job) Status() (status JobStatus, exitCode int) {
 // function can be used to stop streaming output from the job. Once cancel function is invoked,
 // the out channel is closed.
 func (j *job) Output() (out <-chan *Output, cancel func(), err error) {
+	return j.output(-1)
+}
+
+// OutputLast returns an out channel from which the output of a job can be consumed starting at the
+// last n bytes of the output produced so far
+func (j *job) OutputLast(n int64) (out <-chan *Output, cancel func(), err error) {
+	if n < 0 {
+		return nil, nil, fmt.Errorf("negative number of bytes %d", n)
+	}
+	return j.output(n)
+}
+
+// output streams the output of the job. Only the last bytes of the output produced so far are
+// streamed if last isn't negative.
+func (j *job) output(last int64) (out <-chan *Output, cancel func(), err error) {
+	if j.config.DiscardOutput {
+		return nil, nil, ErrOutputDiscarded
+	}
+
+	if n := atomic.AddInt32(&j.outputStreams, 1); MaxOutputStreams > 0 && int(n) > MaxOutputStreams {
+		atomic.AddInt32(&j.outputStreams, -1)
+		return nil, nil, ErrTooManyStreams
+	}
+
 	// cancelOnce is used to make sure that cancel() is executed only once
 	cancelOnce := sync.Once{}
 
@@ -201,22 +968,53 @@ func (j *job) Output() (out <-chan *Output, cancel func(), err error) {
 	cancel = func() {
 		cancelOnce.Do(func() {
 			close(canceled)
+			atomic.AddInt32(&j.outputStreams, -1)
 		})
 	}
 
 	// outChan is the output channel that's returned to the caller
-	outChan := make(chan *Output)
+	outChan := make(chan *Output, outputBuffer())
+
+	// Make sure that the output file isn't removed while it's being opened
+	j.outputLock.RLock()
+	defer j.outputLock.RUnlock()
+
+	select {
+	case <-j.removed:
+		cancel()
+		return nil, nil, ErrFilesRemoved
+	default:
+	}
+
+	if j.compressed {
+		if err := j.compressedOutput(outChan, canceled, cancel, last); err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		return outChan, cancel, nil
+	}
 
 	// Set up a file watcher to monitor changes to j.outFile
 	watcher, err := j.outputWatcher()
 	if err != nil {
+		cancel()
 		return nil, nil, err
 	}
 
 	f, err := os.Open(j.outFile)
 	if err != nil {
+		cancel()
+		_ = watcher.Close()
 		return nil, nil, err
 	}
+	if last >= 0 {
+		if err := seekToLast(f, last); err != nil {
+			cancel()
+			_ = watcher.Close()
+			_ = f.Close()
+			return nil, nil, err
+		}
+	}
 
 	// goroutine to read the j.outFile and send data to the out channel
 	go func() {
@@ -237,7 +1035,15 @@ func (j *job) Output() (out <-chan *Output, cancel func(), err error) {
 		debugLog("Starting output for %s", j)
 		readOnceMore := true
 		buf := make([]byte, outputBufSize)
+
+		// Re-read the file periodically in case a watcher event is missed, e.g. for the first write
+		// to the file that raced with the creation of the watcher
+		ticker := time.NewTicker(outputRereadDelay)
+		defer ticker.Stop()
 		for {
+			if j.outputRemoved(outChan, canceled) {
+				return
+			}
 			n, err := f.Read(buf)
 
 			// n can be positive even in case of an error
@@ -247,7 +1053,9 @@ func (j *job) Output() (out <-chan *Output, cancel func(), err error) {
 					Bytes: make([]byte, n),
 				}
 				copy(o.Bytes, buf)
-				outChan <- o
+				if !j.sendOutput(outChan, o, canceled) {
+					return
+				}
 			}
 
 			if err != nil {
@@ -272,10 +1080,14 @@ func (j *job) Output() (out <-chan *Output, cancel func(), err error) {
 					debugLog("shutting down, error: %v", err)
 					return
 				}
+			case <-ticker.C:
 			case <-canceled:
 				// output streaming canceled by the caller
 				debugLog("Stopping output streaming for %s", j)
 				return
+			case <-j.removed:
+				j.outputRemoved(outChan, canceled)
+				return
 			case <-j.outputWriterDone:
 				// sometimes this event is received before we get the watcher notification. Read the
 				// outFile one more time to make sure we read everything.
@@ -291,73 +1103,401 @@ func (j *job) Output() (out <-chan *Output, cancel func(), err error) {
 	return outChan, cancel, nil
 }
 
+// sendOutput sends o to outChan. It returns false if the output streaming has to stop instead,
+// because it's canceled by the caller or the files of the job are removed.
+func (j *job) sendOutput(outChan chan<- *Output, o *Output, canceled <-chan struct{}) bool {
+	select {
+	case outChan <- o:
+		return true
+	case <-canceled:
+		// output streaming canceled by the caller
+		debugLog("Stopping output streaming for %s", j)
+		return false
+	case <-j.removed:
+		j.outputRemoved(outChan, canceled)
+		return false
+	}
+}
+
+// outputRemoved returns true if the files of the job are removed, after sending ErrFilesRemoved as
+// the last output to outChan
+func (j *job) outputRemoved(outChan chan<- *Output, canceled <-chan struct{}) bool {
+	select {
+	case <-j.removed:
+	default:
+		return false
+	}
+
+	debugLog("Files of %s are removed, stopping output streaming", j)
+	select {
+	case outChan <- &Output{Err: ErrFilesRemoved}:
+	case <-canceled:
+	}
+	return true
+}
+
 // Wait waits for the job to complete
 func (j *job) Wait() {
+	// The goroutines of a scheduled job are only started once it's launched
+	<-j.launched
 	j.wg.Wait()
 }
 
-// waiter is a goroutine that waits for the job to complete and perform cleanup for the job
-func (j *job) waiter() {
-	defer j.wg.Done()
+// Done returns a channel that's closed when the job reaches a terminal state
+func (j *job) Done() <-chan struct{} {
+	return j.done
+}
 
-	debugLog("Starting waiter for %s", j)
+// OutputComplete checks whether the output writer finished writing the output of the job
+func (j *job) OutputComplete() bool {
+	select {
+	case <-j.outputWriterDone:
+		return true
+	default:
+		return false
+	}
+}
 
-	if j.config.Timeout > 0 {
-		select {
-		case <-time.After(j.config.Timeout):
-			// kill the job if the timeout expired
-			j.kill(StatusTimedOut)
-		case <-j.outputWriterDone:
-			// outputWriter finished, which means that the job ran to its completion
-		}
+// StatusUpdates returns a channel that receives the status of the job whenever it changes
+func (j *job) StatusUpdates() (updates <-chan JobStatus, cancel func()) {
+	return j.subscribers.subscribe()
+}
+
+// Usage returns the resources used by the job. Usage is only available once the job reaches
+// a terminal state, ok is false otherwise.
+func (j *job) Usage() (usage Usage, ok bool) {
+	usage, ok = j.usage.Load().(Usage)
+	return
+}
+
+// Alive checks whether the process of the job is actually alive
+func (j *job) Alive() bool {
+	if status := j.status.Get(); status != StatusRunning && status != StatusPaused {
+		return false
 	}
-	// If the job was stopped due to timeout expiration, we still need to make sure that
-	// outputWriter finished writing output to j.outFile
-	<-j.outputWriterDone
+	return processAlive(j.cmd.Process.Pid)
+}
 
-	// Wait for exec.Cmd to handle process completion
-	err := j.cmd.Wait()
-	if err != nil {
-		debugLog("%s completed with error: %v, code: %d", j, err, j.cmd.ProcessState.ExitCode())
-	} else {
-		debugLog("%s completed successfully", j)
+// PID returns the host PID of the process of the job while it's running or paused
+func (j *job) PID() int {
+	if status := j.status.Get(); status != StatusRunning && status != StatusPaused {
+		return 0
 	}
+	return j.cmd.Process.Pid
+}
 
-	j.status.UpdateIf(StatusRunning, StatusCompleted)
-	atomic.StoreInt32(&j.exitCode, int32(j.cmd.ProcessState.ExitCode()))
+// LiveUsage returns the current resource usage of the job from its cgroup
+func (j *job) LiveUsage() (usage LiveUsage, ok bool) {
+	if status := j.status.Get(); j.cgroup == nil || (status != StatusRunning && status != StatusPaused) {
+		return LiveUsage{}, false
+	}
 
-	// Clean up the root fs tree created for the job
-	err = j.deleteRootFSTree()
+	usage, err := j.cgroup.Stats()
 	if err != nil {
-		debugLog("Failed to delete root filesystem for %s: %v", j, err)
+		// the cgroup may have been removed if the job just completed
+		debugLog("Failed to get live usage of %s: %v", j, err)
+		return LiveUsage{}, false
 	}
+	return usage, true
 }
 
-// outputWriter reads data from the mr and writes the same to f
-func (j *job) outputWriter(mr io.Reader, f *os.File) {
-	defer j.wg.Done()
-
-	// Close outputWriterDone to signal completion of outputWriterDone
-	defer close(j.outputWriterDone)
-	defer func() {
-		if err := f.Close(); err != nil {
-			debugLog("Failed to close %s: %v", j.outFile, err)
-		}
-	}()
+// RemoveFiles removes all the files of a finished job, including its output
+func (j *job) RemoveFiles() error {
+	select {
+	case <-j.done:
+	default:
+		return ErrNotFinished
+	}
 
-	debugLog("Starting outputWriter for %s", j)
+	// The output streams end instead of reading the removed output file
+	j.outputLock.Lock()
+	defer j.outputLock.Unlock()
+	j.removeOnce.Do(func() {
+		close(j.removed)
+	})
 
-	_, err := io.Copy(f, mr)
-	if err != nil {
-		if !errors.Is(err, io.EOF) {
-			debugLog("Failed to read stdout or stderr: %v", err)
+	debugLog("Removing files of %s", j)
+	untrack(j)
+	if j.scratchDir() != j.dir {
+		// The root filesystem is only left behind if it's kept for debugging
+		if err := j.deleteRootFSTree(); err != nil {
+			return err
 		}
 	}
+	return os.RemoveAll(j.dir)
+}
 
-	debugLog("outputWriter done for %s", j)
+// RemoveRootFS removes the root filesystem of a finished job
+func (j *job) RemoveRootFS() error {
+	select {
+	case <-j.done:
+	default:
+		return ErrNotFinished
+	}
+	return j.deleteRootFSTree()
 }
 
-// outputWatcher creates a Watcher for the outFile and returns the same
+// waiter is a goroutine that waits for the job to complete and perform cleanup for the job
+func (j *job) waiter() {
+	defer j.wg.Done()
+
+	// Close done to signal that the job reached a terminal state, its slot is freed after that
+	defer j.dequeue()
+	defer close(j.done)
+	defer j.subscribers.close()
+
+	debugLog("Starting waiter for %s", j)
+
+	// If the job was killed, we still need to make sure that outputWriter finished writing output
+	// to j.outFile. SIGKILL doesn't discard the data that's already written to the stdout/stderr
+	// pipes, outputWriter keeps draining the pipes till EOF. Output readers only observe the end of
+	// output after outputWriterDone is closed, so they're guaranteed to see everything the job
+	// produced before it was killed.
+	j.waitOutputWriter()
+
+	// Wait for exec.Cmd to handle process completion
+	err := j.cmd.Wait()
+	if err != nil {
+		debugLog("%s completed with error: %v, code: %d", j, err, j.cmd.ProcessState.ExitCode())
+	} else {
+		debugLog("%s completed successfully", j)
+	}
+	j.killLeaked()
+
+	// The cgroup is only removed once its usage is read
+	j.usage.Store(j.finalUsage())
+	j.removeCgroup()
+	atomic.StoreInt32(&j.exitCode, int32(j.cmd.ProcessState.ExitCode()))
+
+	// The attempts pipe is closed once the process exits, the last attempt is the exit code of the
+	// process
+	<-j.attemptsDone
+	j.attemptsLock.Lock()
+	j.attempts = append(j.attempts, j.cmd.ProcessState.ExitCode())
+	j.attemptsLock.Unlock()
+
+	// The process of the job can only be terminated by a signal if it's killed out of band, the
+	// exit status of the user's command is propagated as the exit code otherwise
+	final := StatusCompleted
+	if ws, ok := j.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		debugLog("%s was killed by signal %s", j, ws.Signal())
+		final = StatusKilled
+	}
+	if j.status.UpdateIf(StatusRunning, final) || j.status.UpdateIf(StatusPaused, final) {
+		j.statusChanged(final)
+	}
+
+	if j.config.CompressOutput && !j.config.DiscardOutput {
+		if err := j.compressOutput(); err != nil {
+			debugLog("Failed to compress output for %s: %v", j, err)
+		}
+	}
+
+	// Clean up the root fs tree created for the job, unless it's kept for debugging
+	if j.config.KeepRootFS {
+		debugLog("Keeping root filesystem tree %s for %s", j.rootFSPath, j)
+		return
+	}
+	err = j.deleteRootFSTree()
+	if err != nil {
+		debugLog("Failed to delete root filesystem for %s: %v", j, err)
+	}
+}
+
+// attemptsReader is a goroutine that records the exit codes of the attempts that are retried, the
+// job reports them on the attempts pipe
+func (j *job) attemptsReader() {
+	defer j.wg.Done()
+	defer close(j.attemptsDone)
+	defer func() {
+		if err := j.attemptsPipe.Close(); err != nil {
+			debugLog("Failed to close attempts pipe for %s: %v", j, err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(j.attemptsPipe)
+	for scanner.Scan() {
+		code, err := strconv.Atoi(scanner.Text())
+		if err != nil {
+			debugLog("Invalid exit code %q of an attempt of %s", scanner.Text(), j)
+			continue
+		}
+		debugLog("Attempt of %s exited with %d, retrying", j, code)
+		j.attemptsLock.Lock()
+		j.attempts = append(j.attempts, code)
+		j.attemptsLock.Unlock()
+	}
+}
+
+// LeakedPIDs returns the host PIDs of the processes that outlived the job
+func (j *job) LeakedPIDs() []int {
+	select {
+	case <-j.done:
+		return append([]int(nil), j.leaked...)
+	default:
+		return nil
+	}
+}
+
+// killLeaked kills the processes left in the PID namespace of the job once its process exits and
+// records them as leaked. The kernel kills all the processes of a PID namespace when its first
+// process exits and reaps them before the exit of the first process is reported, so there's
+// nothing to kill unless that guarantee is broken.
+func (j *job) killLeaked() {
+	if j.pidNS == "" {
+		return
+	}
+	pids, err := namespaceProcesses(j.pidNS)
+	if err != nil {
+		debugLog("Failed to look for processes leaked by %s: %v", j, err)
+		return
+	}
+	if len(pids) == 0 {
+		return
+	}
+
+	debugLog("%s leaked processes %v, killing them", j, pids)
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			debugLog("Failed to kill process %d leaked by %s: %v", pid, j, err)
+		}
+	}
+	j.leaked = pids
+}
+
+// Attempts returns the exit codes of the attempts of the job so far
+func (j *job) Attempts() []int {
+	j.attemptsLock.Lock()
+	defer j.attemptsLock.Unlock()
+
+	return append([]int(nil), j.attempts...)
+}
+
+// waitOutputWriter waits for outputWriter to finish. If the process of the job dies while any
+// processes it spawned keep the stdout/stderr pipes open, those processes are killed so that
+// outputWriter observes EOF and the job can reach a terminal state.
+func (j *job) waitOutputWriter() {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.outputWriterDone:
+			return
+		case <-ticker.C:
+			j.checkInactivity()
+			if !processAlive(j.cmd.Process.Pid) {
+				debugLog("Process of %s died, killing remaining processes", j)
+				err := syscall.Kill(-j.cmd.Process.Pid, syscall.SIGKILL)
+				if err != nil && !errors.Is(err, syscall.ESRCH) {
+					debugLog("Failed to kill remaining processes of %s: %v", j, err)
+				}
+			}
+		}
+	}
+}
+
+// checkInactivity kills the job if it produced no output for its inactivity timeout. The time the
+// job is paused is skipped by moving its last output along.
+func (j *job) checkInactivity() {
+	if j.config.InactivityTimeout <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if j.status.Get() == StatusPaused {
+		atomic.StoreInt64(&j.lastOutput, now.UnixNano())
+		return
+	}
+	if now.Sub(time.Unix(0, atomic.LoadInt64(&j.lastOutput))) >= j.config.InactivityTimeout {
+		debugLog("%s produced no output for %s, killing it", j, j.config.InactivityTimeout)
+		j.kill(StatusInactive)
+	}
+}
+
+// timer is a goroutine that kills the job if it doesn't reach a terminal state before the timeout
+// expires. The killed job is reaped by waiter, timer doesn't wait for the job to exit.
+func (j *job) timer() {
+	defer j.wg.Done()
+
+	t := time.NewTimer(j.config.Timeout)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		// kill the job if the timeout expired
+		j.kill(StatusTimedOut)
+	case <-j.done:
+		// the job reached a terminal state before the timeout expired
+	}
+}
+
+// outputHook records that the job produced n bytes of output and invokes the output hook of the
+// job, if any
+func (j *job) outputHook(n int) {
+	if j.config.InactivityTimeout > 0 {
+		atomic.StoreInt64(&j.lastOutput, time.Now().UnixNano())
+	}
+	if j.config.OnOutput != nil {
+		j.config.OnOutput(j.id, n)
+	}
+}
+
+// outputWriter reads data from stdout and stderr of the job and writes the same to f according to
+// the flush policy
+func (j *job) outputWriter(stdout, stderr io.Reader, f *os.File) {
+	defer j.wg.Done()
+
+	// Close outputWriterDone to signal completion of outputWriterDone
+	defer j.closeOutputWriterDone()
+
+	w := newFlushWriter(f, j.config.Flush)
+	defer func() {
+		if err := w.Close(); err != nil {
+			debugLog("Failed to close %s: %v", j.outFile, err)
+		}
+	}()
+
+	debugLog("Starting outputWriter for %s", j)
+
+	var dst io.Writer = w
+	if j.config.OnOutput != nil || j.config.InactivityTimeout > 0 {
+		dst = &hookWriter{
+			w:    w,
+			hook: j.outputHook,
+		}
+	}
+
+	// Drain stdout and stderr concurrently, so that neither of them is withheld till the other one
+	// is closed. Writes to w are serialized by the flushWriter.
+	var wg sync.WaitGroup
+	for _, r := range []io.Reader{stdout, stderr} {
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+
+			_, err := io.Copy(dst, r)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					debugLog("Failed to read stdout or stderr: %v", err)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	debugLog("outputWriter done for %s", j)
+}
+
+// closeOutputWriterDone closes outputWriterDone. A job that's never launched closes it without
+// starting outputWriter.
+func (j *job) closeOutputWriterDone() {
+	j.outputWriterOnce.Do(func() {
+		close(j.outputWriterDone)
+	})
+}
+
+// outputWatcher creates a Watcher for the outFile and returns the same
 func (j *job) outputWatcher() (*fsnotify.Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -374,16 +1514,69 @@ func (j *job) outputWatcher() (*fsnotify.Watcher, error) {
 
 // generateJobID generates a 12 byte long random ID
 func generateJobID() (string, error) {
-	b := make([]byte, 12)
+	b := make([]byte, jobIDLen/2)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(b), nil
 }
 
-func (j *job) setupReExecCommand() {
-	// reexec self to setup root filesystem and cgroups
-	j.cmd = reexec.Command("reExecHandler", j.rootFSPath, string(j.config.Profile), j.config.Command)
+func (j *job) setupReExecCommand() error {
+	// The device nodes the job can access are mounted after the host directories
+	mounts, err := json.Marshal(append(append([]Mount{}, j.config.Mounts...), deviceMounts(j.devices)...))
+	if err != nil {
+		return err
+	}
+
+	// The environment of the job is only set on the command, the handler runs with the environment
+	// of the server so that variables like LD_PRELOAD can't affect it before the root fs is set up
+	opts, err := json.Marshal(handlerOptions{
+		Env:          j.config.Env,
+		Retries:      j.config.Retries,
+		RetryBackoff: j.config.RetryBackoff,
+		CPUs:         j.cpus,
+		PreExec:      j.config.PreExec,
+		Rlimits:      j.rlimits,
+	})
+	if err != nil {
+		return err
+	}
+
+	// reexec self to setup root filesystem
+	// The command is empty when the job executes the exact argv that follows it
+	args := []string{"reExecHandler", j.rootFSPath, string(mounts), string(opts), string(j.config.Profile),
+		j.hostname(), j.config.Command}
+	args = append(args, j.config.Args...)
+	j.cmd = reexec.Command(args...)
+
+	// The read end of the sync pipe is inherited by the job as fd 3
+	syncR, syncW, err := os.Pipe()
+	if err != nil {
+		debugLog("Failed to create sync pipe: %v", err)
+		return err
+	}
+
+	// The write end of the diagnostics pipe is inherited by the job as fd 4
+	diagR, diagW, err := os.Pipe()
+	if err != nil {
+		debugLog("Failed to create diagnostics pipe: %v", err)
+		_ = syncR.Close()
+		_ = syncW.Close()
+		return err
+	}
+	// The write end of the attempts pipe is inherited by the job as fd 5
+	attemptsR, attemptsW, err := os.Pipe()
+	if err != nil {
+		debugLog("Failed to create attempts pipe: %v", err)
+		for _, f := range []*os.File{syncR, syncW, diagR, diagW} {
+			_ = f.Close()
+		}
+		return err
+	}
+	j.cmd.ExtraFiles = []*os.File{syncR, diagW, attemptsW}
+	j.syncPipe = syncW
+	j.diagPipe = diagR
+	j.attemptsPipe = attemptsR
 
 	// Make sure that child processes spawned from the Job belong to same process group
 	// This is to make sure that we can stop all the child processes as well in Stop()
@@ -414,63 +1607,510 @@ func (j *job) setupReExecCommand() {
 			Gid: 0,
 		},
 	}
+	return nil
+}
+
+// release moves the process of the job to its cgroup and unblocks the job by writing to the sync
+// pipe. The job exits without running the command if the sync pipe is closed without a write,
+// which is how the job is canceled when ctx is done.
+func (j *job) release(ctx context.Context) error {
+	defer func() {
+		if err := j.syncPipe.Close(); err != nil {
+			debugLog("Failed to close sync pipe for %s: %v", j, err)
+		}
+	}()
+
+	if j.cgroup != nil {
+		if err := j.cgroup.AddProcess(j.cmd.Process.Pid); err != nil {
+			return fmt.Errorf("failed to add job to cgroup: %w", err)
+		}
+	}
+
+	// The nice level is set here rather than by the job itself, because the job's user namespace
+	// doesn't allow it to lower its nice level
+	if j.config.Nice != 0 {
+		if err := setNice(j.cmd.Process.Pid, j.config.Nice); err != nil {
+			return fmt.Errorf("failed to set nice level of the job: %w", err)
+		}
+	}
+
+	// The start request may have been canceled while the job was set up
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := j.syncPipe.Write([]byte{0})
+	return err
+}
+
+// readDiagnostics returns the setup failure reported by the job, if any. Setup failures are
+// reported on a pipe of their own, so that they don't end up in the output of the job. The job
+// closes the pipe once it's set up and starts running the command, or exits after reporting the
+// failure, so readDiagnostics returns as soon as the outcome of the setup is known.
+func (j *job) readDiagnostics() string {
+	defer func() {
+		if err := j.diagPipe.Close(); err != nil {
+			debugLog("Failed to close diagnostics pipe for %s: %v", j, err)
+		}
+	}()
+
+	diag, err := io.ReadAll(j.diagPipe)
+	if err != nil {
+		debugLog("Failed to read diagnostics of %s: %v", j, err)
+	}
+	return string(bytes.TrimSpace(diag))
+}
+
+// closePipes closes both ends of the sync, diagnostics and attempts pipes of a job that isn't
+// started
+func (j *job) closePipes() {
+	for _, f := range append(j.cmd.ExtraFiles, j.syncPipe, j.diagPipe, j.attemptsPipe) {
+		_ = f.Close()
+	}
+}
+
+// abort reaps a job that failed to be released and cleans up after it
+func (j *job) abort() {
+	// The job exits on its own once the sync pipe is closed, make sure of it anyway
+	if err := syscall.Kill(-j.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		debugLog("Failed to kill %s: %v", j, err)
+	}
+	j.wg.Wait()
+	_ = j.cmd.Wait()
+	j.undoSetup()
+}
+
+// createCgroup creates the cgroup of the job and applies the resource limits of the job's profile.
+// Jobs without any resource limits aren't put in a cgroup of their own.
+func (j *job) createCgroup() error {
+	// The job is pinned to its CPU set and its rlimits are applied by its handler, not by the cgroup
+	limits := j.limits
+	limits.CPUSet = ""
+	limits.Rlimits = Rlimits{}
+	if limits == (ResourceLimits{}) && len(j.devices) == 0 {
+		return nil
+	}
+
+	cg, err := newCgroupManager(j.id)
+	if err != nil {
+		return err
+	}
+
+	debugLog("Creating cgroup for %s with limits %+v", j, limits)
+	if err := cg.Create(limits); err != nil {
+		if rerr := cg.Remove(); rerr != nil {
+			debugLog("Failed to remove cgroup for %s: %v", j, rerr)
+		}
+		return fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	if len(j.devices) > 0 {
+		if err := cg.RestrictDevices(j.devices); err != nil {
+			if rerr := cg.Remove(); rerr != nil {
+				debugLog("Failed to remove cgroup for %s: %v", j, rerr)
+			}
+			return fmt.Errorf("failed to restrict devices: %w", err)
+		}
+	}
+	j.cgroup = cg
+	return nil
+}
+
+// removeCgroup removes the cgroup of the job, if any
+func (j *job) removeCgroup() {
+	if j.cgroup == nil {
+		return
+	}
+	debugLog("Removing cgroup for %s", j)
+	if err := j.cgroup.Remove(); err != nil {
+		debugLog("Failed to remove cgroup for %s: %v", j, err)
+	}
 }
 
 func (j *job) startOutputWriter() error {
-	// Set up a TeeReader that reads from stdout and stderr of the job and write the same to outFile
+	if j.config.DiscardOutput {
+		// stdout and stderr of the job are connected to the null device when they're not set
+		debugLog("Discarding output for %s", j)
+		j.closeOutputWriterDone()
+		return nil
+	}
+
+	f, err := j.openOutputFile()
+	if err != nil {
+		debugLog("Failed to open output file: %v", err)
+		return err
+	}
+
+	// Set up the pipes for stdout and stderr of the job, outputWriter writes both of them to outFile
 	so, err := j.cmd.StdoutPipe()
 	if err != nil {
 		debugLog("Failed to capture stdout: %v", err)
+		_ = f.Close()
 		return err
 	}
 	se, err := j.cmd.StderrPipe()
 	if err != nil {
 		debugLog("Failed to capture stderr: %v", err)
-		return err
-	}
-	f, err := os.Create(j.outFile)
-	if err != nil {
-		debugLog("Failed to open output file: %v", err)
+		_ = f.Close()
 		return err
 	}
 
 	// Start outputWriter
 	j.wg.Add(1)
-	go j.outputWriter(io.MultiReader(so, se), f)
+	go j.outputWriter(so, se, f)
 	return nil
 }
 
-// reExecHandler runs the user's command in a shell
+// reExecHandler runs the user's command in a shell, or the user's argv without a shell
 func reExecHandler() {
 	rootFSPath := os.Args[1]
-	profile := os.Args[2]
-	command := os.Args[3]
+	profile := os.Args[4]
+	hostname := os.Args[5]
+	command := os.Args[6]
+	argv := os.Args[7:]
+
+	debugLog("Spawning command %s %q with profile %s, hostname %s and rootfs %s", command, argv, profile,
+		hostname, rootFSPath)
+
+	// Setup failures are reported on the diagnostics pipe instead of stdout and stderr, which are
+	// captured as the output of the job
+	diagPipe := os.NewFile(4, "diag")
+	setupFailed := func(format string, a ...interface{}) {
+		fmt.Fprintf(diagPipe, format+"\n", a...)
+		os.Exit(1)
+	}
 
-	debugLog("Spawning command %s with profile %s and rootfs %s", command, profile, rootFSPath)
+	var mounts []Mount
+	if err := json.Unmarshal([]byte(os.Args[2]), &mounts); err != nil {
+		setupFailed("failed to parse mounts %s: %v", os.Args[2], err)
+	}
 
-	if err := rootFSSetup(rootFSPath); err != nil {
-		fmt.Printf("failed to set up root fs for %s: %v\n", rootFSPath, err)
-		os.Exit(1)
+	var opts handlerOptions
+	if err := json.Unmarshal([]byte(os.Args[3]), &opts); err != nil {
+		setupFailed("failed to parse options: %v", err)
 	}
 
-	// TODO: Set up cgroups according to the profile
+	if err := rootFSSetup(rootFSPath, mounts); err != nil {
+		setupFailed("failed to set up root fs for %s: %v", rootFSPath, err)
+	}
 
-	cmd := exec.Command("/bin/sh", []string{"-c", command}...)
+	if err := syscall.Sethostname([]byte(hostname)); err != nil {
+		setupFailed("failed to set hostname %s: %v", hostname, err)
+	}
+
+	// Wait till the job is moved to its cgroup
+	syncPipe := os.NewFile(3, "sync")
+	if _, err := syncPipe.Read(make([]byte, 1)); err != nil {
+		setupFailed("failed to set up job: %v", err)
+	}
+	_ = syncPipe.Close()
+
+	if len(opts.CPUs) > 0 {
+		if err := setAffinity(opts.CPUs); err != nil {
+			setupFailed("failed to pin job to CPUs %v: %v", opts.CPUs, err)
+		}
+	}
+
+	// Neither the hook nor the command may inherit the diagnostics and the attempts pipes, a
+	// process the hook leaves in the background would keep the diagnostics pipe open and block the
+	// start of the job
+	syscall.CloseOnExec(4)
+	syscall.CloseOnExec(5)
+
+	// The hook runs once the job is confined like the command, its failure fails the set up
+	if opts.PreExec != "" {
+		if err := runPreExec(opts.PreExec, opts.Env); err != nil {
+			setupFailed("pre-exec hook failed: %v", err)
+		}
+	}
+
+	// The rlimits apply to the command, the hook and the set up of the job aren't limited
+	if err := setRlimits(opts.Rlimits); err != nil {
+		setupFailed("failed to set rlimits: %v", err)
+	}
+
+	// The job is set up
+	_ = diagPipe.Close()
+
+	if command != "" {
+		argv = []string{"/bin/sh", "-c", command}
+	}
+
+	// Signals sent to the job are meant for the command, the handler only waits for it. The
+	// command doesn't inherit the Go signal handlers, it gets the default actions on exec.
+	shieldHandler()
+
+	// The exit codes of the attempts that are retried are reported on the attempts pipe
+	attemptsPipe := os.NewFile(5, "attempts")
+
+	exitCode := runCommand(argv, opts.Env)
+	backoff := opts.RetryBackoff
+	for attempt := 2; exitCode != 0 && attempt <= opts.Retries+1; attempt++ {
+		fmt.Fprintf(attemptsPipe, "%d\n", exitCode)
+		time.Sleep(backoff)
+		backoff *= 2
+
+		fmt.Fprintf(os.Stdout, "\n--- attempt %d of %d, the previous attempt exited with %d ---\n", attempt,
+			opts.Retries+1, exitCode)
+		exitCode = runCommand(argv, opts.Env)
+	}
+	os.Exit(exitCode)
+}
+
+// handlerOptions are the options of a job passed to reExecHandler
+type handlerOptions struct {
+	Env          []string      // environment variables of the command
+	Retries      int           // number of times the command is run again when it fails
+	RetryBackoff time.Duration // delay before the first retry, doubled after every retry
+	CPUs         []int         // CPUs the command is pinned to, none if it isn't pinned
+	PreExec      string        // shell command run before the command, if any
+	Rlimits      Rlimits       // rlimits of the command
+}
+
+// runPreExec runs the pre-exec hook of a job in a shell with env added to the environment of the
+// handler. An error is returned if the hook exits with a non-zero exit code or doesn't finish
+// within PreExecTimeout.
+func runPreExec(hook string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), PreExecTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", PreExecTimeout)
+	}
+	return err
+}
+
+// runCommand runs argv with env added to the environment of the handler and returns its exit code
+func runCommand(argv, env []string) int {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if len(env) > 0 {
+		// the command inherits the environment of the handler, later variables win over earlier ones
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	if err := cmd.Run(); err != nil {
-		os.Exit(cmd.ProcessState.ExitCode())
+		if cmd.ProcessState == nil {
+			// the command couldn't be started, e.g. the executable doesn't exist
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 127
+		}
+		return cmd.ProcessState.ExitCode()
+	}
+	return 0
+}
+
+// hostname returns the hostname of the job in its UTS namespace
+func (j *job) hostname() string {
+	if j.config.Hostname != "" {
+		return j.config.Hostname
+	}
+	return j.id
+}
+
+// validateHostname checks that hostname can be set as the hostname of a job, an empty hostname is
+// valid since the job ID is used instead
+func validateHostname(hostname string) error {
+	if len(hostname) > maxHostnameLen {
+		return fmt.Errorf("hostname %q is longer than %d bytes", hostname, maxHostnameLen)
+	}
+	return nil
+}
+
+// validateEnv checks that the environment variables of a job are KEY=VALUE with a non-empty key
+func validateEnv(env []string) error {
+	for _, kv := range env {
+		if strings.IndexByte(kv, '=') <= 0 || strings.IndexByte(kv, 0) >= 0 {
+			return fmt.Errorf("%w: %q", ErrInvalidEnv, kv)
+		}
+	}
+	return nil
+}
+
+// validateOutputPath checks that the output file of a job can be created at path, creating its
+// directory if needed. An empty path is valid, the output file is stored in the job directory then.
+func validateOutputPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("invalid output path %q", path)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, JobDirMode); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
 	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return fmt.Errorf("output path %s is a directory", path)
+	}
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		return fmt.Errorf("output directory %s isn't writable: %w", dir, err)
+	}
+	return nil
+}
+
+// ValidateRunnerHome creates RunnerHome with RunnerHomeMode if it doesn't exist already and checks
+// that it's safe to store the files of jobs in it. A *SetupError is returned if RunnerHome is owned
+// by another user or is writable by everyone.
+func ValidateRunnerHome() error {
+	return validateHome("runner home", "RunnerHome", RunnerHome)
+}
+
+// ValidateScratchHome is like ValidateRunnerHome for ScratchHome. It's a no-op if ScratchHome is
+// empty.
+func ValidateScratchHome() error {
+	if ScratchHome == "" {
+		return nil
+	}
+	return validateHome("scratch home", "ScratchHome", ScratchHome)
+}
+
+// scratchHome returns the directory where the root filesystems of jobs are stored
+func scratchHome() string {
+	if ScratchHome == "" {
+		return RunnerHome
+	}
+	return ScratchHome
+}
+
+// validateHome creates dir with RunnerHomeMode if it doesn't exist already and checks that it's
+// safe to store the files of jobs in it. what describes dir in errors and variable is the variable
+// that configures dir.
+func validateHome(what, variable, dir string) error {
+	if err := os.MkdirAll(dir, RunnerHomeMode); err != nil {
+		return fmt.Errorf("failed to set up %s: %w", what, err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to set up %s: %w", what, err)
+	}
+	return checkHome(what, variable, dir, info, os.Geteuid())
+}
+
+// checkHome returns a SetupError if the dir described by info isn't owned by uid or is writable by
+// everyone
+func checkHome(what, variable, dir string, info os.FileInfo, uid int) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != uid {
+		return &SetupError{
+			Reason: fmt.Sprintf("%s %s is owned by another user", what, dir),
+			Hint:   fmt.Sprintf("set %s to a directory owned by uid %d", variable, uid),
+			Err:    fmt.Errorf("owner uid %d", stat.Uid),
+		}
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return &SetupError{
+			Reason: fmt.Sprintf("%s %s is world-writable", what, dir),
+			Hint:   fmt.Sprintf("restrict its permissions, e.g. with 'chmod %o %s'", RunnerHomeMode, dir),
+			Err:    fmt.Errorf("permissions %v", info.Mode().Perm()),
+		}
+	}
+	return nil
+}
+
+// undoSetup removes the cgroup and the root filesystem of a job that failed to start
+func (j *job) undoSetup() {
+	j.removeCgroup()
+	if err := j.deleteRootFSTree(); err != nil {
+		debugLog("Failed to delete root filesystem for %s: %v", j, err)
+	}
+}
+
+// removeJobDir removes the directory of a job that failed to start
+func (j *job) removeJobDir() {
+	untrack(j)
+	if err := os.RemoveAll(j.dir); err != nil {
+		debugLog("Failed to remove files of %s: %v", j, err)
+	}
+}
+
+func (j *job) createJobDir() error {
+	debugLog("Creating directory for %s", j)
+	if err := os.MkdirAll(filepath.Dir(j.dir), JobDirMode); err != nil {
+		return err
+	}
+	if err := os.Mkdir(j.dir, JobDirMode); err != nil {
+		return err
+	}
+
+	// Mkdir is subject to umask, make sure that the directory has the configured permissions
+	return os.Chmod(j.dir, JobDirMode)
+}
+
+// seekToLast seeks f to the last bytes of the file, or to the beginning of the file if it's
+// smaller
+func seekToLast(f *os.File, last int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := fi.Size() - last
+	if offset < 0 {
+		offset = 0
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	return err
+}
+
+// openOutputFile creates or truncates the output file of the job, or opens it for appending if the
+// job appends its output
+func (j *job) openOutputFile() (*os.File, error) {
+	if j.config.AppendOutput {
+		return openFile(j.outFile, os.O_APPEND, OutputFileMode)
+	}
+	return createFile(j.outFile, OutputFileMode)
+}
+
+// createFile creates or truncates the named file with the given permissions
+func createFile(name string, mode os.FileMode) (*os.File, error) {
+	return openFile(name, os.O_TRUNC, mode)
+}
+
+// openFile opens the named file for reading and writing with the given extra flags, creating it if
+// it doesn't exist, and sets its permissions
+func openFile(name string, flag int, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|flag, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenFile is subject to umask, make sure that the file has the configured permissions
+	if err := f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
 }
 
 func (j *job) createRootFSTree() error {
 	debugLog("Creating root filesystem tree for %s", j)
+	if err := os.MkdirAll(j.scratchDir(), JobDirMode); err != nil {
+		return err
+	}
 	return dirCopy.Copy(RootFSSource, j.rootFSPath)
 }
 
 func (j *job) deleteRootFSTree() error {
 	debugLog("Deleting root filesystem tree for %s", j)
+	if dir := j.scratchDir(); dir != j.dir {
+		// <ScratchHome>/jobs/<namespace>/<job_id> only holds the root filesystem
+		return os.RemoveAll(dir)
+	}
 	return os.RemoveAll(j.rootFSPath)
 }
+
+// scratchDir returns the directory of the job's root filesystem, which is the job's directory
+// unless ScratchHome is set
+func (j *job) scratchDir() string {
+	return filepath.Dir(j.rootFSPath)
+}
@@ -1,23 +1,41 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func init() {
+	RegisterReexec()
 	Debug = true
 	RootFSSource = "/tmp/runner/rootfs"
 }
 
+// TestMain removes the files of the jobs that tests leave behind
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if err := Shutdown(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to shut down: %v\n", err)
+	}
+	os.Exit(code)
+}
+
 // TestSimpleCommands tests running simple shell commands, their completion status and output
 func TestSimpleCommands(t *testing.T) {
 	testCases := []struct {
@@ -89,61 +107,2124 @@ func TestSimpleCommands(t *testing.T) {
 			exitCode: 0,
 			output:   "foo\nbar\n",
 		},
+		{
+			name:     "binary output",
+			command:  "printf '\\000\\001\\377\\033[31m\\r\\200'",
+			nilErr:   true,
+			nilJob:   false,
+			status:   StatusCompleted,
+			exitCode: 0,
+			output:   "\x00\x01\xff\x1b[31m\r\x80",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+			}
+			j, err := StartJob(c)
+			require.Equal(t, tc.nilErr, err == nil)
+			require.Equal(t, tc.nilJob, j == nil)
+
+			if j != nil {
+				// let the job finish
+				j.Wait()
+
+				// id
+				assert.NotEmpty(t, j.ID())
+
+				// status
+				assertStatus(t, j, tc.status, tc.exitCode)
+
+				// output
+				assertOutput(t, j, tc.output)
+			}
+		})
+	}
+}
+
+// TestArgs tests running commands with an exact argv without a shell
+func TestArgs(t *testing.T) {
+	testCases := []struct {
+		name     string   // name of the test case
+		command  string   // command to be tested
+		args     []string // argv to be tested
+		nilErr   bool     // nil error from StartJob?
+		exitCode int      // exit code
+		output   string   // output
+	}{
+		{
+			name:     "no shell expansion",
+			args:     []string{"echo", "$HOME  `id` | file with spaces"},
+			nilErr:   true,
+			exitCode: 0,
+			output:   "$HOME  `id` | file with spaces\n",
+		},
+		{
+			name:     "exit code",
+			args:     []string{"sh", "-c", "exit 3"},
+			nilErr:   true,
+			exitCode: 3,
+			output:   "",
+		},
+		{
+			name:     "missing executable",
+			args:     []string{"/invalid/executable"},
+			nilErr:   true,
+			exitCode: 127,
+			output:   "fork/exec /invalid/executable: no such file or directory\n",
+		},
+		{
+			name:    "command and args",
+			command: "echo 123",
+			args:    []string{"echo", "123"},
+			nilErr:  false,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+				Args:    tc.args,
+			}
+			j, err := StartJob(c)
+			require.Equal(t, tc.nilErr, err == nil)
+			if j == nil {
+				return
+			}
+			j.Wait()
+
+			// status
+			assertStatus(t, j, StatusCompleted, tc.exitCode)
+
+			// output
+			assertOutput(t, j, tc.output)
+		})
+	}
+}
+
+// TestTimeout tests timeout expiration for jobs
+func TestTimeout(t *testing.T) {
+	testCases := []struct {
+		name    string        // test case name
+		command string        // command to run
+		timeout time.Duration // timeout
+		output  string        // output
+	}{
+		{
+			name:    "200ms timeout",
+			command: "echo 123 && sleep 5 && echo 456",
+			timeout: 200 * time.Millisecond,
+			output:  "123\n",
+		},
+		{
+			name:    "1s timeout",
+			command: "echo 123 && sleep 5 && echo 456",
+			timeout: time.Second,
+			output:  "123\n",
+		},
+		{
+			name:    "3s timeout",
+			command: "for i in $(seq 1 10); do echo iteration $i; sleep 1; done",
+			timeout: 3 * time.Second,
+			output:  "iteration 1\niteration 2\niteration 3\n",
+		},
+		{
+			name:    "5s timeout",
+			command: "for i in $(seq 1 10); do echo iteration $i; sleep 1; done",
+			timeout: 5 * time.Second,
+			output:  "iteration 1\niteration 2\niteration 3\niteration 4\niteration 5\n",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+				Timeout: tc.timeout,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			// let the job time out
+			j.Wait()
+
+			// status
+			assertStatus(t, j, StatusTimedOut, -1)
+
+			// output
+			assertOutput(t, j, tc.output)
+		})
+	}
+}
+
+// TestInactivityTimeout tests that a job is killed once it produces no output for its inactivity
+// timeout, but not while it keeps producing output
+func TestInactivityTimeout(t *testing.T) {
+	testCases := []struct {
+		name    string    // test case name
+		command string    // command to run
+		status  JobStatus // terminal status of the job
+		code    int       // exit code of the job
+	}{
+		{name: "silent", command: "sleep 60", status: StatusInactive, code: -1},
+		{name: "silent after output", command: "echo 123 && sleep 60", status: StatusInactive, code: -1},
+		{name: "output", command: "for i in $(seq 1 8); do echo $i; sleep 1; done", status: StatusCompleted, code: 0},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Now()
+			j, err := StartJob(JobConfig{Command: tc.command, InactivityTimeout: 5 * time.Second})
+			require.Nil(t, err)
+			j.Wait()
+
+			assertStatus(t, j, tc.status, tc.code)
+			if tc.status == StatusInactive {
+				assert.GreaterOrEqual(t, time.Since(start), 5*time.Second)
+				assert.Less(t, time.Since(start), 30*time.Second)
+			}
+		})
+	}
+
+	_, err := StartJob(JobConfig{Command: "sleep 60", InactivityTimeout: time.Second, DiscardOutput: true})
+	assert.NotNil(t, err)
+}
+
+// TestConcurrentTimeouts tests a burst of jobs timing out at the same time
+func TestConcurrentTimeouts(t *testing.T) {
+	testCases := []struct {
+		name    string        // test case name
+		command string        // command to run
+		timeout time.Duration // timeout
+		numJobs int           // number of jobs
+		output  string        // output
+	}{
+		{
+			name:    "20 jobs",
+			command: "echo 123 && sleep 3600", // go test should timeout in case of failure
+			timeout: time.Second,
+			numJobs: 20,
+			output:  "123\n",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jobs := make([]Job, 0, tc.numJobs)
+			for i := 0; i < tc.numJobs; i++ {
+				j, err := StartJob(JobConfig{
+					Command: tc.command,
+					Timeout: tc.timeout,
+				})
+				require.NotNil(t, j)
+				require.Nil(t, err)
+				jobs = append(jobs, j)
+			}
+
+			for _, j := range jobs {
+				j.Wait()
+
+				// status
+				assertStatus(t, j, StatusTimedOut, -1)
+
+				// output
+				assertOutput(t, j, tc.output)
+			}
+		})
+	}
+}
+
+// TestTimeoutDuringBurst tests that a job killed due to timeout while producing output rapidly
+// doesn't lose any of the output that was produced before the kill
+func TestTimeoutDuringBurst(t *testing.T) {
+	testCases := []struct {
+		name    string        // test case name
+		command string        // command to run
+		timeout time.Duration // timeout
+	}{
+		{
+			name:    "1s timeout",
+			command: "i=0; while true; do i=$((i+1)); echo line $i; done",
+			timeout: time.Second,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+				Timeout: tc.timeout,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			// let the job time out
+			j.Wait()
+
+			// status
+			assertStatus(t, j, StatusTimedOut, -1)
+
+			// output must contain every line produced before the kill, without gaps or partial lines
+			output := getOutput(t, j)
+			require.NotEmpty(t, output)
+			require.True(t, strings.HasSuffix(output, "\n"), "output ends with a partial line")
+
+			lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+			for i, line := range lines {
+				require.Equal(t, fmt.Sprintf("line %d", i+1), line)
+			}
+		})
+	}
+}
+
+// TestFlushPolicy tests that output is written completely to the output file for all the flush policies
+func TestFlushPolicy(t *testing.T) {
+	testCases := []struct {
+		name    string      // test case name
+		command string      // command to run
+		policy  FlushPolicy // flush policy
+		output  string      // output
+	}{
+		{
+			name:    "write through",
+			command: "echo abc && echo xyz",
+			policy:  FlushPolicy{},
+			output:  "abc\nxyz\n",
+		},
+		{
+			name:    "write through with sync",
+			command: "echo abc && echo xyz",
+			policy:  FlushPolicy{Sync: true},
+			output:  "abc\nxyz\n",
+		},
+		{
+			name:    "bytes",
+			command: "for i in $(seq 1 5); do echo iteration $i; done",
+			policy:  FlushPolicy{Bytes: 16},
+			output:  "iteration 1\niteration 2\niteration 3\niteration 4\niteration 5\n",
+		},
+		{
+			name:    "interval with sync",
+			command: "for i in $(seq 1 3); do echo iteration $i; sleep 1; done",
+			policy:  FlushPolicy{Interval: 500 * time.Millisecond, Sync: true},
+			output:  "iteration 1\niteration 2\niteration 3\n",
+		},
+		{
+			name:    "bytes larger than output",
+			command: "echo abc && echo xyz",
+			policy:  FlushPolicy{Bytes: 4096},
+			output:  "abc\nxyz\n",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+				Flush:   tc.policy,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			// output
+			assertOutput(t, j, tc.output)
+
+			// status
+			j.Wait()
+			assertStatus(t, j, StatusCompleted, 0)
+		})
+	}
+}
+
+// TestFlushWriterError tests that a failed flush keeps the output buffered by earlier writes and
+// reports the output of the failed write as not written
+func TestFlushWriterError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.log")
+	f, err := os.Create(path)
+	require.Nil(t, err)
+
+	w := newFlushWriter(f, FlushPolicy{Bytes: 8})
+	n, err := w.Write([]byte("1234"))
+	require.Nil(t, err)
+	require.Equal(t, 4, n)
+
+	require.Nil(t, f.Close())
+	n, err = w.Write([]byte("5678"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+
+	w.f, err = os.OpenFile(path, os.O_WRONLY, 0)
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, "1234", string(data))
+}
+
+// TestCompressOutput tests compression of the output file once the job completes and streaming
+// output from the compressed output file
+func TestCompressOutput(t *testing.T) {
+	testCases := []struct {
+		name     string // test case name
+		command  string // command to run
+		compress bool   // compress output?
+		output   string // output
+	}{
+		{
+			name:     "compressed",
+			command:  "for i in $(seq 1 3); do echo iteration $i; sleep 1; done",
+			compress: true,
+			output:   "iteration 1\niteration 2\niteration 3\n",
+		},
+		{
+			name:     "uncompressed",
+			command:  "for i in $(seq 1 3); do echo iteration $i; sleep 1; done",
+			compress: false,
+			output:   "iteration 1\niteration 2\niteration 3\n",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command:        tc.command,
+				CompressOutput: tc.compress,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			// live output of a running job
+			assertOutput(t, j, tc.output)
+
+			j.Wait()
+			assertStatus(t, j, StatusCompleted, 0)
+
+			outFile := j.(*job).outFile
+			_, err = os.Stat(outFile)
+			assert.Equal(t, tc.compress, os.IsNotExist(err))
+			_, err = os.Stat(outFile + ".gz")
+			assert.Equal(t, tc.compress, err == nil)
+
+			// replay output of a completed job
+			assertOutput(t, j, tc.output)
+		})
+	}
+}
+
+// TestOutputLast tests streaming the last bytes of the output of a job
+func TestOutputLast(t *testing.T) {
+	testCases := []struct {
+		name     string // test case name
+		last     int64  // number of last bytes
+		compress bool   // compress output?
+		output   string // output
+	}{
+		{name: "last bytes", last: 4, compress: false, output: "789\n"},
+		{name: "more than the output", last: 100, compress: false, output: "123\n456\n789\n"},
+		{name: "no bytes", last: 0, compress: false, output: ""},
+		{name: "compressed last bytes", last: 4, compress: true, output: "789\n"},
+		{name: "compressed more than the output", last: 100, compress: true, output: "123\n456\n789\n"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			j, err := StartJob(JobConfig{Command: "echo 123; echo 456; echo 789", CompressOutput: tc.compress})
+			require.Nil(t, err)
+			j.Wait()
+
+			out, cancel, err := j.OutputLast(tc.last)
+			require.Nil(t, err)
+			defer cancel()
+
+			output := make([]byte, 0)
+			for b := range out {
+				output = append(output, b.Bytes...)
+			}
+			assert.Equal(t, tc.output, string(output))
+		})
+	}
+
+	j, err := StartJob(JobConfig{Command: "true"})
+	require.Nil(t, err)
+	_, _, err = j.OutputLast(-1)
+	assert.NotNil(t, err)
+}
+
+// TestDiscardOutput tests jobs that discard their output
+func TestDiscardOutput(t *testing.T) {
+	testCases := []struct {
+		name     string        // test case name
+		command  string        // command to run
+		timeout  time.Duration // timeout
+		status   JobStatus     // completion status
+		exitCode int           // exit code
+	}{
+		{
+			name:     "completed",
+			command:  "echo 123 && echo 456 >&2",
+			status:   StatusCompleted,
+			exitCode: 0,
+		},
+		{
+			name:     "failing command",
+			command:  "cat invalid_file",
+			status:   StatusCompleted,
+			exitCode: 1,
+		},
+		{
+			name:     "timed out",
+			command:  "echo 123 && sleep 5",
+			timeout:  time.Second,
+			status:   StatusTimedOut,
+			exitCode: -1,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command:       tc.command,
+				Timeout:       tc.timeout,
+				DiscardOutput: true,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			j.Wait()
+
+			// status
+			assertStatus(t, j, tc.status, tc.exitCode)
+
+			// output
+			_, _, err = j.Output()
+			assert.ErrorIs(t, err, ErrOutputDiscarded)
+
+			_, err = os.Stat(j.(*job).outFile)
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+// TestUsage tests resource usage accounting for jobs
+func TestUsage(t *testing.T) {
+	require.Nil(t, RegisterProfile("test-usage", ResourceLimits{PidsMax: 16}))
+
+	testCases := []struct {
+		name    string        // test case name
+		command string        // command to run
+		profile ResProfile    // profile of the job
+		mounts  []Mount       // mounts of the job
+		minCPU  time.Duration // minimum CPU time of the job
+	}{
+		{
+			name:    "busy loop",
+			command: "i=0; while [ $i -lt 200000 ]; do i=$((i+1)); done",
+		},
+		{
+			// the busy loop is killed with the job, it's only accounted by the cgroup of the job
+			name:    "busy loop killed with the job",
+			command: "(while :; do :; done) & sleep 1",
+			profile: "test-usage",
+			mounts:  []Mount{{Source: "/dev/null", Target: "/dev/null"}},
+			minCPU:  200 * time.Millisecond,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+				Profile: tc.profile,
+				Mounts:  tc.mounts,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			// usage isn't available while the job is running
+			_, ok := j.Usage()
+			assert.False(t, ok)
+
+			j.Wait()
+			assertStatus(t, j, StatusCompleted, 0)
+
+			usage, ok := j.Usage()
+			require.True(t, ok)
+			assert.Greater(t, usage.UserTime+usage.SystemTime, time.Duration(0))
+			assert.GreaterOrEqual(t, usage.UserTime+usage.SystemTime, tc.minCPU)
+			assert.Greater(t, usage.MaxRSS, int64(0))
+		})
+	}
+}
+
+// TestProfile tests that the resource limits of the job's profile are applied to the job
+func TestProfile(t *testing.T) {
+	require.Nil(t, RegisterProfile("test-pids", ResourceLimits{PidsMax: 16}))
+	require.Nil(t, RegisterProfile("test-cpu-mem", ResourceLimits{CPUs: 0.5, MemoryMax: 64 << 20}))
+
+	testCases := []struct {
+		name     string     // test case name
+		profile  ResProfile // profile of the job
+		command  string     // command to run
+		nilErr   bool       // nil error from StartJob?
+		exitCode int        // exit code
+		output   string     // substring of the output
+	}{
+		{
+			name:    "default profile",
+			command: "echo 123",
+			nilErr:  true,
+			output:  "123",
+		},
+		{
+			name:     "pids limit",
+			profile:  "test-pids",
+			command:  "for i in $(seq 32); do sleep 1 & done; wait",
+			nilErr:   true,
+			exitCode: 2,
+			output:   "can't fork",
+		},
+		{
+			name:    "cpu and memory limits",
+			profile: "test-cpu-mem",
+			command: "echo 123",
+			nilErr:  true,
+			output:  "123",
+		},
+		{
+			name:    "unknown profile",
+			profile: "invalid",
+			command: "echo 123",
+			nilErr:  false,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// ash redirects the stdin of background commands from /dev/null, which isn't in the root
+			// filesystem
+			c := JobConfig{
+				Command: tc.command,
+				Profile: tc.profile,
+				Mounts:  []Mount{{Source: "/dev/null", Target: "/dev/null"}},
+			}
+			j, err := StartJob(c)
+			require.Equal(t, tc.nilErr, err == nil)
+			if j == nil {
+				assert.True(t, errors.Is(err, ErrUnknownProfile))
+				return
+			}
+			j.Wait()
+
+			assertStatus(t, j, StatusCompleted, tc.exitCode)
+			assert.Contains(t, getOutput(t, j), tc.output)
+
+			// the cgroup of the job is removed once the job completes
+			if cg := j.(*job).cgroup; cg != nil {
+				_, err := os.Stat(filepath.Join(cgroupMountPoint, "pids", CgroupParent, j.ID()))
+				assert.True(t, errors.Is(err, os.ErrNotExist))
+			}
+		})
+	}
+}
+
+// TestLiveUsage tests the live resource usage of running jobs
+func TestLiveUsage(t *testing.T) {
+	require.Nil(t, RegisterProfile("test-live", ResourceLimits{MemoryMax: 64 << 20}))
+
+	testCases := []struct {
+		name    string     // test case name
+		profile ResProfile // profile of the job
+		ok      bool       // is live usage available?
+	}{
+		{
+			name:    "cgroup",
+			profile: "test-live",
+			ok:      true,
+		},
+		{
+			name:    "no cgroup",
+			profile: ResProfileDefault,
+			ok:      false,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: "while true; do :; done",
+				Profile: tc.profile,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			if tc.ok {
+				assert.Eventually(t, func() bool {
+					usage, ok := j.LiveUsage()
+					return ok && usage.CPUTime > 0 && usage.MemoryCurrent > 0
+				}, 5*time.Second, 100*time.Millisecond)
+			} else {
+				_, ok := j.LiveUsage()
+				assert.False(t, ok)
+			}
+
+			// live usage isn't available once the job reaches a terminal state
+			j.Stop()
+			_, ok := j.LiveUsage()
+			assert.False(t, ok)
+		})
+	}
+}
+
+// TestPause tests pausing and resuming a job with and without a cgroup
+func TestPause(t *testing.T) {
+	require.Nil(t, RegisterProfile("test-pause", ResourceLimits{PidsMax: 64}))
+
+	testCases := []struct {
+		name    string     // test case name
+		profile ResProfile // profile of the job
+	}{
+		{
+			name:    "cgroup freezer",
+			profile: "test-pause",
+		},
+		{
+			name:    "no cgroup",
+			profile: ResProfileDefault,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: "i=0; while true; do echo $i; i=$((i+1)); sleep 0.05; done",
+				Profile: tc.profile,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+			defer j.Stop()
+
+			outputSize := func() int64 {
+				info, err := os.Stat(j.(*job).outFile)
+				require.Nil(t, err)
+				return info.Size()
+			}
+
+			// only a paused job can be resumed
+			assert.True(t, errors.Is(j.Resume(), ErrNotPaused))
+
+			require.Eventually(t, func() bool { return outputSize() > 0 }, 5*time.Second, 10*time.Millisecond)
+			require.Nil(t, j.Pause())
+			assertStatus(t, j, StatusPaused, -1)
+			assert.True(t, errors.Is(j.Pause(), ErrNotRunning))
+			assert.True(t, j.Alive())
+
+			// output stops while the job is paused
+			time.Sleep(100 * time.Millisecond)
+			size := outputSize()
+			time.Sleep(500 * time.Millisecond)
+			assert.Equal(t, size, outputSize())
+
+			// output resumes once the job is resumed
+			require.Nil(t, j.Resume())
+			assertStatus(t, j, StatusRunning, -1)
+			assert.Eventually(t, func() bool { return outputSize() > size }, 5*time.Second, 10*time.Millisecond)
+
+			// a paused job can be stopped
+			require.Nil(t, j.Pause())
+			j.Stop()
+			assertStatus(t, j, StatusStopped, -1)
+			assert.True(t, errors.Is(j.Pause(), ErrNotRunning))
+		})
+	}
+}
+
+// TestMounts tests that data written to a mounted directory by a job is visible to another job
+func TestMounts(t *testing.T) {
+	dir := t.TempDir()
+	mounts := []Mount{{Source: dir, Target: "/data/volume"}}
+
+	j, err := StartJob(JobConfig{Command: "echo persisted > /data/volume/file", Mounts: mounts})
+	require.NotNil(t, j)
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+
+	data, err := os.ReadFile(filepath.Join(dir, "file"))
+	require.Nil(t, err)
+	assert.Equal(t, "persisted\n", string(data))
+
+	j, err = StartJob(JobConfig{Command: "cat /data/volume/file", Mounts: mounts})
+	require.NotNil(t, j)
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+	assertOutput(t, j, "persisted\n")
+
+	invalid := [][]Mount{
+		{{Source: "relative", Target: "/data"}},
+		{{Source: dir, Target: "data"}},
+		{{Source: dir, Target: "/data/../etc"}},
+		{{Source: dir, Target: "/"}},
+	}
+	for _, mounts := range invalid {
+		_, err := StartJob(JobConfig{Command: "true", Mounts: mounts})
+		assert.NotNil(t, err, "%+v", mounts)
+	}
+}
+
+// TestRetries tests that a failing command is run again under the same job
+func TestRetries(t *testing.T) {
+	// the command fails twice, then succeeds
+	command := "n=$(($(cat /attempts 2>/dev/null || echo 0) + 1)); echo $n > /attempts; echo attempt $n; [ $n -ge 3 ]"
+	testCases := []struct {
+		name     string // test case name
+		retries  int    // number of retries
+		status   JobStatus
+		exitCode int
+		attempts []int // exit codes of the attempts
+	}{
+		{name: "enough retries", retries: 3, status: StatusCompleted, exitCode: 0, attempts: []int{1, 1, 0}},
+		{name: "too few retries", retries: 1, status: StatusCompleted, exitCode: 1, attempts: []int{1, 1}},
+		{name: "no retries", retries: 0, status: StatusCompleted, exitCode: 1, attempts: []int{1}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			j, err := StartJob(JobConfig{Command: command, Retries: tc.retries, RetryBackoff: 10 * time.Millisecond})
+			require.Nil(t, err)
+			j.Wait()
+			assertStatus(t, j, tc.status, tc.exitCode)
+			assert.Equal(t, tc.attempts, j.Attempts())
+
+			// the output of all the attempts is kept, separated by the attempt
+			expected := "attempt 1\n"
+			for i := 2; i <= len(tc.attempts); i++ {
+				expected += fmt.Sprintf("\n--- attempt %d of %d, the previous attempt exited with 1 ---\nattempt %d\n", i,
+					tc.retries+1, i)
+			}
+			assertOutput(t, j, expected)
+		})
+	}
+
+	for _, retries := range []int{-1, MaxRetries + 1} {
+		_, err := StartJob(JobConfig{Command: "true", Retries: retries})
+		assert.NotNil(t, err, "%d", retries)
+	}
+}
+
+// TestPreExec tests that the pre-exec hook runs in the root filesystem of the job before the command
+// and that the job fails to set up if the hook fails
+func TestPreExec(t *testing.T) {
+	t.Parallel()
+
+	j, err := StartJob(JobConfig{
+		Command: "cat /seed",
+		Env:     []string{"SEED=seeded"},
+		PreExec: "echo $SEED > /seed; echo hook",
+	})
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+	assertOutput(t, j, "hook\nseeded\n")
+	require.Nil(t, j.RemoveFiles())
+
+	// the hook runs once, not before every retry
+	j, err = StartJob(JobConfig{Command: "exit 1", Retries: 2, PreExec: "echo hook"})
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 1)
+	assert.Equal(t, 1, strings.Count(getOutput(t, j), "hook\n"))
+	require.Nil(t, j.RemoveFiles())
+
+	// a process left in the background by the hook doesn't hold up the start of the job. ash
+	// redirects the stdin of background commands from /dev/null, which isn't in the root filesystem.
+	start := time.Now()
+	j, err = StartJob(JobConfig{
+		Command: "echo 123",
+		PreExec: "sleep 100 &",
+		Mounts:  []Mount{{Source: "/dev/null", Target: "/dev/null"}},
+	})
+	require.Nil(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+	assertOutput(t, j, "123\n")
+	require.Nil(t, j.RemoveFiles())
+
+	j, err = StartJob(JobConfig{Command: "echo 123", PreExec: "exit 3"})
+	require.Nil(t, j)
+	require.True(t, errors.Is(err, ErrSetupFailed), "%v", err)
+	assert.Contains(t, err.Error(), "pre-exec hook failed")
+}
+
+func TestScheduledStart(t *testing.T) {
+	t.Parallel()
+
+	// the job isn't launched till it's due
+	startAt := time.Now().Add(500 * time.Millisecond)
+	j, err := StartJob(JobConfig{Command: "echo 123", StartAt: startAt})
+	require.Nil(t, err)
+	assertStatus(t, j, StatusScheduled, -1)
+
+	j.Wait()
+	assert.False(t, time.Now().Before(startAt))
+	assertStatus(t, j, StatusCompleted, 0)
+	assertOutput(t, j, "123\n")
+
+	// a job that's stopped before it's due is never launched
+	j, err = StartJob(JobConfig{Command: "echo 123", StartAt: time.Now().Add(time.Hour)})
+	require.Nil(t, err)
+	j.Stop()
+	assertStatus(t, j, StatusStopped, -1)
+	assert.Nil(t, j.Attempts())
+	select {
+	case <-j.Done():
+	default:
+		t.Fatal("stopped scheduled job isn't done")
+	}
+	assertOutput(t, j, "")
+
+	// a start time that has passed launches the job right away
+	j, err = StartJob(JobConfig{Command: "echo 123", StartAt: time.Now().Add(-time.Hour)})
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	q := NewQueue(1, 1, 0)
+	first, err := StartJob(JobConfig{Command: "sleep 1", Queue: q})
+	require.Nil(t, err)
+	assertStatus(t, first, StatusRunning, -1)
+
+	// the second job waits for the first one to finish, there's no room for a third one
+	second, err := StartJob(JobConfig{Command: "echo 123", Queue: q})
+	require.Nil(t, err)
+	assertStatus(t, second, StatusQueued, -1)
+	_, err = StartJob(JobConfig{Command: "echo 123", Queue: q})
+	assert.True(t, errors.Is(err, ErrQueueFull), "%v", err)
+	assert.Equal(t, 1, q.Running())
+	assert.Equal(t, 1, q.Queued())
+
+	first.Wait()
+	second.Wait()
+	assertStatus(t, first, StatusCompleted, 0)
+	assertStatus(t, second, StatusCompleted, 0)
+	assertOutput(t, second, "123\n")
+	assert.Equal(t, 0, q.Running())
+	assert.Equal(t, 0, q.Queued())
+
+	// a job that's stopped while it's queued is never launched
+	first, err = StartJob(JobConfig{Command: "sleep 10", Queue: q})
+	require.Nil(t, err)
+	second, err = StartJob(JobConfig{Command: "echo 123", Queue: q})
+	require.Nil(t, err)
+	second.Stop()
+	assertStatus(t, second, StatusStopped, -1)
+	assert.Equal(t, 0, q.Queued())
+	first.Stop()
+	assert.Equal(t, 0, q.Running())
+
+	// a scheduled job joins the queue once it's due
+	first, err = StartJob(JobConfig{Command: "sleep 1", Queue: q})
+	require.Nil(t, err)
+	second, err = StartJob(JobConfig{Command: "echo 123", Queue: q, StartAt: time.Now().Add(200 * time.Millisecond)})
+	require.Nil(t, err)
+	assertStatus(t, second, StatusScheduled, -1)
+	time.Sleep(500 * time.Millisecond)
+	assertStatus(t, second, StatusQueued, -1)
+	second.Wait()
+	assertStatus(t, second, StatusCompleted, 0)
+	first.Wait()
+}
+
+func TestQueuePriority(t *testing.T) {
+	now := time.Now()
+	waiting := func(priority int, waited time.Duration) *queuedJob {
+		return &queuedJob{j: &job{config: JobConfig{Priority: priority}}, queuedAt: now.Add(-waited)}
+	}
+
+	testCases := []struct {
+		name    string        // test case name
+		aging   time.Duration // how long a job waits before its priority is raised
+		waiting []*queuedJob  // jobs waiting for a slot, in the order they were queued in
+		next    int           // index of the job handed the next slot
+	}{
+		{name: "single", waiting: []*queuedJob{waiting(0, 0)}, next: 0},
+		{name: "fifo", waiting: []*queuedJob{waiting(1, 0), waiting(1, 0)}, next: 0},
+		{name: "higher priority", waiting: []*queuedJob{waiting(1, 0), waiting(5, 0), waiting(3, 0)}, next: 1},
+		{name: "no aging", waiting: []*queuedJob{waiting(0, time.Hour), waiting(1, 0)}, next: 1},
+		{name: "aged", aging: time.Minute, waiting: []*queuedJob{waiting(0, 2*time.Minute), waiting(1, 0)}, next: 0},
+		{name: "not aged enough", aging: time.Minute, waiting: []*queuedJob{waiting(0, 30*time.Second), waiting(1, 0)}, next: 1},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := NewQueue(1, -1, tc.aging)
+			q.waiting = tc.waiting
+			assert.Equal(t, tc.next, q.next(now))
+		})
+	}
+
+	q := NewQueue(1, -1, 0)
+	q.waiting = []*queuedJob{waiting(1, 0), waiting(3, 0), waiting(1, 0)}
+	assert.Equal(t, map[int]int{1: 2, 3: 1}, q.QueuedByPriority())
+
+	_, err := StartJob(JobConfig{Command: "true", Priority: MaxPriority + 1})
+	assert.NotNil(t, err)
+}
+
+func TestQueuePosition(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	waiting := func(id string, priority int) *queuedJob {
+		return &queuedJob{j: &job{id: id, config: JobConfig{Priority: priority}}, queuedAt: now}
+	}
+	q := NewQueue(2, -1, 0)
+	q.waiting = []*queuedJob{waiting("a", 0), waiting("b", 5), waiting("c", 0)}
+
+	// there's no estimate till a job frees its slot
+	position, start, ok := q.Position("c")
+	assert.True(t, ok)
+	assert.Equal(t, 3, position)
+	assert.True(t, start.IsZero())
+
+	q.avgHold = time.Minute
+	for _, tc := range []struct {
+		id       string
+		position int
+		rounds   int // number of times all the slots free up before the job starts
+	}{{"b", 1, 1}, {"a", 2, 1}, {"c", 3, 2}} {
+		position, start, ok = q.Position(tc.id)
+		assert.True(t, ok)
+		assert.Equal(t, tc.position, position, tc.id)
+		assert.WithinDuration(t, time.Now().Add(time.Duration(tc.rounds)*time.Minute), start, time.Second, tc.id)
+	}
+
+	_, _, ok = q.Position("unknown")
+	assert.False(t, ok)
+}
+
+func TestRemoveFilesWhileStreaming(t *testing.T) {
+	t.Parallel()
+
+	j, err := StartJob(JobConfig{Command: "yes | head -c 100000"})
+	require.Nil(t, err)
+	j.Wait()
+
+	out, cancel, err := j.Output()
+	require.Nil(t, err)
+	defer cancel()
+	first := <-out
+	require.NotNil(t, first)
+	require.Nil(t, first.Err)
+
+	// the stream ends with ErrFilesRemoved instead of the rest of the output
+	require.Nil(t, j.RemoveFiles())
+	var last *Output
+	n := len(first.Bytes)
+	for o := range out {
+		n += len(o.Bytes)
+		last = o
+	}
+	require.NotNil(t, last)
+	assert.True(t, errors.Is(last.Err, ErrFilesRemoved), "%v", last.Err)
+	assert.Less(t, n, 100000)
+
+	// no new stream is started
+	_, _, err = j.Output()
+	assert.True(t, errors.Is(err, ErrFilesRemoved), "%v", err)
+}
+
+// TestMountSymlink tests that a symlink left in a volume by a job can't redirect the mounts of a
+// later job to the host
+func TestMountSymlink(t *testing.T) {
+	volume := t.TempDir()
+	host := t.TempDir()
+	require.Nil(t, os.Symlink(host, filepath.Join(volume, "link")))
+
+	mounts := []Mount{
+		{Source: volume, Target: "/data"},
+		{Source: t.TempDir(), Target: "/data/link/escaped"},
+	}
+	j, err := StartJob(JobConfig{Command: "true", Mounts: mounts})
+	require.Nil(t, j)
+	require.True(t, errors.Is(err, ErrSetupFailed), "%v", err)
+	assert.Contains(t, err.Error(), "symlink")
+
+	_, err = os.Lstat(filepath.Join(host, "escaped"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+// TestDevices tests that a job can only access the devices it's allowed to access
+func TestDevices(t *testing.T) {
+	testCases := []struct {
+		name    string   // test case name
+		command string   // command to run
+		devices []string // devices the job can access
+		nilErr  bool     // nil error from StartJob?
+		code    int      // exit code
+	}{
+		{name: "allowed", command: "echo 123 > /dev/null", devices: []string{"/dev/null"}, nilErr: true, code: 0},
+		{name: "denied", command: "head -c 1 /dev/zero", devices: []string{"/dev/null"}, nilErr: true, code: 1},
+		{name: "not a device", command: "true", devices: []string{"/tmp"}, nilErr: false},
+		{name: "relative path", command: "true", devices: []string{"dev/null"}, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// /dev/zero is mounted into the root filesystem, but it isn't an allowed device
+			j, err := StartJob(JobConfig{
+				Command: tc.command,
+				Devices: tc.devices,
+				Mounts:  []Mount{{Source: "/dev/zero", Target: "/dev/zero"}},
+			})
+			require.Equal(t, tc.nilErr, err == nil, "%v", err)
+			if j == nil {
+				return
+			}
+			j.Wait()
+			assertStatus(t, j, StatusCompleted, tc.code)
+		})
+	}
+}
+
+// TestNice tests that the nice level is applied to the job and inherited by its child processes
+func TestNice(t *testing.T) {
+	testCases := []struct {
+		name   string // test case name
+		nice   int    // nice level of the job
+		nilErr bool   // nil error from StartJob?
+		output string // nice level reported by the job
+	}{
+		{name: "default", nice: 0, nilErr: true, output: "0\n"},
+		{name: "lower priority", nice: 10, nilErr: true, output: "10\n"},
+		{name: "higher priority", nice: -5, nilErr: true, output: "-5\n"},
+		{name: "out of range", nice: 20, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// nice is the 19th field of /proc/<pid>/stat
+			c := JobConfig{
+				Command: "cut -d ' ' -f 19 /proc/self/stat",
+				Nice:    tc.nice,
+			}
+			j, err := StartJob(c)
+			require.Equal(t, tc.nilErr, err == nil)
+			if j == nil {
+				return
+			}
+			j.Wait()
+
+			assertStatus(t, j, StatusCompleted, 0)
+			assertOutput(t, j, tc.output)
+		})
+	}
+}
+
+// TestStatusUpdates tests that subscribers receive every status change of the job
+func TestStatusUpdates(t *testing.T) {
+	j, err := StartJob(JobConfig{Command: "sleep 10"})
+	require.NotNil(t, j)
+	require.Nil(t, err)
+
+	updates, cancel := j.StatusUpdates()
+	defer cancel()
+
+	// a canceled subscription doesn't receive updates
+	canceled, cancelNow := j.StatusUpdates()
+	cancelNow()
+	_, ok := <-canceled
+	assert.False(t, ok)
+
+	require.Nil(t, j.Pause())
+	require.Nil(t, j.Resume())
+	j.Stop()
+
+	received := make([]JobStatus, 0)
+	for status := range updates {
+		received = append(received, status)
+	}
+	assert.Equal(t, []JobStatus{StatusPaused, StatusRunning, StatusStopped}, received)
+	assert.True(t, received[len(received)-1].Terminal())
+
+	// subscribing to a finished job returns a closed channel
+	updates, cancel = j.StatusUpdates()
+	defer cancel()
+	_, ok = <-updates
+	assert.False(t, ok)
+}
+
+// TestSetupFailure tests that a job that fails to set up is reported as a start error and that
+// the setup failure doesn't end up in its output
+func TestSetupFailure(t *testing.T) {
+	var id atomic.Value
+	var failed int32
+	var output int64
+
+	// mounting a directory that doesn't exist fails the root filesystem setup
+	c := JobConfig{
+		Command: "echo 123",
+		Mounts:  []Mount{{Source: "/invalid/source", Target: "/data"}},
+		OnStatusChange: func(jobID string, status JobStatus) {
+			id.Store(jobID)
+			if status == StatusFailed {
+				atomic.StoreInt32(&failed, 1)
+			}
+		},
+		OnOutput: func(_ string, n int) {
+			atomic.AddInt64(&output, int64(n))
+		},
+	}
+	j, err := StartJob(c)
+	require.Nil(t, j)
+	require.True(t, errors.Is(err, ErrSetupFailed), "%v", err)
+	assert.Contains(t, err.Error(), "/invalid/source")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failed))
+
+	// the setup failure isn't written to the output of the job
+	assert.Equal(t, int64(0), atomic.LoadInt64(&output))
+
+	// no one learns the ID of the job, so its files are removed
+	_, err = os.Stat(filepath.Join(RunnerHome, id.Load().(string)))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+// TestInterleavedStreams tests that stderr of a job is streamed while stdout is still open
+func TestInterleavedStreams(t *testing.T) {
+	testCases := []struct {
+		name    string // test case name
+		command string // command to run
+		output  string // output received before the job finishes
+	}{
+		{
+			name:    "stderr after stdout",
+			command: "echo out; sleep 1; echo err >&2; sleep 3; echo done",
+			output:  "out\nerr\n",
+		},
+		{
+			name:    "stdout held open",
+			command: "echo err >&2; sleep 5; echo out",
+			output:  "err\n",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			j, err := StartJob(JobConfig{Command: tc.command})
+			require.NotNil(t, j)
+			require.Nil(t, err)
+			defer j.Stop()
+
+			out, cancel, err := j.Output()
+			require.Nil(t, err)
+			defer cancel()
+
+			output := ""
+			for o := range out {
+				output += string(o.Bytes)
+				if strings.Contains(output, "err") {
+					break
+				}
+			}
+
+			// stderr is received before the job finishes
+			assert.Equal(t, tc.output, output)
+			assertStatus(t, j, StatusRunning, -1)
+		})
+	}
+}
+
+// TestDelayedOutput tests output streaming of a job that is silent for a while after it starts
+func TestDelayedOutput(t *testing.T) {
+	c := JobConfig{
+		Command: "sleep 10; echo first; sleep 3600", // go test should timeout in case of failure
+	}
+	j, err := StartJob(c)
+	require.NotNil(t, j)
+	require.Nil(t, err)
+	defer j.Stop()
+
+	out, cancel, err := j.Output()
+	require.Nil(t, err)
+
+	// the output channel blocks until the first line is written
+	o, ok := <-out
+	require.True(t, ok)
+	assert.Equal(t, "first\n", string(o.Bytes))
+	assertStatus(t, j, StatusRunning, -1)
+
+	// the output channel is closed once canceled
+	cancel()
+	for range out {
+	}
+}
+
+// TestMaxOutputStreams tests the limit on concurrent output streams of a job
+func TestMaxOutputStreams(t *testing.T) {
+	// not parallel since MaxOutputStreams applies to all jobs
+	defer func(max int) { MaxOutputStreams = max }(MaxOutputStreams)
+	MaxOutputStreams = 2
+
+	j, err := StartJob(JobConfig{Command: "sleep 3600"})
+	require.NotNil(t, j)
+	require.Nil(t, err)
+	defer j.Stop()
+
+	_, cancel1, err := j.Output()
+	require.Nil(t, err)
+	_, cancel2, err := j.Output()
+	require.Nil(t, err)
+	defer cancel2()
+
+	_, _, err = j.Output()
+	assert.ErrorIs(t, err, ErrTooManyStreams)
+
+	// canceling a stream makes room for another one, cancel is idempotent
+	cancel1()
+	cancel1()
+	_, cancel3, err := j.Output()
+	require.Nil(t, err)
+	defer cancel3()
+
+	_, _, err = j.Output()
+	assert.ErrorIs(t, err, ErrTooManyStreams)
+}
+
+// TestRunnerHomeFailure tests that StartJob returns an error when RunnerHome can't be set up
+func TestRunnerHomeFailure(t *testing.T) {
+	// not parallel since RunnerHome applies to all jobs
+	defer func(home string) { RunnerHome = home }(RunnerHome)
+
+	// RunnerHome can't be created under a regular file
+	file := filepath.Join(t.TempDir(), "file")
+	require.Nil(t, os.WriteFile(file, nil, 0600))
+	RunnerHome = filepath.Join(file, "runner")
+
+	j, err := StartJob(JobConfig{Command: "true"})
+	assert.Nil(t, j)
+	assert.ErrorIs(t, err, syscall.ENOTDIR)
+}
+
+// TestRunnerHomeValidation tests that a RunnerHome owned by another user or writable by everyone
+// is rejected
+func TestRunnerHomeValidation(t *testing.T) {
+	testCases := []struct {
+		name   string      // test case name
+		mode   os.FileMode // permissions of RunnerHome
+		owner  bool        // RunnerHome owned by the current user?
+		nilErr bool        // nil error from checkHome?
+	}{
+		{name: "private", mode: 0700, owner: true, nilErr: true},
+		{name: "world-readable", mode: 0755, owner: true, nilErr: true},
+		{name: "world-writable", mode: 0777, owner: true, nilErr: false},
+		{name: "other owner", mode: 0700, owner: false, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			require.Nil(t, os.Chmod(dir, tc.mode))
+			info, err := os.Stat(dir)
+			require.Nil(t, err)
+
+			uid := os.Geteuid()
+			if !tc.owner {
+				uid++
+			}
+			err = checkHome("runner home", "RunnerHome", dir, info, uid)
+			assert.Equal(t, tc.nilErr, err == nil)
+			if !tc.nilErr {
+				var setupErr *SetupError
+				assert.ErrorAs(t, err, &setupErr)
+			}
+		})
+	}
+}
+
+// TestStaleJobDirs tests that the directories of jobs without a live process are removed
+func TestStaleJobDirs(t *testing.T) {
+	// not parallel since RunnerHome applies to all jobs
+	defer func(home string) { RunnerHome = home }(RunnerHome)
+	RunnerHome = t.TempDir()
+
+	// directories left behind by a crashed runner
+	stale := []string{
+		filepath.Join(RunnerHome, strings.Repeat("a", jobIDLen)),
+		filepath.Join(RunnerHome, "jobs", "tenant1", strings.Repeat("b", jobIDLen)),
+	}
+	for _, dir := range stale {
+		require.Nil(t, os.MkdirAll(dir, 0700))
+		require.Nil(t, os.WriteFile(filepath.Join(dir, "output.log"), []byte("123\n"), 0600))
+	}
+
+	// directories that don't belong to jobs
+	other := []string{
+		filepath.Join(RunnerHome, "certs"),
+		filepath.Join(RunnerHome, "jobs", "tenant1", strings.Repeat("c", jobIDLen)),
+		filepath.Join(RunnerHome, "volumes", "tenant1", strings.Repeat("d", jobIDLen)),
+	}
+	for _, dir := range other {
+		require.Nil(t, os.MkdirAll(dir, 0700))
+		require.Nil(t, os.WriteFile(filepath.Join(dir, "file"), make([]byte, 1000), 0600))
+	}
+
+	// only the files of jobs count towards the disk usage
+	usage, err := DiskUsage("")
+	require.Nil(t, err)
+	assert.Equal(t, int64(2*len("123\n")), usage)
+	usage, err = DiskUsage("tenant1")
+	require.Nil(t, err)
+	assert.Equal(t, int64(len("123\n")), usage)
+
+	j, err := StartJob(JobConfig{Command: "sleep 10", Namespace: "tenant1"})
+	require.Nil(t, err)
+	defer j.Stop()
+
+	// the directory of the running job isn't stale
+	dirs, err := RemoveStaleJobDirs(true)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, stale, dirs)
+	for _, dir := range stale {
+		assert.DirExists(t, dir)
+	}
+
+	dirs, err = RemoveStaleJobDirs(false)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, stale, dirs)
+	for _, dir := range stale {
+		assert.NoDirExists(t, dir)
+	}
+	for _, dir := range append(other, j.(*job).dir) {
+		assert.DirExists(t, dir)
+	}
+}
+
+// TestShutdown tests that Shutdown stops all the jobs and leaves nothing behind under RunnerHome
+func TestShutdown(t *testing.T) {
+	// not parallel since RunnerHome and Shutdown apply to all jobs
+	defer func(home string) { RunnerHome = home }(RunnerHome)
+	RunnerHome = t.TempDir()
+
+	running, err := StartJob(JobConfig{Command: "sleep 100", Namespace: "tenant1"})
+	require.Nil(t, err)
+	paused, err := StartJob(JobConfig{Command: "sleep 100"})
+	require.Nil(t, err)
+	require.Nil(t, paused.Pause())
+	scheduled, err := StartJob(JobConfig{Command: "echo 123", StartAt: time.Now().Add(time.Hour)})
+	require.Nil(t, err)
+	completed, err := StartJob(JobConfig{Command: "echo 123"})
+	require.Nil(t, err)
+	completed.Wait()
+
+	require.Nil(t, Shutdown())
+	assertStatus(t, running, StatusStopped, -1)
+	assertStatus(t, paused, StatusStopped, -1)
+	assertStatus(t, scheduled, StatusStopped, -1)
+	assertStatus(t, completed, StatusCompleted, 0)
+
+	dirs, err := StaleJobDirs()
+	require.Nil(t, err)
+	assert.Empty(t, dirs)
+
+	// nothing is left to shut down
+	require.Nil(t, Shutdown())
+}
+
+// TestScratchHome tests that the root filesystems of jobs are stored under ScratchHome and their
+// output under RunnerHome
+func TestScratchHome(t *testing.T) {
+	// not parallel since RunnerHome and ScratchHome apply to all jobs
+	defer func(home, scratch string) { RunnerHome, ScratchHome = home, scratch }(RunnerHome, ScratchHome)
+	RunnerHome = t.TempDir()
+	ScratchHome = t.TempDir()
+
+	j, err := StartJob(JobConfig{Command: "sleep 100", Namespace: "tenant1"})
+	require.Nil(t, err)
+	assert.DirExists(t, filepath.Join(ScratchHome, "jobs", "tenant1", j.ID(), "rootfs"))
+	assert.NoDirExists(t, filepath.Join(RunnerHome, "jobs", "tenant1", j.ID(), "rootfs"))
+	assert.FileExists(t, filepath.Join(RunnerHome, "jobs", "tenant1", j.ID(), "output.log"))
+
+	// the directories of the running job aren't stale
+	dirs, err := StaleJobDirs()
+	require.Nil(t, err)
+	assert.Empty(t, dirs)
+
+	// the root filesystem is removed once the job finishes, the output is kept
+	j.Stop()
+	assert.NoDirExists(t, filepath.Join(ScratchHome, "jobs", "tenant1", j.ID()))
+	assert.FileExists(t, filepath.Join(RunnerHome, "jobs", "tenant1", j.ID(), "output.log"))
+	require.Nil(t, j.RemoveFiles())
+
+	// a root filesystem that's kept is removed with the files of the job
+	j, err = StartJob(JobConfig{Command: "echo 123", KeepRootFS: true})
+	require.Nil(t, err)
+	j.Wait()
+	assertOutput(t, j, "123\n")
+	assert.DirExists(t, filepath.Join(ScratchHome, j.ID(), "rootfs"))
+	require.Nil(t, j.RemoveFiles())
+	assert.NoDirExists(t, filepath.Join(ScratchHome, j.ID()))
+	assert.NoDirExists(t, filepath.Join(RunnerHome, j.ID()))
+}
+
+// TestRemoveFiles tests that the files of a job can only be removed once it finishes
+func TestRemoveFiles(t *testing.T) {
+	t.Parallel()
+
+	j, err := StartJob(JobConfig{Command: "echo 123; sleep 10"})
+	require.Nil(t, err)
+	assert.ErrorIs(t, j.RemoveFiles(), ErrNotFinished)
+	assert.DirExists(t, j.(*job).dir)
+
+	j.Stop()
+	assert.Nil(t, j.RemoveFiles())
+	assert.NoDirExists(t, j.(*job).dir)
+}
+
+// TestKeepRootFS tests that the root filesystem of a job is kept once it finishes till it's removed
+func TestKeepRootFS(t *testing.T) {
+	testCases := []struct {
+		name string // test case name
+		keep bool   // keep the root filesystem?
+	}{
+		{name: "kept", keep: true},
+		{name: "removed", keep: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			j, err := StartJob(JobConfig{Command: "echo 123 > /left-behind; sleep 1", KeepRootFS: tc.keep})
+			require.Nil(t, err)
+			assert.ErrorIs(t, j.RemoveRootFS(), ErrNotFinished)
+			j.Wait()
+			assertStatus(t, j, StatusCompleted, 0)
+
+			rootFS := j.(*job).rootFSPath
+			data, err := os.ReadFile(filepath.Join(rootFS, "left-behind"))
+			assert.Equal(t, tc.keep, err == nil)
+			if tc.keep {
+				assert.Equal(t, "123\n", string(data))
+			}
+
+			assert.Nil(t, j.RemoveRootFS())
+			assert.NoDirExists(t, rootFS)
+		})
+	}
+}
+
+// TestResolveProfiles tests resolving the inheritance of the profiles in a profile file
+func TestResolveProfiles(t *testing.T) {
+	cpus := func(v float64) *float64 { return &v }
+	size := func(v int64) *int64 { return &v }
+	registered := map[ResProfile]ResourceLimits{
+		ResProfileDefault: {},
+		"base":            {CPUs: 1, MemoryMax: 1 << 30, PidsMax: 100},
+	}
+
+	testCases := []struct {
+		name     string                        // test case name
+		defs     map[ResProfile]profileDef     // profiles in the file
+		expected map[ResProfile]ResourceLimits // resolved limits, nil if the profiles are invalid
+	}{
+		{
+			name:     "no inheritance",
+			defs:     map[ResProfile]profileDef{"small": {CPUs: cpus(0.5), PidsMax: size(10)}},
+			expected: map[ResProfile]ResourceLimits{"small": {CPUs: 0.5, PidsMax: 10}},
+		},
+		{
+			name: "extends profile in file",
+			defs: map[ResProfile]profileDef{
+				"small": {CPUs: cpus(0.5), MemoryMax: size(1 << 20), PidsMax: size(10)},
+				"large": {Extends: "small", CPUs: cpus(2)},
+				"huge":  {Extends: "large", PidsMax: size(0)},
+			},
+			expected: map[ResProfile]ResourceLimits{
+				"small": {CPUs: 0.5, MemoryMax: 1 << 20, PidsMax: 10},
+				"large": {CPUs: 2, MemoryMax: 1 << 20, PidsMax: 10},
+				"huge":  {CPUs: 2, MemoryMax: 1 << 20},
+			},
+		},
+		{
+			name:     "extends registered profile",
+			defs:     map[ResProfile]profileDef{"more-mem": {Extends: "base", MemoryMax: size(2 << 30)}},
+			expected: map[ResProfile]ResourceLimits{"more-mem": {CPUs: 1, MemoryMax: 2 << 30, PidsMax: 100}},
+		},
+		{
+			name: "unknown base",
+			defs: map[ResProfile]profileDef{"small": {Extends: "unknown"}},
+		},
+		{
+			name: "self cycle",
+			defs: map[ResProfile]profileDef{"small": {Extends: "small"}},
+		},
+		{
+			name: "cycle",
+			defs: map[ResProfile]profileDef{
+				"a": {Extends: "b"},
+				"b": {Extends: "c"},
+				"c": {Extends: "a", CPUs: cpus(1)},
+			},
+		},
+		{
+			name: "negative limit",
+			defs: map[ResProfile]profileDef{"small": {Extends: "base", PidsMax: size(-1)}},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resolved, err := resolveProfiles(tc.defs, registered)
+			if tc.expected == nil {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(t, tc.expected, resolved)
+		})
+	}
+}
+
+// TestLoadProfiles tests registering the profiles of a profile file
+func TestLoadProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	require.Nil(t, os.WriteFile(path, []byte(`{"profiles": {
+		"test-file-small": {"cpus": 0.5, "pids_max": 32},
+		"test-file-large": {"extends": "test-file-small", "memory_max": 67108864}
+	}}`), 0600))
+	require.Nil(t, LoadProfiles(path))
+
+	limits, err := lookupProfile("test-file-large")
+	require.Nil(t, err)
+	assert.Equal(t, ResourceLimits{CPUs: 0.5, MemoryMax: 64 << 20, PidsMax: 32}, limits)
+	assert.Contains(t, ValidProfiles(), ResProfile("test-file-small"))
+	assert.Contains(t, ValidProfiles(), ResProfile("test-file-large"))
+
+	// none of the profiles of an invalid file are registered
+	require.Nil(t, os.WriteFile(path, []byte(`{"profiles": {
+		"test-file-valid": {"cpus": 1},
+		"test-file-cycle": {"extends": "test-file-cycle"}
+	}}`), 0600))
+	assert.NotNil(t, LoadProfiles(path))
+	assert.NotContains(t, ValidProfiles(), ResProfile("test-file-valid"))
+	assert.Contains(t, ValidProfiles(), ResProfile("test-file-large"))
+
+	// reloading replaces the profiles loaded before
+	yamlPath := filepath.Join(t.TempDir(), "profiles.yaml")
+	require.Nil(t, os.WriteFile(yamlPath, []byte(`
+profiles:
+  test-file-small:
+    cpus: 1
+  test-file-medium:
+    extends: test-file-small
+    pids_max: 16
+`), 0600))
+	require.Nil(t, LoadProfiles(yamlPath))
+	limits, err = lookupProfile("test-file-medium")
+	require.Nil(t, err)
+	assert.Equal(t, ResourceLimits{CPUs: 1, PidsMax: 16}, limits)
+	assert.NotContains(t, ValidProfiles(), ResProfile("test-file-large"))
+	assert.Contains(t, ValidProfiles(), ResProfileDefault)
+}
+
+func TestParseCPUSet(t *testing.T) {
+	testCases := []struct {
+		list string // CPU set in the list format
+		cpus []int  // CPUs of the set, nil if it's invalid
+	}{
+		{list: "0", cpus: []int{0}},
+		{list: "0-3,6", cpus: []int{0, 1, 2, 3, 6}},
+		{list: "6,0-1,1", cpus: []int{0, 1, 6}},
+		{list: ""},
+		{list: "a"},
+		{list: "-1"},
+		{list: "3-1"},
+		{list: "0,"},
+		{list: "1023-1024"},
+	}
+	for _, tc := range testCases {
+		cpus, err := parseCPUSet(tc.list)
+		assert.Equal(t, tc.cpus == nil, err != nil, "%q: %v", tc.list, err)
+		assert.Equal(t, tc.cpus, cpus, tc.list)
+	}
+}
+
+// TestCPUSet tests that a job only runs on the CPUs of its CPU set
+func TestCPUSet(t *testing.T) {
+	// the last CPU available to the test, which differs from the first one on most machines
+	var available unix.CPUSet
+	require.Nil(t, unix.SchedGetaffinity(0, &available))
+	cpu := -1
+	for i := 0; i < maxCPUs; i++ {
+		if available.IsSet(i) {
+			cpu = i
+		}
+	}
+	require.NotEqual(t, -1, cpu)
+	require.Nil(t, RegisterProfile("test-cpuset", ResourceLimits{CPUSet: strconv.Itoa(cpu)}))
+
+	testCases := []struct {
+		name    string     // test case name
+		cpuSet  string     // CPU set of the job
+		profile ResProfile // profile of the job
+	}{
+		{name: "job", cpuSet: strconv.Itoa(cpu)},
+		{name: "profile", profile: "test-cpuset"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			j, err := StartJob(JobConfig{
+				Command: "grep Cpus_allowed_list /proc/self/status",
+				CPUSet:  tc.cpuSet,
+				Profile: tc.profile,
+			})
+			require.Nil(t, err)
+			j.Wait()
+			assertOutput(t, j, fmt.Sprintf("Cpus_allowed_list:\t%d\n", cpu))
+		})
+	}
+
+	// the CPUs must be available to the runner
+	_, err := StartJob(JobConfig{Command: "true", CPUSet: strconv.Itoa(maxCPUs - 1)})
+	assert.ErrorIs(t, err, ErrInvalidCPUSet)
+	_, err = StartJob(JobConfig{Command: "true", CPUSet: "0-"})
+	assert.ErrorIs(t, err, ErrInvalidCPUSet)
+	assert.NotNil(t, RegisterProfile("test-cpuset-invalid", ResourceLimits{CPUSet: "x"}))
+}
+
+// TestRlimits tests that the rlimits of a job apply to its command and that the job hits them
+func TestRlimits(t *testing.T) {
+	limit := func(n uint64) *uint64 { return &n }
+	require.Nil(t, RegisterProfile("test-rlimits", ResourceLimits{Rlimits: Rlimits{NoFile: limit(64), Core: limit(0)}}))
+
+	// the limits of the job override the limits of the profile one by one
+	j, err := StartJob(JobConfig{
+		Command: "ulimit -n; ulimit -Hn; ulimit -c; ulimit -f",
+		Profile: "test-rlimits",
+		Rlimits: Rlimits{NoFile: limit(16), FileSize: limit(1024 * 512)},
+	})
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+	// ulimit -f counts blocks of 512 bytes
+	assertOutput(t, j, "16\n16\n0\n1024\n")
+	require.Nil(t, j.RemoveFiles())
+
+	// opening more files than the limit fails inside the job
+	j, err = StartJob(JobConfig{
+		Command: `i=3; while [ $i -lt 32 ]; do eval "exec $i</etc/passwd"; i=$((i+1)); done; echo unreachable`,
+		Rlimits: Rlimits{NoFile: limit(16)},
+	})
+	require.Nil(t, err)
+	j.Wait()
+	output := getOutput(t, j)
+	assert.Contains(t, output, "No file descriptors available")
+	assert.NotContains(t, output, "unreachable")
+	require.Nil(t, j.RemoveFiles())
+
+	_, err = StartJob(JobConfig{Command: "true", Rlimits: Rlimits{NoFile: limit(MinNoFile - 1)}})
+	assert.ErrorIs(t, err, ErrInvalidRlimits)
+	assert.NotNil(t, RegisterProfile("test-rlimits-invalid", ResourceLimits{Rlimits: Rlimits{NoFile: limit(1)}}))
+
+	// profiles in profile files inherit the rlimits they don't set
+	resolved, err := resolveProfiles(map[ResProfile]profileDef{
+		"base":  {Rlimits: Rlimits{NoFile: limit(64), Core: limit(0)}},
+		"child": {Extends: "base", Rlimits: Rlimits{NoFile: limit(128)}},
+	}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, Rlimits{NoFile: limit(128), Core: limit(0)}, resolved["child"].Rlimits)
+}
+
+// TestExec tests running additional processes in the namespaces of a running job
+func TestExec(t *testing.T) {
+	t.Parallel()
+
+	j, err := StartJob(JobConfig{Command: "echo 123 > /marker; sleep 5"})
+	require.Nil(t, err)
+	defer j.Stop()
+	time.Sleep(500 * time.Millisecond)
+
+	testCases := []struct {
+		name     string   // test case name
+		args     []string // argv to execute in the job
+		output   string   // expected output of the process
+		exitCode int      // expected exit code of the process
+	}{
+		{name: "mount namespace", args: []string{"cat", "/marker"}, output: "123\n"},
+		{name: "pid namespace", args: []string{"cat", "/proc/1/cmdline"}, output: "reExecHandler"},
+		{name: "exit code", args: []string{"sh", "-c", "exit 3"}, exitCode: 3},
+		{name: "no such command", args: []string{"/bin/no-such-command"}, exitCode: 127},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			exitCode, err := j.Exec(context.Background(), tc.args, &stdout, &stderr)
+			require.Nil(t, err, stderr.String())
+			assert.Equal(t, tc.exitCode, exitCode)
+			assert.Contains(t, stdout.String(), tc.output)
+		})
+	}
+
+	_, err = j.Exec(context.Background(), nil, io.Discard, io.Discard)
+	assert.ErrorIs(t, err, ErrNoCommand)
+
+	// the process is killed when the context is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = j.Exec(ctx, []string{"sleep", "10"}, io.Discard, io.Discard)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// the output of the job doesn't include the output of the processes
+	j.Wait()
+	assertOutput(t, j, "")
+	_, err = j.Exec(context.Background(), []string{"true"}, io.Discard, io.Discard)
+	assert.ErrorIs(t, err, ErrNotRunning)
+}
+
+// TestStartJobContext tests that a job isn't started once the context of the start is done
+func TestStartJobContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	namespace := "test-canceled-start"
+	j, err := StartJobContext(ctx, JobConfig{Command: "echo 123 > /tmp/started", Namespace: namespace})
+	assert.Nil(t, j)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// nothing is left behind by the canceled job
+	entries, err := os.ReadDir(namespaceDir(RunnerHome, namespace))
+	require.Nil(t, err)
+	assert.Empty(t, entries)
+
+	j, err = StartJobContext(context.Background(), JobConfig{Command: "true", Namespace: namespace})
+	require.Nil(t, err)
+	j.Wait()
+	assertStatus(t, j, StatusCompleted, 0)
+}
+
+// TestReexecNotRegistered tests that jobs can't be started before RegisterReexec is called
+func TestReexecNotRegistered(t *testing.T) {
+	// not parallel since the registration applies to all jobs
+	atomic.StoreInt32(&reexecRegistered, 0)
+	defer atomic.StoreInt32(&reexecRegistered, 1)
+
+	j, err := StartJob(JobConfig{Command: "true"})
+	assert.Nil(t, j)
+	assert.ErrorIs(t, err, ErrReexecNotRegistered)
+}
+
+// TestCgroupParent tests the validation of the cgroup parent
+func TestCgroupParent(t *testing.T) {
+	testCases := []struct {
+		name   string // test case name
+		parent string // cgroup parent
+		nilErr bool   // nil error from validateCgroupParent?
+	}{
+		{name: "simple", parent: "runner", nilErr: true},
+		{name: "nested", parent: "runner.slice/runner", nilErr: true},
+		{name: "empty", parent: "", nilErr: false},
+		{name: "absolute", parent: "/runner", nilErr: false},
+		{name: "unclean", parent: "runner//jobs/", nilErr: false},
+		{name: "outside the hierarchy", parent: "../runner", nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.nilErr, validateCgroupParent(tc.parent) == nil)
+		})
+	}
+
+	// The default cgroup parent is writable by the tests
+	assert.Nil(t, ValidateCgroupParent())
+}
+
+// TestStatusChange tests that the status change hook is invoked for every status transition
+func TestStatusChange(t *testing.T) {
+	testCases := []struct {
+		name     string        // test case name
+		command  string        // command to run
+		timeout  time.Duration // timeout
+		stop     bool          // stop the job?
+		statuses []JobStatus   // expected status transitions
+	}{
+		{
+			name:     "completed",
+			command:  "echo 123",
+			statuses: []JobStatus{StatusRunning, StatusCompleted},
+		},
+		{
+			name:     "stopped",
+			command:  "sleep 3600", // go test should timeout in case of failure
+			stop:     true,
+			statuses: []JobStatus{StatusRunning, StatusStopped},
+		},
+		{
+			name:     "timed out",
+			command:  "sleep 3600", // go test should timeout in case of failure
+			timeout:  time.Second,
+			statuses: []JobStatus{StatusRunning, StatusTimedOut},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			lock := sync.Mutex{}
+			statuses := make([]JobStatus, 0)
+			c := JobConfig{
+				Command: tc.command,
+				Timeout: tc.timeout,
+				OnStatusChange: func(id string, status JobStatus) {
+					assert.NotEmpty(t, id)
+
+					lock.Lock()
+					defer lock.Unlock()
+					statuses = append(statuses, status)
+				},
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+
+			if tc.stop {
+				j.Stop()
+			}
+			j.Wait()
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, tc.statuses, statuses)
+		})
+	}
+}
+
+// TestFileModes tests the permissions of the files created for a job
+func TestFileModes(t *testing.T) {
+	testCases := []struct {
+		name     string // test case name
+		command  string // command to run
+		compress bool   // compress output?
+	}{
+		{
+			name:    "uncompressed",
+			command: "echo 123",
+		},
+		{
+			name:     "compressed",
+			command:  "echo 123",
+			compress: true,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command:        tc.command,
+				CompressOutput: tc.compress,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+			j.Wait()
+
+			fi, err := os.Stat(j.(*job).dir)
+			require.Nil(t, err)
+			assert.Equal(t, JobDirMode, fi.Mode().Perm())
+
+			outFile := j.(*job).outFile
+			if tc.compress {
+				outFile = j.(*job).compressedOutFile()
+			}
+			fi, err = os.Stat(outFile)
+			require.Nil(t, err)
+			assert.Equal(t, OutputFileMode, fi.Mode().Perm())
+		})
+	}
+}
+
+// TestNamespace tests grouping the files of jobs in a namespace
+func TestNamespace(t *testing.T) {
+	testCases := []struct {
+		name      string // test case name
+		command   string // command to run
+		namespace string // namespace for the job
+		nilErr    bool   // nil error from StartJob?
+	}{
+		{
+			name:      "valid namespace",
+			command:   "echo 123",
+			namespace: "tenant1",
+			nilErr:    true,
+		},
+		{
+			name:      "relative namespace",
+			command:   "echo 123",
+			namespace: "../tenant1",
+			nilErr:    false,
+		},
+		{
+			name:      "nested namespace",
+			command:   "echo 123",
+			namespace: "tenant1/nested",
+			nilErr:    false,
+		},
+		{
+			name:      "parent namespace",
+			command:   "echo 123",
+			namespace: "..",
+			nilErr:    false,
+		},
+		{
+			name:      "namespace named after the root filesystem source",
+			command:   "echo 123",
+			namespace: filepath.Base(RootFSSource),
+			nilErr:    true,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command:   tc.command,
+				Namespace: tc.namespace,
+			}
+			j, err := StartJob(c)
+			require.Equal(t, tc.nilErr, err == nil)
+			if j == nil {
+				return
+			}
+			j.Wait()
+
+			assert.Equal(t, filepath.Join(RunnerHome, "jobs", tc.namespace, j.ID()), j.(*job).dir)
+			assertOutput(t, j, "123\n")
+
+			usage, err := DiskUsage(tc.namespace)
+			require.Nil(t, err)
+			assert.GreaterOrEqual(t, usage, int64(len("123\n")))
+		})
+	}
+}
+
+// TestOnOutput tests that the output hook accounts for all the output of a job
+func TestOnOutput(t *testing.T) {
+	testCases := []struct {
+		name    string // test case name
+		command string // command to run
+		output  string // output
+	}{
+		{
+			name:    "stdout and stderr",
+			command: "echo abc && sleep 0.5 && echo xyz >&2",
+			output:  "abc\nxyz\n",
+		},
+		{
+			name:    "no output",
+			command: "true",
+			output:  "",
+		},
 	}
 	for _, tc := range testCases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
+			var total int64
 			c := JobConfig{
 				Command: tc.command,
+				OnOutput: func(id string, n int) {
+					atomic.AddInt64(&total, int64(n))
+				},
 			}
 			j, err := StartJob(c)
-			require.Equal(t, tc.nilErr, err == nil)
-			require.Equal(t, tc.nilJob, j == nil)
-
-			if j != nil {
-				// let the job finish
-				j.Wait()
-
-				// id
-				assert.NotEmpty(t, j.ID())
-
-				// status
-				assertStatus(t, j, tc.status, tc.exitCode)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+			j.Wait()
 
-				// output
-				assertOutput(t, j, tc.output)
-			}
+			assertOutput(t, j, tc.output)
+			assert.Equal(t, int64(len(tc.output)), atomic.LoadInt64(&total))
 		})
 	}
 }
 
-// TestTimeout tests timeout expiration for jobs
-func TestTimeout(t *testing.T) {
+// TestAlive tests the liveness check of a job whose process is killed out of band
+func TestAlive(t *testing.T) {
 	testCases := []struct {
-		name    string        // test case name
-		command string        // command to run
-		timeout time.Duration // timeout
-		output  string        // output
+		name    string // test case name
+		command string // command to run
 	}{
 		{
-			name:    "1s timeout",
-			command: "echo 123 && sleep 5 && echo 456",
-			timeout: time.Second,
-			output:  "123\n",
-		},
-		{
-			name:    "3s timeout",
-			command: "for i in $(seq 1 10); do echo iteration $i; sleep 1; done",
-			timeout: 3 * time.Second,
-			output:  "iteration 1\niteration 2\niteration 3\n",
-		},
-		{
-			name:    "5s timeout",
-			command: "for i in $(seq 1 10); do echo iteration $i; sleep 1; done",
-			timeout: 5 * time.Second,
-			output:  "iteration 1\niteration 2\niteration 3\niteration 4\niteration 5\n",
+			name:    "killed out of band",
+			command: "sleep 3600", // go test should timeout in case of failure
 		},
 	}
 	for _, tc := range testCases {
@@ -153,24 +2234,123 @@ func TestTimeout(t *testing.T) {
 
 			c := JobConfig{
 				Command: tc.command,
-				Timeout: tc.timeout,
 			}
 			j, err := StartJob(c)
 			require.NotNil(t, j)
 			require.Nil(t, err)
+			defer j.Stop()
 
-			// let the job time out
-			j.Wait()
+			assert.True(t, j.Alive())
 
-			// status
-			assertStatus(t, j, StatusTimedOut, -1)
+			// kill the process out of band
+			err = syscall.Kill(j.(*job).cmd.Process.Pid, syscall.SIGKILL)
+			require.Nil(t, err)
 
-			// output
-			assertOutput(t, j, tc.output)
+			// status must converge to a terminal state
+			select {
+			case <-j.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s didn't reach a terminal state", j.ID())
+			}
+			assert.False(t, j.Alive())
+			assertStatus(t, j, StatusKilled, -1)
 		})
 	}
 }
 
+// TestPID tests that the PID of a job is the PID of its process while it's running or paused
+func TestPID(t *testing.T) {
+	j, err := StartJob(JobConfig{Command: "sleep 3600"})
+	require.Nil(t, err)
+	defer j.Stop()
+
+	pid := j.PID()
+	require.NotZero(t, pid)
+	assert.True(t, processAlive(pid))
+
+	require.Nil(t, j.Pause())
+	assert.Equal(t, pid, j.PID())
+	require.Nil(t, j.Resume())
+
+	j.Stop()
+	assert.Zero(t, j.PID())
+
+	// a scheduled job has no process yet
+	scheduled, err := StartJob(JobConfig{Command: "true", StartAt: time.Now().Add(time.Hour)})
+	require.Nil(t, err)
+	defer scheduled.Stop()
+	assert.Zero(t, scheduled.PID())
+}
+
+// TestLeakedProcesses tests that stopping a job kills a process that escaped its process group,
+// as the process is still in the PID namespace of the job
+func TestLeakedProcesses(t *testing.T) {
+	// ash redirects the stdin of background commands from /dev/null, which isn't in the root
+	// filesystem
+	j, err := StartJob(JobConfig{
+		Command: "setsid sleep 3600 & sleep 3600",
+		Mounts:  []Mount{{Source: "/dev/null", Target: "/dev/null"}},
+	})
+	require.Nil(t, err)
+	defer j.Stop()
+
+	// the handler and both the sleeps, ash execs the last sleep in place of the shell
+	ns := j.(*job).pidNS
+	require.NotEmpty(t, ns)
+	var pids []int
+	require.Eventually(t, func() bool {
+		pids, err = namespaceProcesses(ns)
+		return err == nil && len(pids) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the sleep run by setsid isn't reached by killing the process group of the job
+	var escaped []int
+	for _, pid := range pids {
+		if pgid, err := syscall.Getpgid(pid); err == nil && pgid != j.PID() {
+			escaped = append(escaped, pid)
+		}
+	}
+	require.Len(t, escaped, 1)
+
+	j.Stop()
+	assertStatus(t, j, StatusStopped, -1)
+	for _, pid := range pids {
+		assert.False(t, processAlive(pid), "process %d outlived the job", pid)
+	}
+	assert.Empty(t, j.LeakedPIDs())
+}
+
+// TestSignal tests that signals sent to a job reach its command without taking down the job
+func TestSignal(t *testing.T) {
+	j, err := StartJob(JobConfig{Command: "trap 'echo hup' HUP; while true; do sleep 0.1; done"})
+	require.Nil(t, err)
+	defer j.Stop()
+
+	// give the shell time to set up the trap
+	time.Sleep(500 * time.Millisecond)
+	require.Nil(t, j.Signal(syscall.SIGHUP))
+	// the signal reaches the whole process group, ash reports the foreground sleep it kills too
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(j.(*job).outFile)
+		return err == nil && strings.Contains(string(data), "hup\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	assertStatus(t, j, StatusRunning, -1)
+
+	// signals that kill or stop a job are left to Stop and Pause
+	for _, sig := range []syscall.Signal{syscall.SIGKILL, syscall.SIGSTOP, syscall.Signal(0)} {
+		assert.True(t, errors.Is(j.Signal(sig), ErrInvalidSignal), sig)
+	}
+
+	// the command acts on the signals without a trap
+	require.Nil(t, j.Signal(syscall.SIGTERM))
+	select {
+	case <-j.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s didn't reach a terminal state", j.ID())
+	}
+	assert.True(t, errors.Is(j.Signal(syscall.SIGHUP), ErrNotRunning))
+}
+
 // TestStop tests stopping a job
 func TestStop(t *testing.T) {
 	testCases := []struct {
@@ -222,6 +2402,52 @@ func TestStop(t *testing.T) {
 	}
 }
 
+// TestStopAsync tests stopping multiple jobs without waiting for them to finish
+func TestStopAsync(t *testing.T) {
+	testCases := []struct {
+		name    string // test case name
+		command string // command to run
+		numJobs int    // number of jobs to stop
+	}{
+		{
+			name:    "10 jobs",
+			command: "for i in $(seq 1 10); do echo iteration $i; sleep 1; done",
+			numJobs: 10,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jobs := make([]Job, 0, tc.numJobs)
+			for i := 0; i < tc.numJobs; i++ {
+				j, err := StartJob(JobConfig{
+					Command: tc.command,
+				})
+				require.NotNil(t, j)
+				require.Nil(t, err)
+				jobs = append(jobs, j)
+			}
+
+			for _, j := range jobs {
+				j.StopAsync()
+			}
+
+			for _, j := range jobs {
+				select {
+				case <-j.Done():
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%s didn't stop in time", j.ID())
+				}
+
+				// status
+				assertStatus(t, j, StatusStopped, -1)
+			}
+		})
+	}
+}
+
 // TestConcurrentOutput tests streaming output from 1 job to multiple clients
 func TestConcurrentOutput(t *testing.T) {
 	testCases := []struct {
@@ -686,6 +2912,407 @@ func TestHostNameIsolation(t *testing.T) {
 	}
 }
 
+// TestHostname tests that the hostname of a job is the configured hostname or the job ID
+func TestHostname(t *testing.T) {
+	testCases := []struct {
+		name     string // test case name
+		hostname string // configured hostname
+		nilErr   bool   // nil error from StartJob?
+	}{
+		{name: "configured", hostname: "builder", nilErr: true},
+		{name: "default", hostname: "", nilErr: true},
+		{name: "too long", hostname: strings.Repeat("a", 65), nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			j, err := StartJob(JobConfig{Command: "hostname", Hostname: tc.hostname})
+			if !tc.nilErr {
+				assert.Nil(t, j)
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			j.Wait()
+
+			hostname := tc.hostname
+			if hostname == "" {
+				hostname = j.ID()
+			}
+			assertOutput(t, j, hostname+"\n")
+			assertStatus(t, j, StatusCompleted, 0)
+		})
+	}
+}
+
+// TestEnv tests the environment variables of a job
+func TestEnv(t *testing.T) {
+	testCases := []struct {
+		name   string   // test case name
+		env    []string // environment variables of the job
+		output string   // expected output of the job
+		nilErr bool     // nil error from StartJob?
+	}{
+		{name: "variables", env: []string{"FOO=bar", "EMPTY="}, output: "bar,\n", nilErr: true},
+		{name: "last wins", env: []string{"FOO=bar", "FOO=baz"}, output: "baz,\n", nilErr: true},
+		{name: "no value", env: []string{"FOO"}, nilErr: false},
+		{name: "no key", env: []string{"=bar"}, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			j, err := StartJob(JobConfig{Command: `echo "$FOO,$EMPTY"`, Env: tc.env})
+			if !tc.nilErr {
+				assert.Nil(t, j)
+				assert.ErrorIs(t, err, ErrInvalidEnv)
+				return
+			}
+			require.Nil(t, err)
+			j.Wait()
+			assertOutput(t, j, tc.output)
+			assertStatus(t, j, StatusCompleted, 0)
+		})
+	}
+}
+
+// TestOutputPath tests storing the output of a job at a configured path
+func TestOutputPath(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name   string // test case name
+		path   string // output path of the job
+		nilErr bool   // nil error from StartJob?
+	}{
+		{name: "existing directory", path: filepath.Join(dir, "out.log"), nilErr: true},
+		{name: "missing directory", path: filepath.Join(dir, "logs", "nested", "out.log"), nilErr: true},
+		{name: "relative", path: "logs/out.log", nilErr: false},
+		{name: "directory", path: dir, nilErr: false},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			j, err := StartJob(JobConfig{Command: "echo 123", OutputPath: tc.path})
+			if !tc.nilErr {
+				assert.Nil(t, j)
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			j.Wait()
+			assertOutput(t, j, "123\n")
+
+			data, err := os.ReadFile(tc.path)
+			require.Nil(t, err)
+			assert.Equal(t, "123\n", string(data))
+			assert.NoFileExists(t, filepath.Join(j.(*job).dir, "output.log"))
+
+			// the output file is left for the tooling that owns the path
+			require.Nil(t, j.RemoveFiles())
+			assert.FileExists(t, tc.path)
+		})
+	}
+
+	// compressing would replace the output file with a .gz file the tooling doesn't know about
+	j, err := StartJob(JobConfig{Command: "echo 123", OutputPath: filepath.Join(dir, "compressed.log"), CompressOutput: true})
+	assert.Nil(t, j)
+	assert.NotNil(t, err)
+}
+
+func TestAppendOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	require.Nil(t, os.WriteFile(path, []byte("previous\n"), 0600))
+
+	// the output of every job is appended to the output file
+	for _, command := range []string{"echo 123", "echo 456"} {
+		j, err := StartJob(JobConfig{Command: command, OutputPath: path, AppendOutput: true})
+		require.Nil(t, err)
+		j.Wait()
+		require.Nil(t, j.RemoveFiles())
+	}
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, "previous\n123\n456\n", string(data))
+
+	// the output is truncated without AppendOutput
+	j, err := StartJob(JobConfig{Command: "echo 789", OutputPath: path})
+	require.Nil(t, err)
+	j.Wait()
+	assertOutput(t, j, "789\n")
+
+	// there's no existing output file to append to without OutputPath
+	_, err = StartJob(JobConfig{Command: "echo 123", AppendOutput: true})
+	assert.NotNil(t, err)
+	_, err = StartJob(JobConfig{Command: "echo 123", OutputPath: path, AppendOutput: true, CompressOutput: true})
+	assert.NotNil(t, err)
+}
+
+// TestOutputComplete tests that the output is complete once the output writer is done
+func TestOutputComplete(t *testing.T) {
+	testCases := []struct {
+		name    string // test case name
+		discard bool   // discard the output of the job?
+	}{
+		{name: "stored", discard: false},
+		{name: "discarded", discard: true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			j, err := StartJob(JobConfig{Command: "echo 123; sleep 1", DiscardOutput: tc.discard})
+			require.Nil(t, err)
+			assert.Equal(t, tc.discard, j.OutputComplete())
+
+			j.Wait()
+			assert.True(t, j.OutputComplete())
+		})
+	}
+}
+
+// TestDone tests that the Done channel is closed once the job reaches a terminal state
+func TestDone(t *testing.T) {
+	testCases := []struct {
+		name     string        // test case name
+		command  string        // command to run
+		waitDur  time.Duration // how long to wait for the Done channel
+		done     bool          // should Done be closed before waitDur expires?
+		status   JobStatus     // status after waiting
+		exitCode int           // exit code
+	}{
+		{
+			name:     "completed job",
+			command:  "echo 123",
+			waitDur:  5 * time.Second,
+			done:     true,
+			status:   StatusCompleted,
+			exitCode: 0,
+		},
+		{
+			name:     "running job",
+			command:  "sleep 3600", // go test should timeout in case of failure
+			waitDur:  time.Second,
+			done:     false,
+			status:   StatusRunning,
+			exitCode: -1,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := JobConfig{
+				Command: tc.command,
+			}
+			j, err := StartJob(c)
+			require.NotNil(t, j)
+			require.Nil(t, err)
+			defer j.Stop()
+
+			select {
+			case <-j.Done():
+				assert.True(t, tc.done, "Done closed unexpectedly")
+			case <-time.After(tc.waitDur):
+				assert.False(t, tc.done, "Done not closed before timeout")
+			}
+
+			// status
+			assertStatus(t, j, tc.status, tc.exitCode)
+
+			// Done must be closed once the job is stopped
+			j.Stop()
+			<-j.Done()
+		})
+	}
+}
+
+// TestDiagnoseStartError tests detection of common host misconfigurations when a job can't be started
+func TestDiagnoseStartError(t *testing.T) {
+	testCases := []struct {
+		name       string            // test case name
+		err        error             // error returned when starting the job
+		procFiles  map[string]string // files in the proc filesystem
+		setupError bool              // is a SetupError expected?
+	}{
+		{
+			name:       "proc not mounted",
+			err:        syscall.EPERM,
+			procFiles:  map[string]string{},
+			setupError: true,
+		},
+		{
+			name: "unprivileged user namespaces disabled",
+			err:  syscall.EPERM,
+			procFiles: map[string]string{
+				"self/stat":                            "",
+				"sys/kernel/unprivileged_userns_clone": "0\n",
+			},
+			setupError: true,
+		},
+		{
+			name: "user namespaces disabled",
+			err:  syscall.ENOSPC,
+			procFiles: map[string]string{
+				"self/stat":                    "",
+				"sys/user/max_user_namespaces": "0\n",
+			},
+			setupError: true,
+		},
+		{
+			name: "user namespaces enabled",
+			err:  syscall.EPERM,
+			procFiles: map[string]string{
+				"self/stat":                            "",
+				"sys/kernel/unprivileged_userns_clone": "1\n",
+				"sys/user/max_user_namespaces":         "15000\n",
+			},
+			setupError: false,
+		},
+		{
+			name: "unrelated error",
+			err:  syscall.ENOENT,
+			procFiles: map[string]string{
+				"self/stat":                            "",
+				"sys/kernel/unprivileged_userns_clone": "0\n",
+			},
+			setupError: false,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			procRoot := t.TempDir()
+			for name, data := range tc.procFiles {
+				path := filepath.Join(procRoot, name)
+				require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.Nil(t, os.WriteFile(path, []byte(data), 0644))
+			}
+
+			err := diagnoseStartError(tc.err, procRoot)
+			var setupErr *SetupError
+			assert.Equal(t, tc.setupError, errors.As(err, &setupErr))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+// BenchmarkStartJob measures starting a job and waiting for it, which is dominated by copying the
+// root filesystem and setting up the namespaces of the job
+func BenchmarkStartJob(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		runBenchmarkJob(b, JobConfig{Command: "true"})
+	}
+}
+
+// BenchmarkConcurrentStartJob measures starting jobs concurrently, which adds the contention on
+// the shared state of the library, e.g. the profile registry and RunnerHome
+func BenchmarkConcurrentStartJob(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			runBenchmarkJob(b, JobConfig{Command: "true"})
+		}
+	})
+}
+
+// BenchmarkOutput measures the throughput of streaming the output of a job while it's written
+func BenchmarkOutput(b *testing.B) {
+	const size = 64 << 20
+	b.SetBytes(size)
+
+	for i := 0; i < b.N; i++ {
+		// the root filesystem has no /dev/zero
+		j, err := StartJob(JobConfig{Command: fmt.Sprintf("yes | head -c %d", size)})
+		require.Nil(b, err)
+
+		out, cancel, err := j.Output()
+		require.Nil(b, err)
+		var n int
+		for o := range out {
+			n += len(o.Bytes)
+		}
+		cancel()
+		require.Equal(b, size, n)
+
+		j.Wait()
+		require.Nil(b, j.RemoveFiles())
+	}
+}
+
+// BenchmarkOutputSlowConsumer streams the output of a fast producer to a consumer that pauses
+// every so often, with different sizes of the output buffer
+func BenchmarkOutputSlowConsumer(b *testing.B) {
+	const size = 16 << 20
+	defer func(n int) { OutputBufferSize = n }(OutputBufferSize)
+
+	for _, n := range []int{0, 16, 256, MaxOutputBufferSize} {
+		b.Run(fmt.Sprintf("buffer=%d", n), func(b *testing.B) {
+			OutputBufferSize = n
+			b.SetBytes(size)
+
+			for i := 0; i < b.N; i++ {
+				j, err := StartJob(JobConfig{Command: fmt.Sprintf("yes | head -c %d", size)})
+				require.Nil(b, err)
+
+				out, cancel, err := j.Output()
+				require.Nil(b, err)
+				var n, chunks int
+				for o := range out {
+					n += len(o.Bytes)
+					if chunks++; chunks%512 == 0 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+				cancel()
+				require.Equal(b, size, n)
+
+				j.Wait()
+				require.Nil(b, j.RemoveFiles())
+			}
+		})
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	defer func(n int) { OutputBufferSize = n }(OutputBufferSize)
+
+	for _, tc := range []struct{ size, expected int }{
+		{-1, 0}, {0, 0}, {16, 16}, {MaxOutputBufferSize + 1, MaxOutputBufferSize},
+	} {
+		OutputBufferSize = tc.size
+		assert.Equal(t, tc.expected, outputBuffer())
+	}
+
+	OutputBufferSize = 16
+	j, err := StartJob(JobConfig{Command: "seq 1 10000"})
+	require.Nil(t, err)
+	j.Wait()
+	var expected strings.Builder
+	for i := 1; i <= 10000; i++ {
+		fmt.Fprintf(&expected, "%d\n", i)
+	}
+	assertOutput(t, j, expected.String())
+	require.Nil(t, j.RemoveFiles())
+}
+
+// runBenchmarkJob starts a job, waits for it and removes its files. Failures are reported with
+// Error, as it's also run by the goroutines of RunParallel.
+func runBenchmarkJob(b *testing.B, config JobConfig) {
+	j, err := StartJob(config)
+	if err != nil {
+		b.Error(err)
+		return
+	}
+	j.Wait()
+	if err := j.RemoveFiles(); err != nil {
+		b.Error(err)
+	}
+}
+
 // assertOutput is a convenience function to verify a job's output
 func assertOutput(t *testing.T, j Job, expected string) {
 	assert.Equal(t, expected, getOutput(t, j))
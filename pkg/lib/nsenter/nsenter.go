@@ -0,0 +1,20 @@
+// package nsenter enters the namespaces of a job before the Go runtime starts. Mount and user
+// namespaces can only be entered by single threaded processes, which a Go program never is once
+// the runtime is up, so the namespaces are entered from a C constructor instead.
+//
+// The constructor only does anything when _RUNNER_NSENTER_FDS is set to a comma separated list of
+// file descriptors of namespaces, which are entered in that order and closed. The process then
+// forks, the child continues into the Go runtime inside the namespaces and the parent exits with
+// the exit status of the child. If _RUNNER_NSENTER_SYNC_FD is set, the process reads a byte from
+// that file descriptor before it forks, so the caller can e.g. move it to a cgroup first.
+//
+// The constructor needs cgo, which makes the binaries importing the package dynamically linked.
+// When the package is built with CGO_ENABLED=0 the constructor is left out and Available is false,
+// the namespaces of a job can't be entered then.
+package nsenter
+
+// EnvFDs is the environment variable holding the file descriptors of the namespaces to enter
+const EnvFDs = "_RUNNER_NSENTER_FDS"
+
+// EnvSyncFD is the environment variable holding the file descriptor read before the process forks
+const EnvSyncFD = "_RUNNER_NSENTER_SYNC_FD"
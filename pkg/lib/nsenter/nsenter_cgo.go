@@ -0,0 +1,95 @@
+//go:build cgo
+// +build cgo
+
+package nsenter
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <sched.h>
+#include <signal.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+// runner_sync waits for the caller to write a byte to the fd in _RUNNER_NSENTER_SYNC_FD, if it's set
+static void runner_sync(void) {
+	const char *sync = getenv("_RUNNER_NSENTER_SYNC_FD");
+	if (sync == NULL || *sync == '\0') {
+		return;
+	}
+	char *end;
+	long fd = strtol(sync, &end, 10);
+	if (end == sync || *end != '\0') {
+		fprintf(stderr, "invalid sync fd %s\n", sync);
+		exit(1);
+	}
+	char c;
+	if (read((int)fd, &c, 1) != 1) {
+		exit(1);
+	}
+	close((int)fd);
+	unsetenv("_RUNNER_NSENTER_SYNC_FD");
+}
+
+__attribute__((constructor)) static void runner_nsenter(void) {
+	const char *fds = getenv("_RUNNER_NSENTER_FDS");
+	if (fds == NULL || *fds == '\0') {
+		return;
+	}
+
+	const char *p = fds;
+	while (*p != '\0') {
+		char *end;
+		long fd = strtol(p, &end, 10);
+		if (end == p || (*end != ',' && *end != '\0')) {
+			fprintf(stderr, "invalid namespace fds %s\n", fds);
+			exit(1);
+		}
+		if (setns((int)fd, 0) < 0) {
+			perror("failed to enter namespace");
+			exit(1);
+		}
+		close((int)fd);
+		p = *end == ',' ? end + 1 : end;
+	}
+
+	// processes started by the current process must not enter the namespaces again
+	unsetenv("_RUNNER_NSENTER_FDS");
+
+	// The process must be in the cgroup of the job before it forks, the child inherits it
+	runner_sync();
+
+	// Entering a PID namespace only applies to the children of the process, and the Go runtime
+	// can't create its threads while pid_ns_for_children differs from the PID namespace of the
+	// process. The child continues into Go in the namespace, the parent passes its exit status
+	// through.
+	pid_t pid = fork();
+	if (pid < 0) {
+		perror("failed to fork");
+		exit(1);
+	}
+	if (pid == 0) {
+		return;
+	}
+
+	int status;
+	while (waitpid(pid, &status, 0) < 0) {
+		if (errno != EINTR) {
+			perror("failed to wait");
+			_exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		signal(WTERMSIG(status), SIG_DFL);
+		kill(getpid(), WTERMSIG(status));
+		_exit(128 + WTERMSIG(status));
+	}
+	_exit(WEXITSTATUS(status));
+}
+*/
+import "C"
+
+// Available is true when the namespaces are entered by the constructor
+const Available = true
@@ -0,0 +1,7 @@
+//go:build !cgo
+// +build !cgo
+
+package nsenter
+
+// Available is false, the constructor needs cgo
+const Available = false
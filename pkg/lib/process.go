@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// setNice sets the nice level of all the threads of the process with pid. Threads and processes
+// created by the process afterwards inherit the nice level.
+func setNice(pid, nice int) error {
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// PRIO_PROCESS applies to a single thread on Linux
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processAlive checks whether the process with pid is alive. A process that has exited but isn't
+// reaped yet (zombie) isn't alive.
+func processAlive(pid int) bool {
+	// Signal 0 performs the error checking without sending any signal
+	if err := syscall.Kill(pid, 0); err != nil {
+		return errors.Is(err, syscall.EPERM)
+	}
+
+	state, err := processState(pid)
+	if err != nil {
+		return false
+	}
+	return state != 'Z' && state != 'X'
+}
+
+// processState returns the state of the process with pid from /proc/<pid>/stat
+func processState(pid int) (byte, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name is enclosed in parentheses and may contain spaces or parentheses, the state
+	// follows the last closing parenthesis
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 || i+2 >= len(data) {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	return data[i+2], nil
+}
+
+// namespaceProcesses returns the PIDs of the live processes in the PID namespace ns, as read from
+// /proc/<pid>/ns/pid, e.g. pid:[4026532277]
+func namespaceProcesses(ns string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// the process may have exited since /proc was read
+		link, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+		if err != nil || link != ns || !processAlive(pid) {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownProfile is returned by StartJob when the resource profile of the job isn't registered
+var ErrUnknownProfile = errors.New("unknown resource profile")
+
+// ResourceLimits represents the limits applied to the resources used by a job. A zero value for
+// a limit means unlimited.
+type ResourceLimits struct {
+	CPUs      float64 // Maximum number of CPUs the job can use, e.g. 0.5 for half a CPU
+	MemoryMax int64   // Maximum memory the job can use in bytes
+	PidsMax   int64   // Maximum number of tasks in the job, including the threads of the runner
+
+	// CPUSet pins the job to the CPUs of the set, in the list format of cpuset(7), e.g. 0-3,6. The
+	// job can run on all the CPUs of the runner if it's empty.
+	CPUSet string
+
+	// Rlimits are the rlimits of the processes of the job, they don't need a cgroup
+	Rlimits Rlimits
+}
+
+// profileRegistry maps the resource profiles to their resource limits
+type profileRegistry struct {
+	profiles map[ResProfile]ResourceLimits
+	loaded   map[ResProfile]bool // profiles loaded from the profile file by LoadProfiles
+	sync.RWMutex
+}
+
+var profiles = profileRegistry{
+	profiles: map[ResProfile]ResourceLimits{
+		ResProfileDefault: {},
+	},
+}
+
+// RegisterProfile registers the resource limits for a resource profile, replacing the existing
+// resource limits of the profile if any
+func RegisterProfile(profile ResProfile, limits ResourceLimits) error {
+	if profile == "" {
+		return errors.New("profile name is empty")
+	}
+	if limits.CPUs < 0 || limits.MemoryMax < 0 || limits.PidsMax < 0 {
+		return fmt.Errorf("negative resource limits for profile %s", profile)
+	}
+	if limits.CPUSet != "" {
+		if _, err := parseCPUSet(limits.CPUSet); err != nil {
+			return fmt.Errorf("invalid CPU set for profile %s: %w", profile, err)
+		}
+	}
+	if err := limits.Rlimits.validate(); err != nil {
+		return fmt.Errorf("invalid rlimits for profile %s: %w", profile, err)
+	}
+
+	profiles.Lock()
+	defer profiles.Unlock()
+
+	profiles.profiles[profile] = limits
+	delete(profiles.loaded, profile)
+	return nil
+}
+
+// lookupProfile returns the resource limits for a resource profile. ResProfileDefault is used if
+// the profile is empty.
+func lookupProfile(profile ResProfile) (ResourceLimits, error) {
+	if profile == "" {
+		profile = ResProfileDefault
+	}
+
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	limits, ok := profiles.profiles[profile]
+	if !ok {
+		return ResourceLimits{}, fmt.Errorf("%w: %s", ErrUnknownProfile, profile)
+	}
+	return limits, nil
+}
+
+// Profiles returns the registered resource profiles and their resource limits
+func Profiles() map[ResProfile]ResourceLimits {
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	registered := make(map[ResProfile]ResourceLimits, len(profiles.profiles))
+	for profile, limits := range profiles.profiles {
+		registered[profile] = limits
+	}
+	return registered
+}
+
+// ValidProfiles returns the names of the registered resource profiles in sorted order
+func ValidProfiles() []ResProfile {
+	profiles.RLock()
+	defer profiles.RUnlock()
+
+	names := make([]ResProfile, 0, len(profiles.profiles))
+	for profile := range profiles.profiles {
+		names = append(names, profile)
+	}
+	sort.Slice(names, func(i, k int) bool {
+		return names[i] < names[k]
+	})
+	return names
+}
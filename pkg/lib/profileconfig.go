@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileConfig represents the content of a profile file in JSON or YAML. A profile may extend
+// another profile, either from the same file or one registered with RegisterProfile, and override
+// some of its limits, e.g.
+//
+//	profiles:
+//	  small:
+//	    cpus: 0.5
+//	    memory_max: 268435456
+//	    pids_max: 64
+//	    rlimits:
+//	      nofile: 1024
+//	      core: 0
+//	  large:
+//	    extends: small
+//	    cpus: 2
+//	    cpuset: 0-3
+type profileConfig struct {
+	Profiles map[ResProfile]profileDef `json:"profiles" yaml:"profiles"`
+}
+
+// profileDef is the definition of a profile in a profile file. The limits that aren't set are
+// inherited from the extended profile, or are unlimited if the profile doesn't extend any.
+type profileDef struct {
+	Extends   ResProfile `json:"extends" yaml:"extends"`
+	CPUs      *float64   `json:"cpus" yaml:"cpus"`
+	MemoryMax *int64     `json:"memory_max" yaml:"memory_max"`
+	PidsMax   *int64     `json:"pids_max" yaml:"pids_max"`
+	CPUSet    *string    `json:"cpuset" yaml:"cpuset"`
+	Rlimits   Rlimits    `json:"rlimits" yaml:"rlimits"` // the rlimits that aren't set are inherited one by one
+}
+
+// LoadProfiles registers the resource profiles defined in the profile file at path. Files with a
+// .yaml or .yml extension are parsed as YAML, all the other files as JSON. The profiles loaded by
+// a previous call are replaced, so LoadProfiles can be called again to reload the file. The
+// profiles are only registered if all of them are valid, the registered profiles are left as they
+// are otherwise. Jobs that are already started keep the limits they were started with.
+func LoadProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	config := profileConfig{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		err = json.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse profile file %s: %w", path, err)
+	}
+
+	profiles.Lock()
+	defer profiles.Unlock()
+
+	// the profiles of the file may only extend the profiles that aren't loaded from a file
+	registered := make(map[ResProfile]ResourceLimits)
+	for profile, limits := range profiles.profiles {
+		if !profiles.loaded[profile] {
+			registered[profile] = limits
+		}
+	}
+	resolved, err := resolveProfiles(config.Profiles, registered)
+	if err != nil {
+		return fmt.Errorf("invalid profile file %s: %w", path, err)
+	}
+
+	for profile := range profiles.loaded {
+		delete(profiles.profiles, profile)
+	}
+	profiles.loaded = make(map[ResProfile]bool)
+	for profile, limits := range resolved {
+		profiles.profiles[profile] = limits
+		profiles.loaded[profile] = true
+	}
+	return nil
+}
+
+// resolveProfiles resolves the inheritance of the profile definitions defs into the resource
+// limits of each profile. Profiles that aren't in defs are looked up in registered.
+func resolveProfiles(defs map[ResProfile]profileDef, registered map[ResProfile]ResourceLimits) (map[ResProfile]ResourceLimits, error) {
+	resolved := make(map[ResProfile]ResourceLimits)
+
+	// visiting holds the chain of profiles being resolved, to detect cycles
+	var visiting []ResProfile
+	var resolve func(profile ResProfile) (ResourceLimits, error)
+	resolve = func(profile ResProfile) (ResourceLimits, error) {
+		if limits, ok := resolved[profile]; ok {
+			return limits, nil
+		}
+		def, ok := defs[profile]
+		if !ok {
+			limits, ok := registered[profile]
+			if !ok {
+				return ResourceLimits{}, fmt.Errorf("%w: %s", ErrUnknownProfile, profile)
+			}
+			return limits, nil
+		}
+		for i, p := range visiting {
+			if p == profile {
+				chain := make([]string, 0, len(visiting)-i+1)
+				for _, p := range append(visiting[i:], profile) {
+					chain = append(chain, string(p))
+				}
+				return ResourceLimits{}, fmt.Errorf("profiles extend each other: %s", strings.Join(chain, " -> "))
+			}
+		}
+
+		visiting = append(visiting, profile)
+		defer func() { visiting = visiting[:len(visiting)-1] }()
+
+		var limits ResourceLimits
+		if def.Extends != "" {
+			var err error
+			if limits, err = resolve(def.Extends); err != nil {
+				return ResourceLimits{}, err
+			}
+		}
+		if def.CPUs != nil {
+			limits.CPUs = *def.CPUs
+		}
+		if def.MemoryMax != nil {
+			limits.MemoryMax = *def.MemoryMax
+		}
+		if def.PidsMax != nil {
+			limits.PidsMax = *def.PidsMax
+		}
+		if def.CPUSet != nil {
+			limits.CPUSet = *def.CPUSet
+		}
+		limits.Rlimits = limits.Rlimits.override(def.Rlimits)
+		if limits.CPUs < 0 || limits.MemoryMax < 0 || limits.PidsMax < 0 {
+			return ResourceLimits{}, fmt.Errorf("negative resource limits for profile %s", profile)
+		}
+		if limits.CPUSet != "" {
+			if _, err := parseCPUSet(limits.CPUSet); err != nil {
+				return ResourceLimits{}, fmt.Errorf("invalid CPU set for profile %s: %w", profile, err)
+			}
+		}
+		if err := limits.Rlimits.validate(); err != nil {
+			return ResourceLimits{}, fmt.Errorf("invalid rlimits for profile %s: %w", profile, err)
+		}
+
+		resolved[profile] = limits
+		return limits, nil
+	}
+
+	for profile := range defs {
+		if profile == "" {
+			return nil, errors.New("profile name is empty")
+		}
+		if _, err := resolve(profile); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
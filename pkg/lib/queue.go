@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by StartJob when all the slots of the queue of the job are taken and no
+// more jobs can wait for one
+var ErrQueueFull = errors.New("job queue is full")
+
+// Queue limits the number of jobs that run at the same time. A job started with a Queue takes one
+// of its slots from the time it's launched till it reaches a terminal state. The jobs started when
+// all the slots are taken wait for one in StatusQueued. As slots free up, the jobs with the highest
+// priority are launched first, and jobs with the same priority are launched in the order they were
+// queued in. A Queue is shared by the jobs started with it.
+type Queue struct {
+	limit     int // maximum number of jobs holding a slot
+	maxQueued int // maximum number of jobs waiting for a slot
+
+	// aging is how long a job waits for a slot before its priority is raised by one, so that jobs
+	// with a low priority aren't starved by a steady stream of jobs with a higher priority. 0
+	// means the priority of a job is never raised.
+	aging time.Duration
+
+	lock    sync.Mutex
+	running int          // number of jobs holding a slot
+	waiting []*queuedJob // jobs waiting for a slot, in the order they were queued in
+
+	// avgHold is the moving average of how long the jobs held a slot, 0 till a job frees its slot
+	avgHold time.Duration
+}
+
+// holdAvgWeight is the weight of the previous average in avgHold of a Queue, every job that frees
+// its slot counts for 1/holdAvgWeight of the new average
+const holdAvgWeight = 5
+
+// queuedJob is a job waiting for a slot of a Queue
+type queuedJob struct {
+	j        *job
+	ready    chan struct{} // closed once the job is handed a slot
+	queuedAt time.Time     // when the job started waiting
+}
+
+// NewQueue returns a Queue that runs at most limit jobs at the same time and lets at most
+// maxQueued jobs wait for a slot, a negative maxQueued means unlimited. The priority of a waiting
+// job is raised by one for every aging it waits, 0 means it's never raised.
+func NewQueue(limit int, maxQueued int, aging time.Duration) *Queue {
+	return &Queue{limit: limit, maxQueued: maxQueued, aging: aging}
+}
+
+// Limit returns the maximum number of jobs that run at the same time
+func (q *Queue) Limit() int {
+	return q.limit
+}
+
+// Running returns the number of jobs holding a slot
+func (q *Queue) Running() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.running
+}
+
+// Queued returns the number of jobs waiting for a slot
+func (q *Queue) Queued() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.waiting)
+}
+
+// QueuedByPriority returns the number of jobs waiting for a slot for each priority the jobs were
+// started with
+func (q *Queue) QueuedByPriority() map[int]int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	queued := make(map[int]int)
+	for _, w := range q.waiting {
+		queued[w.j.config.Priority]++
+	}
+	return queued
+}
+
+// acquire hands j a slot right away and returns a nil channel if one is free. The job is queued
+// otherwise, and the returned channel is closed once it's handed a slot.
+func (q *Queue) acquire(j *job) (<-chan struct{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.running < q.limit {
+		q.running++
+		j.slotAt = time.Now()
+		atomic.StoreInt32(&j.holdsSlot, 1)
+		return nil, nil
+	}
+	if q.maxQueued >= 0 && len(q.waiting) >= q.maxQueued {
+		return nil, ErrQueueFull
+	}
+
+	w := &queuedJob{j: j, ready: make(chan struct{}), queuedAt: time.Now()}
+	q.waiting = append(q.waiting, w)
+	return w.ready, nil
+}
+
+// release frees the slot of j, which is handed to the waiting job with the highest priority
+func (q *Queue) release(j *job) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+	if hold := now.Sub(j.slotAt); q.avgHold == 0 {
+		q.avgHold = hold
+	} else {
+		q.avgHold += (hold - q.avgHold) / holdAvgWeight
+	}
+
+	if len(q.waiting) == 0 {
+		q.running--
+		return
+	}
+
+	i := q.next(now)
+	next := q.waiting[i]
+	q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+	next.j.slotAt = now
+	atomic.StoreInt32(&next.j.holdsSlot, 1)
+	close(next.ready)
+}
+
+// Position returns the position of the job with the given ID among the jobs waiting for a slot,
+// starting at 1 for the job that's handed the next slot, and when the job is estimated to be
+// launched. The estimate assumes that every job holds its slot for as long as the recent jobs did
+// on average, it's the zero time till a job frees its slot. ok is false if the job isn't waiting.
+func (q *Queue) Position(id string) (position int, start time.Time, ok bool) {
+	now := time.Now()
+
+	// The jobs are ordered on a copy, so that jobs can be launched in the meantime
+	q.lock.Lock()
+	waiting := make([]queuedJob, 0, len(q.waiting))
+	for _, w := range q.waiting {
+		waiting = append(waiting, *w)
+	}
+	avgHold := q.avgHold
+	q.lock.Unlock()
+
+	// The earliest queued job wins a tie, like in next
+	sort.SliceStable(waiting, func(i, k int) bool {
+		return q.priority(&waiting[i], now) > q.priority(&waiting[k], now)
+	})
+	for i, w := range waiting {
+		if w.j.id != id {
+			continue
+		}
+		position = i + 1
+		if avgHold > 0 {
+			// the job is launched once it's among the first jobs handed the slots that free up
+			rounds := (position + q.limit - 1) / q.limit
+			start = now.Add(time.Duration(rounds) * avgHold)
+		}
+		return position, start, true
+	}
+	return 0, time.Time{}, false
+}
+
+// next returns the index of the waiting job with the highest priority at now. The earliest queued
+// job wins a tie, as waiting is in the order the jobs were queued in.
+func (q *Queue) next(now time.Time) int {
+	best := 0
+	for i := 1; i < len(q.waiting); i++ {
+		if q.priority(q.waiting[i], now) > q.priority(q.waiting[best], now) {
+			best = i
+		}
+	}
+	return best
+}
+
+// priority returns the priority of a waiting job at now, raised by one for every aging it waited
+func (q *Queue) priority(w *queuedJob, now time.Time) int {
+	p := w.j.config.Priority
+	if q.aging > 0 {
+		p += int(now.Sub(w.queuedAt) / q.aging)
+	}
+	return p
+}
+
+// remove removes j from the jobs waiting for a slot, if it's still waiting
+func (q *Queue) remove(j *job) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, w := range q.waiting {
+		if w.j == j {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// MinNoFile is the lowest NoFile limit of a job, the runner needs a few file descriptors to start
+// the command of the job
+const MinNoFile = 8
+
+// ErrInvalidRlimits is returned by StartJob when the rlimits of the job are out of range
+var ErrInvalidRlimits = errors.New("invalid rlimits")
+
+// Rlimits are the limits of the resources of every process of a job, applied with setrlimit(2)
+// right before the command is run. They're enforced by the kernel independently of cgroups, so
+// they also work on hosts without cgroup delegation. Both the soft and the hard limit are set to
+// the value, a nil limit keeps the limit of the runner. The command can't raise them unless it's
+// privileged, they're a guardrail rather than a security boundary.
+type Rlimits struct {
+	NoFile   *uint64 `json:"nofile,omitempty" yaml:"nofile"` // maximum number of open files, at least MinNoFile
+	NProc    *uint64 `json:"nproc,omitempty" yaml:"nproc"`   // maximum number of processes of the user of the job, not enforced for root
+	FileSize *uint64 `json:"fsize,omitempty" yaml:"fsize"`   // maximum size of a file written by the job in bytes
+	Core     *uint64 `json:"core,omitempty" yaml:"core"`     // maximum size of a core dump in bytes, 0 disables core dumps
+}
+
+// override returns r with the limits that are set in o replaced
+func (r Rlimits) override(o Rlimits) Rlimits {
+	if o.NoFile != nil {
+		r.NoFile = o.NoFile
+	}
+	if o.NProc != nil {
+		r.NProc = o.NProc
+	}
+	if o.FileSize != nil {
+		r.FileSize = o.FileSize
+	}
+	if o.Core != nil {
+		r.Core = o.Core
+	}
+	return r
+}
+
+// validate returns ErrInvalidRlimits if the limits can't be applied to a job
+func (r Rlimits) validate() error {
+	if r.NoFile != nil && *r.NoFile < MinNoFile {
+		return fmt.Errorf("%w: nofile %d is below %d", ErrInvalidRlimits, *r.NoFile, MinNoFile)
+	}
+	return nil
+}
+
+// rlimit is a limit of Rlimits that's set
+type rlimit struct {
+	name     string // name of the limit in profile files
+	resource int    // setrlimit(2) resource of the limit
+	value    uint64
+}
+
+// set returns the limits that are set
+func (r Rlimits) set() []rlimit {
+	var set []rlimit
+	for _, l := range []struct {
+		name     string
+		resource int
+		value    *uint64
+	}{
+		{"nofile", unix.RLIMIT_NOFILE, r.NoFile},
+		{"nproc", unix.RLIMIT_NPROC, r.NProc},
+		{"fsize", unix.RLIMIT_FSIZE, r.FileSize},
+		{"core", unix.RLIMIT_CORE, r.Core},
+	} {
+		if l.value != nil {
+			set = append(set, rlimit{name: l.name, resource: l.resource, value: *l.value})
+		}
+	}
+	return set
+}
+
+// setRlimits applies the limits to the current process, they're inherited by the processes it
+// starts. syscall.Setrlimit is used since newer Go runtimes restore the NOFILE limit they raised
+// at startup in the processes they start, unless it's set through the syscall package.
+func setRlimits(r Rlimits) error {
+	for _, l := range r.set() {
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			return fmt.Errorf("failed to set %s limit to %d: %w", l.name, l.value, err)
+		}
+	}
+	return nil
+}
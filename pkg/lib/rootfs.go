@@ -1,13 +1,106 @@
 package lib
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
-func rootFSSetup(newRoot string) error {
+// Mount represents a host directory that's bind mounted into the root filesystem of a job
+type Mount struct {
+	Source string // absolute path to the directory on the host
+	Target string // absolute path where the directory is mounted in the job's root filesystem
+}
+
+// validateMounts makes sure that the mounts have absolute and clean paths and that they don't
+// replace the root filesystem
+func validateMounts(mounts []Mount) error {
+	for _, m := range mounts {
+		if !filepath.IsAbs(m.Source) {
+			return fmt.Errorf("mount source %q isn't an absolute path", m.Source)
+		}
+		if !filepath.IsAbs(m.Target) || filepath.Clean(m.Target) != m.Target || m.Target == "/" {
+			return fmt.Errorf("invalid mount target %q", m.Target)
+		}
+	}
+	return nil
+}
+
+// openMountPoint opens the mount point of target in root with O_PATH, creating the directory or
+// the empty file that source is bind mounted onto, depending on whether source is a directory.
+//
+// Volumes and the root filesystem can be written by jobs, so the path is resolved one component
+// at a time without following symlinks. Otherwise a job could leave a symlink behind that makes a
+// later job create or mount over paths on the host. The mount point must be mounted on through
+// /proc/self/fd of the returned file, so that the path isn't resolved again.
+func openMountPoint(source, root, target string) (*os.File, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", source, err)
+	}
+
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", root, err)
+	}
+
+	components := strings.Split(strings.TrimPrefix(target, "/"), "/")
+	for i, name := range components {
+		last := i == len(components)-1
+		if last && !fi.IsDir() {
+			// the file is only created if it doesn't exist, O_EXCL doesn't follow symlinks
+			err = createFileAt(fd, name)
+		} else {
+			err = unix.Mkdirat(fd, name, 0755)
+		}
+		if err != nil && !errors.Is(err, unix.EEXIST) {
+			_ = unix.Close(fd)
+			return nil, fmt.Errorf("failed to create %s in %s: %w", name, root, err)
+		}
+
+		next, err := openNoFollow(fd, name)
+		_ = unix.Close(fd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s in %s: %w", strings.Join(components[:i+1], "/"), root, err)
+		}
+		fd = next
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root, target)), nil
+}
+
+// openNoFollow opens name in the directory dirfd with O_PATH. Symlinks are rejected.
+func openNoFollow(dirfd int, name string) (int, error) {
+	fd, err := unix.Openat(dirfd, name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		_ = unix.Close(fd)
+		return -1, err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		_ = unix.Close(fd)
+		return -1, fmt.Errorf("%s is a symlink", name)
+	}
+	return fd, nil
+}
+
+// createFileAt creates the empty file name in the directory dirfd
+func createFileAt(dirfd int, name string) error {
+	fd, err := unix.Openat(dirfd, name, unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_RDONLY|unix.O_CLOEXEC, 0644)
+	if err != nil {
+		return err
+	}
+	return unix.Close(fd)
+}
+
+func rootFSSetup(newRoot string, mounts []Mount) error {
 	putOld := "/old_root"
 	putOldAbsPath := filepath.Join(newRoot, putOld)
 
@@ -16,6 +109,20 @@ func rootFSSetup(newRoot string) error {
 		return fmt.Errorf("failed to mount new root filesystem %s: %w", newRoot, err)
 	}
 
+	// Bind mount the host directories and files into the new root filesystem, they're carried over
+	// by pivot_root
+	for _, m := range mounts {
+		target, err := openMountPoint(m.Source, newRoot, m.Target)
+		if err != nil {
+			return err
+		}
+		err = syscall.Mount(m.Source, fmt.Sprintf("/proc/self/fd/%d", target.Fd()), "", syscall.MS_BIND|syscall.MS_REC, "")
+		_ = target.Close()
+		if err != nil {
+			return fmt.Errorf("failed to mount %s at %s: %w", m.Source, m.Target, err)
+		}
+	}
+
 	// Create putOld directory if it doesn't exist already
 	if err := os.MkdirAll(putOldAbsPath, 0700); err != nil {
 		return fmt.Errorf("failed to mkdir %s: %w", putOldAbsPath, err)
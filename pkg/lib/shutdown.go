@@ -0,0 +1,56 @@
+package lib
+
+import "sync"
+
+// jobs are the jobs started by the current process whose files haven't been removed yet
+var jobs = struct {
+	sync.Mutex
+	m map[*job]struct{}
+}{m: make(map[*job]struct{})}
+
+// track adds j to jobs once it's launched or scheduled. A job that's still starting isn't tracked,
+// stopping it would race with its start.
+func track(j *job) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	jobs.m[j] = struct{}{}
+}
+
+// untrack removes j from jobs once its files are removed
+func untrack(j *job) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	delete(jobs.m, j)
+}
+
+// Shutdown stops all the jobs started by the current process and removes their files, including
+// their root filesystems and cgroups, so that nothing is left behind under RunnerHome. It's meant
+// for tests and programs that embed the lib and need a deterministic teardown. Jobs started while
+// Shutdown is running may be left behind. The first error of removing files is returned, the files
+// of the other jobs are removed regardless.
+func Shutdown() error {
+	jobs.Lock()
+	all := make([]*job, 0, len(jobs.m))
+	for j := range jobs.m {
+		all = append(all, j)
+	}
+	jobs.Unlock()
+
+	var wg sync.WaitGroup
+	for _, j := range all {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			j.Stop()
+		}(j)
+	}
+	wg.Wait()
+
+	var err error
+	for _, j := range all {
+		if rerr := j.RemoveFiles(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
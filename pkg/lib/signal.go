@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Signals are the signals that can be sent to a job with Signal. Signals that stop or kill a job
+// are left to Stop and Pause, so that the status of the job reflects them.
+var Signals = []syscall.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// ErrInvalidSignal is returned by Signal when the signal isn't one of Signals
+var ErrInvalidSignal = errors.New("signal can't be sent to a job")
+
+// validSignal returns true if sig is one of Signals
+func validSignal(sig syscall.Signal) bool {
+	for _, s := range Signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// Signal sends sig to all the processes of the job
+func (j *job) Signal(sig syscall.Signal) error {
+	if !validSignal(sig) {
+		return fmt.Errorf("%w: %s", ErrInvalidSignal, sig)
+	}
+
+	// Serialized with kill, so that the signal isn't sent to a process group that's gone
+	j.pauseLock.Lock()
+	defer j.pauseLock.Unlock()
+
+	if status := j.status.Get(); status != StatusRunning && status != StatusPaused {
+		return ErrNotRunning
+	}
+
+	debugLog("Sending %s to %s", sig, j)
+	if err := syscall.Kill(-j.cmd.Process.Pid, sig); err != nil {
+		return fmt.Errorf("failed to send %s to the job: %w", sig, err)
+	}
+	return nil
+}
+
+// shieldHandler keeps the handler of a job alive when Signals are sent to the process group of
+// the job, only the command and its child processes act on them. The Go runtime exits on most of
+// Signals by default, which would take the whole job down with the handler.
+func shieldHandler() {
+	sigs := make([]os.Signal, 0, len(Signals))
+	for _, sig := range Signals {
+		sigs = append(sigs, sig)
+	}
+	// The signals are dropped once the channel is full, nobody reads them
+	signal.Notify(make(chan os.Signal, 1), sigs...)
+}
@@ -17,6 +17,18 @@ func (s JobStatus) String() string {
 		return "TIMEDOUT"
 	case StatusStopped:
 		return "STOPPED"
+	case StatusKilled:
+		return "KILLED"
+	case StatusPaused:
+		return "PAUSED"
+	case StatusFailed:
+		return "FAILED"
+	case StatusScheduled:
+		return "SCHEDULED"
+	case StatusQueued:
+		return "QUEUED"
+	case StatusInactive:
+		return "INACTIVE"
 	}
 	return "UNKNOWN"
 }
@@ -32,8 +44,31 @@ func (s JobStatus) String() string {
 	StatusStopped
 	// StatusTimedOut denotes a job that was killed due to timeout expiration
 	StatusTimedOut
+	// StatusKilled denotes a job whose process was killed by a signal not sent by the library,
+	// e.g. by the OOM killer
+	StatusKilled
+	// StatusPaused denotes a running job whose processes are frozen till it's resumed
+	StatusPaused
+	// StatusFailed denotes a job that couldn't be set up to run its command
+	StatusFailed
+	// StatusScheduled denotes a job that waits for its start time to be launched
+	StatusScheduled
+	// StatusQueued denotes a job that waits for a slot of its queue to be launched
+	StatusQueued
+	// StatusInactive denotes a job that was killed because it produced no output for its
+	// inactivity timeout
+	StatusInactive
 )
 
+// Terminal returns true if the job can't change its status anymore
+func (s JobStatus) Terminal() bool {
+	switch s {
+	case StatusCompleted, StatusStopped, StatusTimedOut, StatusKilled, StatusFailed, StatusInactive:
+		return true
+	}
+	return false
+}
+
 // safeJobStatus provides a safer way to use JobStatus protecting it with a lock
 type safeJobStatus struct {
 	value JobStatus
@@ -56,12 +91,15 @@ func (s *safeJobStatus) Get() JobStatus {
 	return s.value
 }
 
-// UpdateIf updates the JobStatus to new value only if the existing value is set to old
-func (s *safeJobStatus) UpdateIf(old JobStatus, new JobStatus) {
+// UpdateIf updates the JobStatus to new value only if the existing value is set to old. It returns
+// true if the value was updated.
+func (s *safeJobStatus) UpdateIf(old JobStatus, new JobStatus) bool {
 	s.Lock()
 	defer s.Unlock()
 
 	if s.value == old {
 		s.value = new
+		return true
 	}
+	return false
 }
@@ -0,0 +1,70 @@
+package lib
+
+import "sync"
+
+// statusUpdatesBufSize is the number of status updates buffered for each subscriber
+const statusUpdatesBufSize = 16
+
+// statusSubscribers fans out the status changes of a job to its subscribers. The zero value is
+// ready to use.
+type statusSubscribers struct {
+	subs   map[chan JobStatus]struct{}
+	closed bool // no more status changes are published once closed
+	sync.Mutex
+}
+
+// subscribe returns a channel that receives the published status changes and a function to
+// unsubscribe. The channel is closed once the subscribers are closed or on unsubscribe.
+func (s *statusSubscribers) subscribe() (<-chan JobStatus, func()) {
+	s.Lock()
+	defer s.Unlock()
+
+	ch := make(chan JobStatus, statusUpdatesBufSize)
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if s.subs == nil {
+		s.subs = make(map[chan JobStatus]struct{})
+	}
+	s.subs[ch] = struct{}{}
+
+	unsubscribe := func() {
+		s.Lock()
+		defer s.Unlock()
+
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// publish sends status to all the subscribers. The status is dropped for subscribers that don't
+// keep up, publishing never blocks.
+func (s *statusSubscribers) publish(status JobStatus) {
+	s.Lock()
+	defer s.Unlock()
+
+	for ch := range s.subs {
+		select {
+		case ch <- status:
+		default:
+			debugLog("Dropping status update %s for a slow subscriber", status)
+		}
+	}
+}
+
+// close closes the channels of all the subscribers
+func (s *statusSubscribers) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for ch := range s.subs {
+		close(ch)
+	}
+	s.subs = nil
+	s.closed = true
+}
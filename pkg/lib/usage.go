@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Usage represents the resources used by a job. It's accounted by the cgroup of the job if the job
+// has one, so that processes that weren't waited for, e.g. daemons killed with the job, are
+// included. MaxRSS is the peak memory usage of the cgroup then, which includes the page cache, if
+// the kernel tracks it. Usage is accounted by the rusage of the processes that were waited for
+// otherwise.
+type Usage struct {
+	UserTime   time.Duration // CPU time spent in user mode
+	SystemTime time.Duration // CPU time spent in kernel mode
+	MaxRSS     int64         // Maximum resident set size in bytes
+}
+
+// LiveUsage represents the current resource usage of a running job, as accounted by its cgroup
+type LiveUsage struct {
+	CPUTime       time.Duration // CPU time consumed by all the processes of the job so far
+	MemoryCurrent int64         // Memory currently used by the job in bytes
+}
+
+// usageFromProcessState returns the resources used by a process and all of its children that
+// were waited for
+func usageFromProcessState(ps *os.ProcessState) Usage {
+	rusage, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return Usage{}
+	}
+
+	return Usage{
+		UserTime:   ps.UserTime(),
+		SystemTime: ps.SystemTime(),
+		// ru_maxrss is in kilobytes on Linux
+		MaxRSS: rusage.Maxrss * 1024,
+	}
+}
+
+// finalUsage returns the resources used by the job once its processes are gone. The usage
+// accounted by the cgroup of the job is preferred, the rusage of the process of the job is used
+// for what the cgroup doesn't track.
+func (j *job) finalUsage() Usage {
+	usage := usageFromProcessState(j.cmd.ProcessState)
+	if j.cgroup == nil {
+		return usage
+	}
+
+	cgUsage, err := j.cgroup.Usage()
+	if err != nil {
+		debugLog("Failed to get usage of %s from its cgroup: %v", j, err)
+		return usage
+	}
+	usage.UserTime, usage.SystemTime = cgUsage.UserTime, cgUsage.SystemTime
+	if cgUsage.MaxRSS > 0 {
+		usage.MaxRSS = cgUsage.MaxRSS
+	}
+	return usage
+}